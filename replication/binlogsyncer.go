@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"strconv"
@@ -75,9 +76,101 @@ type BinlogSyncerConfig struct {
 	// strings obtained from MySQL.
 	TimestampStringLocation *time.Location
 
+	// If ParseTime is false, render both TIMESTAMP and DATETIME strings in this
+	// specified timezone, so all temporal strings are consistent. DATETIME values
+	// are regarded as UTC. It takes precedence over TimestampStringLocation.
+	//
+	// Zero dates and DATETIME values before 1970-01-01, which are formatted from
+	// their stored components, are not converted.
+	TemporalStringLocation *time.Location
+
 	// Use decimal.Decimal structure for decimals.
 	UseDecimal bool
 
+	// Use []byte for BIT columns, holding exactly (M+7)/8 big-endian bytes, so a
+	// BIT(64) value with the top bit set is not reinterpreted as a negative int64.
+	BitAsBytes bool
+
+	// Emit JSON object keys in the order MySQL stores them (sorted by key length,
+	// then key bytes) instead of the default lexicographic order.
+	JSONStoredKeyOrder bool
+
+	// Decode JSON columns to Go values instead of the JSON text: map[string]interface{},
+	// []interface{}, string, bool, nil for the JSON null, float64 for the doubles and
+	// int64 or uint64 for the integers, keeping the exact value of the integers above
+	// 2^53. Decimals are decoded like the values of the JSON text, see UseDecimal.
+	// With ParseTime, DATE/DATETIME/TIMESTAMP values are time.Time in UTC, except the
+	// zero dates which are kept as strings. Partial updates are still decoded to *JsonDiff.
+	JSONAsNative bool
+
+	// Use PartialDate for DATE and DATETIME values with a zero month or day,
+	// e.g. '2024-06-00', which can't be represented by time.Time. Without it,
+	// such DATETIME values after 1970 are normalized, e.g. to '2024-05-31'.
+	UsePartialDate bool
+
+	// Use MySQLTime for TIME values instead of a string like "-838:59:59", to get
+	// the sign and the components without parsing.
+	UseMySQLTime bool
+
+	// Use TimeWithDec instead of time.Time for the DATETIME and TIMESTAMP values parsed
+	// with ParseTime, to keep the fractional seconds precision of the column. UseMySQLTime
+	// keeps it for TIME values.
+	UseTimeWithDec bool
+
+	// Convert CHAR/VARCHAR values to UTF-8 from the charset of the column collation,
+	// see TableMapEvent.CollationMap. Invalid sequences are replaced by U+FFFD, which
+	// is also done if the collation isn't logged (binlog_row_metadata is not FULL).
+	// BINARY/VARBINARY values are not changed. With EnumSetWithLabels, the labels are
+	// converted from the charset of the enum/set column too.
+	TranscodeToUTF8 bool
+
+	// The charset of the server, e.g. "latin1", that TranscodeToUTF8 converts from for
+	// the columns whose collation isn't logged (binlog_row_metadata is not FULL).
+	// The collation of a column takes precedence when it is logged. If it is empty or
+	// unknown, the values are only made valid UTF-8.
+	DefaultCharset string
+
+	// Decode enum and set columns as EnumValue and SetValue, which carry both the
+	// index/mask and the labels, instead of int64. The labels are empty if the
+	// values are not logged (binlog_row_metadata is not FULL).
+	EnumSetWithLabels bool
+
+	// Remove the trailing spaces of the labels of EnumValue and SetValue, like SELECT
+	// returns them. TableMapEvent.EnumStrValue and SetStrValue still have the labels
+	// as logged.
+	TrimEnumSetLabels bool
+
+	// Decode BINARY and VARBINARY values to []byte instead of string, which is
+	// decided by the binary collation of the column (see TableMapEvent.CollationMap),
+	// so it requires binlog_row_metadata=FULL. Note that MySQL strips the trailing
+	// 0x00 padding of BINARY values in the binlog.
+	BinaryAsBytes bool
+
+	// Decode the values of BLOB columns and, like BinaryAsBytes, of BINARY and VARBINARY
+	// columns to lowercase hex strings instead of []byte or string. TEXT columns are
+	// not changed if their collation is known. It takes precedence over BinaryAsBytes.
+	BinaryAsHex bool
+
+	// Use uppercase hex strings with BinaryAsHex, like HEX() returns them.
+	BinaryAsUpperHex bool
+
+	// Limit the number of row images decoded from a rows event, so that a corrupted
+	// or malicious event can't exhaust the memory. The event fails with ErrTooManyRows
+	// if it has more rows. 0 means unlimited.
+	MaxRows int
+
+	// Record the error of a row image that fails to decode in RowsEvent.RowErrors
+	// and continue with the next row, instead of failing the whole event. The row
+	// of the failed image in RowsEvent.Rows is nil. The event still fails if the
+	// length of the image can't be found, e.g. for a truncated or corrupted event.
+	ContinueOnRowError bool
+
+	// Decode the rows of events with at least this many row images (an update has
+	// two per row) concurrently, using up to GOMAXPROCS goroutines. The order of the
+	// rows is kept. Events with a partial JSON update or decoded with BlobDecodeFunc
+	// are always decoded sequentially. 0 disables it.
+	ParallelDecodeMinRows int
+
 	// RecvBufferSize sets the size in bytes of the operating system's receive buffer associated with the connection.
 	RecvBufferSize int
 
@@ -121,8 +214,42 @@ type BinlogSyncerConfig struct {
 
 	RowsEventDecodeFunc func(*RowsEvent, []byte) error
 
+	// TableMapOptionalMetaDecodeFunc replaces the decoding of the optional metadata
+	// of table map events, see TableMapEvent.SetOptionalMetaDecodeFunc.
 	TableMapOptionalMetaDecodeFunc func([]byte) error
 
+	// EnumSetLabelsFunc gives the values of the enum and set columns, e.g. from a known
+	// schema, for EnumSetWithLabels when they are not logged (binlog_row_metadata is not
+	// FULL). The values logged take precedence, see TableMapEvent.SetEnumSetLabelsFunc.
+	EnumSetLabelsFunc func(schema, table string, column int) []string
+
+	// UnknownTypeDecodeFunc decodes column types not supported by the library,
+	// returning the value and the number of bytes consumed.
+	UnknownTypeDecodeFunc func(tp byte, meta uint16, data []byte) (interface{}, int, error)
+
+	// LegacyDecimalLengthFunc returns the field length of a legacy DECIMAL column of
+	// MySQL 4.1 or older, e.g. M+2 for a DECIMAL(M,D) with D > 0 and M+1 otherwise,
+	// which must be taken from the schema as it is not logged. The values are then
+	// decoded as strings, or decimal.Decimal with UseDecimal. A length <= 0 fails the
+	// decoding. When unset, the values are left to UnknownTypeDecodeFunc.
+	LegacyDecimalLengthFunc func(schema, table string, column int) int
+
+	// BlobDecodeFunc is called for the non-NULL values of BLOB/TEXT columns with a
+	// reader over the value bytes. The returned value, e.g. a reference to where the
+	// bytes were streamed to, is stored in the row instead of the []byte. Note that
+	// the whole event is read in memory before it is decoded, the function only
+	// keeps the rows from referencing the event data.
+	BlobDecodeFunc func(col int, r io.Reader) (interface{}, error)
+
+	// RowsEventPanicHandler is called when decoding the rows of a rows event panics,
+	// e.g. to log the stack with runtime/debug.Stack or keep the raw event. The panic
+	// is still recovered and returned as an error.
+	RowsEventPanicHandler func(recovered interface{}, partialEvent *RowsEvent)
+
+	// TableResolver looks up the table map event of a table id for the rows events,
+	// instead of the table map events cached by the parser. See BinlogParser.SetTableResolver.
+	TableResolver func(tableID uint64) (*TableMapEvent, bool)
+
 	DiscardGTIDSet bool
 
 	EventCacheCount int
@@ -188,10 +315,33 @@ func NewBinlogSyncer(cfg BinlogSyncerConfig) *BinlogSyncer {
 	b.parser.SetRawMode(b.cfg.RawModeEnabled)
 	b.parser.SetParseTime(b.cfg.ParseTime)
 	b.parser.SetTimestampStringLocation(b.cfg.TimestampStringLocation)
+	b.parser.SetTemporalStringLocation(b.cfg.TemporalStringLocation)
 	b.parser.SetUseDecimal(b.cfg.UseDecimal)
+	b.parser.SetBitAsBytes(b.cfg.BitAsBytes)
+	b.parser.SetJSONStoredKeyOrder(b.cfg.JSONStoredKeyOrder)
+	b.parser.SetJSONAsNative(b.cfg.JSONAsNative)
+	b.parser.SetUsePartialDate(b.cfg.UsePartialDate)
+	b.parser.SetUseMySQLTime(b.cfg.UseMySQLTime)
+	b.parser.SetUseTimeWithDec(b.cfg.UseTimeWithDec)
+	b.parser.SetTranscodeToUTF8(b.cfg.TranscodeToUTF8)
+	b.parser.SetDefaultCharset(b.cfg.DefaultCharset)
+	b.parser.SetEnumSetWithLabels(b.cfg.EnumSetWithLabels)
+	b.parser.SetTrimEnumSetLabels(b.cfg.TrimEnumSetLabels)
+	b.parser.SetBinaryAsBytes(b.cfg.BinaryAsBytes)
+	b.parser.SetBinaryAsHex(b.cfg.BinaryAsHex)
+	b.parser.SetBinaryAsUpperHex(b.cfg.BinaryAsUpperHex)
+	b.parser.SetMaxRows(b.cfg.MaxRows)
+	b.parser.SetContinueOnRowError(b.cfg.ContinueOnRowError)
+	b.parser.SetParallelDecodeMinRows(b.cfg.ParallelDecodeMinRows)
 	b.parser.SetVerifyChecksum(b.cfg.VerifyChecksum)
 	b.parser.SetRowsEventDecodeFunc(b.cfg.RowsEventDecodeFunc)
 	b.parser.SetTableMapOptionalMetaDecodeFunc(b.cfg.TableMapOptionalMetaDecodeFunc)
+	b.parser.SetEnumSetLabelsFunc(b.cfg.EnumSetLabelsFunc)
+	b.parser.SetUnknownTypeDecodeFunc(b.cfg.UnknownTypeDecodeFunc)
+	b.parser.SetLegacyDecimalLengthFunc(b.cfg.LegacyDecimalLengthFunc)
+	b.parser.SetBlobDecodeFunc(b.cfg.BlobDecodeFunc)
+	b.parser.SetRowsEventPanicHandler(b.cfg.RowsEventPanicHandler)
+	b.parser.SetTableResolver(b.cfg.TableResolver)
 	b.running = false
 	b.ctx, b.cancel = context.WithCancel(context.Background())
 
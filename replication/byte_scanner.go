@@ -0,0 +1,78 @@
+package replication
+
+import (
+	"github.com/pingcap/errors"
+
+	. "github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// byteScanner reads the values of a byte slice in order, checking that each value
+// is within the slice, so that a truncated or corrupted event returns an error
+// instead of panicking.
+type byteScanner struct {
+	data []byte
+	pos  int
+}
+
+func newByteScanner(data []byte) *byteScanner {
+	return &byteScanner{data: data}
+}
+
+// Len returns the number of bytes left.
+func (s *byteScanner) Len() int {
+	return len(s.data) - s.pos
+}
+
+// ReadByte reads one byte.
+func (s *byteScanner) ReadByte() (byte, error) {
+	if s.Len() < 1 {
+		return 0, errors.Errorf("needs 1 byte at pos %d but got 0", s.pos)
+	}
+	b := s.data[s.pos]
+	s.pos++
+	return b, nil
+}
+
+// ReadN reads n bytes, the returned slice refers to the scanned data.
+func (s *byteScanner) ReadN(n int) ([]byte, error) {
+	if n < 0 || n > s.Len() {
+		return nil, errors.Errorf("value needs %d bytes but got %d", n, s.Len())
+	}
+	v := s.data[s.pos : s.pos+n : s.pos+n]
+	s.pos += n
+	return v, nil
+}
+
+// ReadLenEncInt reads a length-encoded integer, see LengthEncodedInt.
+func (s *byteScanner) ReadLenEncInt() (uint64, error) {
+	if s.Len() < 1 {
+		return 0, errors.Errorf("missing length at pos %d", s.pos)
+	}
+	n := 1
+	switch s.data[s.pos] {
+	case 0xfc:
+		n = 3
+	case 0xfd:
+		n = 4
+	case 0xfe:
+		n = 9
+	}
+	if n > s.Len() {
+		return 0, errors.Errorf("length needs %d bytes but got %d", n, s.Len())
+	}
+	v, _, _ := LengthEncodedInt(s.data[s.pos:])
+	s.pos += n
+	return v, nil
+}
+
+// ReadLenEncString reads a length-encoded string, see LengthEncodedString.
+func (s *byteScanner) ReadLenEncString() ([]byte, error) {
+	l, err := s.ReadLenEncInt()
+	if err != nil {
+		return nil, err
+	}
+	if l > uint64(s.Len()) {
+		return nil, errors.Errorf("value needs %d bytes but got %d", l, s.Len())
+	}
+	return s.ReadN(int(l))
+}
@@ -0,0 +1,69 @@
+package replication
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// charsetEncodings maps MySQL charset names to their encodings.
+// utf8, utf8mb3, utf8mb4 and ascii need no transcoding.
+var charsetEncodings = map[string]encoding.Encoding{
+	"latin1":   charmap.Windows1252, // MySQL latin1 is cp1252
+	"latin2":   charmap.ISO8859_2,
+	"latin5":   charmap.ISO8859_9,
+	"latin7":   charmap.ISO8859_13,
+	"greek":    charmap.ISO8859_7,
+	"hebrew":   charmap.ISO8859_8,
+	"cp1250":   charmap.Windows1250,
+	"cp1251":   charmap.Windows1251,
+	"cp1256":   charmap.Windows1256,
+	"cp1257":   charmap.Windows1257,
+	"cp850":    charmap.CodePage850,
+	"cp852":    charmap.CodePage852,
+	"cp866":    charmap.CodePage866,
+	"koi8r":    charmap.KOI8R,
+	"koi8u":    charmap.KOI8U,
+	"macroman": charmap.Macintosh,
+	"gbk":      simplifiedchinese.GBK,
+	"gb2312":   simplifiedchinese.GBK, // GBK is a superset of EUC-CN
+	"gb18030":  simplifiedchinese.GB18030,
+	"big5":     traditionalchinese.Big5,
+	"sjis":     japanese.ShiftJIS,
+	"cp932":    japanese.ShiftJIS,
+	"ujis":     japanese.EUCJP,
+	"eucjpms":  japanese.EUCJP,
+	"euckr":    korean.EUCKR,
+	"ucs2":     unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM),
+	"utf16":    unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM),
+	"utf16le":  unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM),
+}
+
+// toUTF8 converts s in the charset of the collation to UTF-8, or in defaultCharset
+// if the collation isn't logged. Invalid sequences are replaced by utf8.RuneError.
+// If the charset is unknown, s is only validated. Binary strings are returned as is.
+func toUTF8(s string, collation uint64, hasCollation bool, defaultCharset string) string {
+	name := defaultCharset
+	if hasCollation {
+		name, _ = collationCharsetName(collation)
+	}
+	if name == "binary" {
+		return s
+	}
+	if enc, ok := charsetEncodings[name]; ok {
+		if d, err := enc.NewDecoder().String(s); err == nil {
+			s = d
+		}
+	}
+	if utf8.ValidString(s) {
+		return s
+	}
+	return strings.ToValidUTF8(s, string(utf8.RuneError))
+}
@@ -255,3 +255,13 @@ const (
 	ENUM_EXTRA_ROW_INFO_TYPECODE_NDB byte = iota
 	ENUM_EXTRA_ROW_INFO_TYPECODE_PARTITION
 )
+
+// NDB extra row info format and flags, see storage/ndb/plugin/ndb_binlog_extra_row_info.h
+const (
+	NDB_ERIF_FORMAT_V0 byte = 0
+)
+
+const (
+	NDB_ERIF_TRANSID   uint16 = 0x1
+	NDB_ERIF_CFT_FLAGS uint16 = 0x2
+)
@@ -0,0 +1,376 @@
+package replication
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+
+	"github.com/pingcap/errors"
+)
+
+// WKB geometry types, refer https://dev.mysql.com/doc/refman/8.0/en/gis-data-formats.html
+const (
+	wkbPoint              = 1
+	wkbLineString         = 2
+	wkbPolygon            = 3
+	wkbMultiPoint         = 4
+	wkbMultiLineString    = 5
+	wkbMultiPolygon       = 6
+	wkbGeometryCollection = 7
+)
+
+var wkbTypeNames = map[uint32]string{
+	wkbPoint:              "Point",
+	wkbLineString:         "LineString",
+	wkbPolygon:            "Polygon",
+	wkbMultiPoint:         "MultiPoint",
+	wkbMultiLineString:    "MultiLineString",
+	wkbMultiPolygon:       "MultiPolygon",
+	wkbGeometryCollection: "GeometryCollection",
+}
+
+// wkbTypeName returns the name of the WKB geometry type tp, with the Z, M or ZM
+// suffix of the ISO WKB types with more dimensions.
+func wkbTypeName(tp uint32) string {
+	name, ok := wkbTypeNames[tp%1000]
+	if !ok {
+		return "Unknown(" + strconv.FormatUint(uint64(tp), 10) + ")"
+	}
+	switch tp / 1000 {
+	case 0:
+		return name
+	case 1:
+		return name + "Z"
+	case 2:
+		return name + "M"
+	case 3:
+		return name + "ZM"
+	default:
+		return "Unknown(" + strconv.FormatUint(uint64(tp), 10) + ")"
+	}
+}
+
+// GeometryDimension is the coordinate dimension of a geometry.
+type GeometryDimension int
+
+const (
+	// GeometryXY is a 2D geometry, the only one MySQL and MariaDB store.
+	GeometryXY GeometryDimension = iota
+	// GeometryXYZ is a 3D geometry with Z coordinates.
+	GeometryXYZ
+	// GeometryXYM is a 2D geometry with M measures.
+	GeometryXYM
+	// GeometryXYZM is a 3D geometry with Z coordinates and M measures.
+	GeometryXYZM
+)
+
+func (d GeometryDimension) String() string {
+	switch d {
+	case GeometryXY:
+		return "XY"
+	case GeometryXYZ:
+		return "XYZ"
+	case GeometryXYM:
+		return "XYM"
+	case GeometryXYZM:
+		return "XYZM"
+	default:
+		return "GeometryDimension(" + strconv.Itoa(int(d)) + ")"
+	}
+}
+
+// Coordinates returns the number of coordinates of each point, 2 to 4.
+func (d GeometryDimension) Coordinates() int {
+	switch d {
+	case GeometryXYZ, GeometryXYM:
+		return 3
+	case GeometryXYZM:
+		return 4
+	default:
+		return 2
+	}
+}
+
+// the flags of the EWKB geometry types with more dimensions
+const (
+	ewkbZFlag = 0x80000000
+	ewkbMFlag = 0x40000000
+)
+
+// GeometryDimensionOf returns the dimension of the value of a GEOMETRY column, the
+// 4 bytes little-endian SRID followed by the WKB, from the type of its outermost
+// geometry. Both the ISO WKB types, e.g. 1001 for a Point Z, and the EWKB Z and M
+// flags are recognized. It can be used for the columns whose TableMapEvent.GeometryDimension
+// is not available.
+func GeometryDimensionOf(data []byte) (GeometryDimension, error) {
+	if len(data) < 4 {
+		return 0, errors.Errorf("geometry needs at least 4 bytes of SRID but got %d", len(data))
+	}
+	r := &wkbReader{data: data[4:]}
+	_, tp, err := r.readHeader()
+	if err != nil {
+		return 0, err
+	}
+
+	var d GeometryDimension
+	if tp&ewkbZFlag != 0 {
+		d |= GeometryXYZ
+	}
+	if tp&ewkbMFlag != 0 {
+		d |= GeometryXYM
+	}
+	tp &^= ewkbZFlag | ewkbMFlag
+	if _, ok := wkbTypeNames[tp%1000]; !ok || tp/1000 > 3 || (d != GeometryXY && tp >= 1000) {
+		return 0, errors.Errorf("unsupported geometry type %s", wkbTypeName(tp))
+	}
+	// 1000 is Z, 2000 is M and 3000 is ZM, the same bits as GeometryDimension
+	return d | GeometryDimension(tp/1000), nil
+}
+
+// GeometryAxisOrder is the order in which the coordinates of a point are written.
+type GeometryAxisOrder int
+
+const (
+	// GeometryAxisOrderStored writes the coordinates in the order they are stored,
+	// X then Y. MySQL stores the coordinates of a geographic SRS as longitude then
+	// latitude whatever the axis order of the SRS, which is also the GeoJSON order.
+	GeometryAxisOrderStored GeometryAxisOrder = iota
+	// GeometryAxisOrderSwapped writes Y then X, e.g. latitude then longitude as
+	// defined by EPSG:4326.
+	GeometryAxisOrderSwapped
+)
+
+// GeometryAxisOrderFunc returns the axis order to write the coordinates of a
+// geometry with SRID srid in.
+type GeometryAxisOrderFunc func(srid uint32) GeometryAxisOrder
+
+// wkbReader reads the WKB of a geometry, every nested geometry has its own byte order.
+type wkbReader struct {
+	data []byte
+	pos  int
+	// swapXY writes the Y coordinate of every point first
+	swapXY bool
+}
+
+func (r *wkbReader) readUint32(order binary.ByteOrder) (uint32, error) {
+	if len(r.data)-r.pos < 4 {
+		return 0, errors.Errorf("geometry is truncated at offset %d", r.pos)
+	}
+	v := order.Uint32(r.data[r.pos:])
+	r.pos += 4
+	return v, nil
+}
+
+func (r *wkbReader) readCount(order binary.ByteOrder, size int) (int, error) {
+	n, err := r.readUint32(order)
+	if err != nil {
+		return 0, err
+	}
+	// a corrupted count must not allocate much
+	if uint64(n)*uint64(size) > uint64(len(r.data)-r.pos) {
+		return 0, errors.Errorf("geometry count %d at offset %d exceeds the data", n, r.pos-4)
+	}
+	return int(n), nil
+}
+
+func (r *wkbReader) readHeader() (binary.ByteOrder, uint32, error) {
+	if r.pos >= len(r.data) {
+		return nil, 0, errors.Errorf("geometry is truncated at offset %d", r.pos)
+	}
+	var order binary.ByteOrder
+	switch r.data[r.pos] {
+	case 0:
+		order = binary.BigEndian
+	case 1:
+		order = binary.LittleEndian
+	default:
+		return nil, 0, errors.Errorf("invalid WKB byte order %d at offset %d", r.data[r.pos], r.pos)
+	}
+	r.pos++
+	tp, err := r.readUint32(order)
+	return order, tp, err
+}
+
+// appendPoint appends the coordinates of a point as a GeoJSON position.
+func (r *wkbReader) appendPoint(buf []byte, order binary.ByteOrder) ([]byte, error) {
+	if len(r.data)-r.pos < 16 {
+		return nil, errors.Errorf("geometry is truncated at offset %d", r.pos)
+	}
+	xy := [2]float64{
+		math.Float64frombits(order.Uint64(r.data[r.pos:])),
+		math.Float64frombits(order.Uint64(r.data[r.pos+8:])),
+	}
+	r.pos += 16
+	if r.swapXY {
+		xy[0], xy[1] = xy[1], xy[0]
+	}
+	buf = append(buf, '[')
+	for i, f := range xy {
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, errors.Errorf("invalid coordinate %v", f)
+		}
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = strconv.AppendFloat(buf, f, 'g', -1, 64)
+	}
+	return append(buf, ']'), nil
+}
+
+// appendPoints appends the points of a LineString or a ring of a Polygon.
+func (r *wkbReader) appendPoints(buf []byte, order binary.ByteOrder) ([]byte, error) {
+	n, err := r.readCount(order, 16)
+	if err != nil {
+		return nil, err
+	}
+	buf = append(buf, '[')
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		if buf, err = r.appendPoint(buf, order); err != nil {
+			return nil, err
+		}
+	}
+	return append(buf, ']'), nil
+}
+
+func (r *wkbReader) appendRings(buf []byte, order binary.ByteOrder) ([]byte, error) {
+	n, err := r.readCount(order, 4)
+	if err != nil {
+		return nil, err
+	}
+	buf = append(buf, '[')
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		if buf, err = r.appendPoints(buf, order); err != nil {
+			return nil, err
+		}
+	}
+	return append(buf, ']'), nil
+}
+
+// appendMember reads a geometry of type tp, which is a member of a multi geometry
+// of type parent, and appends its GeoJSON coordinates.
+func (r *wkbReader) appendMember(buf []byte, parent, tp uint32) ([]byte, error) {
+	order, memberType, err := r.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	if memberType != tp {
+		return nil, errors.Errorf("invalid %s member type %s", wkbTypeName(parent), wkbTypeName(memberType))
+	}
+	switch tp {
+	case wkbPoint:
+		return r.appendPoint(buf, order)
+	case wkbLineString:
+		return r.appendPoints(buf, order)
+	default:
+		return r.appendRings(buf, order)
+	}
+}
+
+// maxGeometryDepth limits the nesting of geometry collections, so that a corrupted
+// value can't overflow the stack.
+const maxGeometryDepth = 64
+
+// appendGeometry reads a geometry at the given nesting depth, 1 for the outermost
+// one, and appends it as a GeoJSON object, with crs as the last member if it is
+// not empty.
+func (r *wkbReader) appendGeometry(buf []byte, crs string, depth int) ([]byte, error) {
+	if depth > maxGeometryDepth {
+		return nil, errors.Errorf("geometry at offset %d is nested deeper than %d levels", r.pos, maxGeometryDepth)
+	}
+	order, tp, err := r.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	name := wkbTypeName(tp)
+	if tp < wkbPoint || tp > wkbGeometryCollection {
+		return nil, errors.Errorf("unsupported geometry type %s", name)
+	}
+
+	buf = append(buf, `{"type":"`...)
+	buf = append(buf, name...)
+	if tp == wkbGeometryCollection {
+		buf = append(buf, `","geometries":[`...)
+	} else {
+		buf = append(buf, `","coordinates":`...)
+	}
+
+	switch tp {
+	case wkbPoint:
+		buf, err = r.appendPoint(buf, order)
+	case wkbLineString:
+		buf, err = r.appendPoints(buf, order)
+	case wkbPolygon:
+		buf, err = r.appendRings(buf, order)
+	default:
+		// every member has at least a header
+		var n int
+		if n, err = r.readCount(order, 5); err != nil {
+			return nil, err
+		}
+		if tp != wkbGeometryCollection {
+			buf = append(buf, '[')
+		}
+		for i := 0; i < n && err == nil; i++ {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			if tp == wkbGeometryCollection {
+				buf, err = r.appendGeometry(buf, "", depth+1)
+			} else {
+				buf, err = r.appendMember(buf, tp, tp-wkbMultiPoint+wkbPoint)
+			}
+		}
+		buf = append(buf, ']')
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if crs != "" {
+		buf = append(buf, `,"crs":{"type":"name","properties":{"name":"`...)
+		buf = append(buf, crs...)
+		buf = append(buf, `"}}`...)
+	}
+	return append(buf, '}'), nil
+}
+
+// GeometryToGeoJSON converts the value of a GEOMETRY column, the 4 bytes little-endian
+// SRID followed by the WKB, into a GeoJSON geometry object. All the 2D geometry types
+// are supported. A non-zero SRID is given in the crs member as "EPSG:<srid>".
+// The coordinates are written in the order they are stored, X then Y.
+func GeometryToGeoJSON(data []byte) (string, error) {
+	return GeometryToGeoJSONWithAxisOrder(data, nil)
+}
+
+// GeometryToGeoJSONWithAxisOrder is like GeometryToGeoJSON, but writes the coordinates
+// in the axis order axisOrder returns for the SRID of the geometry. A nil axisOrder
+// keeps the stored order.
+func GeometryToGeoJSONWithAxisOrder(data []byte, axisOrder GeometryAxisOrderFunc) (string, error) {
+	if len(data) < 4 {
+		return "", errors.Errorf("geometry needs at least 4 bytes of SRID but got %d", len(data))
+	}
+	srid := binary.LittleEndian.Uint32(data)
+	var crs string
+	if srid != 0 {
+		crs = "EPSG:" + strconv.FormatUint(uint64(srid), 10)
+	}
+
+	r := &wkbReader{data: data[4:]}
+	if axisOrder != nil {
+		r.swapXY = axisOrder(srid) == GeometryAxisOrderSwapped
+	}
+	buf, err := r.appendGeometry(nil, crs, 1)
+	if err != nil {
+		return "", err
+	}
+	if r.pos != len(r.data) {
+		return "", errors.Errorf("geometry has %d bytes after the WKB", len(r.data)-r.pos)
+	}
+	return string(buf), nil
+}
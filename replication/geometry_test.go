@@ -0,0 +1,219 @@
+package replication
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// geometryValue builds a GEOMETRY value with srid and the WKB of values in the
+// given byte order, ints are written as uint32.
+func geometryValue(srid uint32, order binary.ByteOrder, values ...interface{}) []byte {
+	data := make([]byte, 4, 64)
+	binary.LittleEndian.PutUint32(data, srid)
+	var b [8]byte
+	for _, v := range values {
+		switch v := v.(type) {
+		case byte:
+			data = append(data, v)
+		case int:
+			order.PutUint32(b[:], uint32(v))
+			data = append(data, b[:4]...)
+		case float64:
+			order.PutUint64(b[:], math.Float64bits(v))
+			data = append(data, b[:]...)
+		}
+	}
+	return data
+}
+
+func TestGeometryToGeoJSON(t *testing.T) {
+	le, be := binary.LittleEndian, binary.BigEndian
+	testcases := []struct {
+		data     []byte
+		expected string
+	}{
+		// POINT(1 1), the value from the binlog
+		{
+			[]byte("\x00\x00\x00\x00\x01\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\xf0\x3f\x00\x00\x00\x00\x00\x00\xf0\x3f"),
+			`{"type":"Point","coordinates":[1,1]}`,
+		},
+		{
+			geometryValue(4326, le, byte(1), 1, 12.5, -3.25),
+			`{"type":"Point","coordinates":[12.5,-3.25],"crs":{"type":"name","properties":{"name":"EPSG:4326"}}}`,
+		},
+		{
+			geometryValue(0, be, byte(0), 1, 1e21, 0.1),
+			`{"type":"Point","coordinates":[1e+21,0.1]}`,
+		},
+		{
+			geometryValue(0, le, byte(1), 2, 2, 0.0, 0.0, 1.0, 2.0),
+			`{"type":"LineString","coordinates":[[0,0],[1,2]]}`,
+		},
+		// a polygon with a hole
+		{
+			geometryValue(3857, le, byte(1), 3, 2,
+				4, 0.0, 0.0, 4.0, 0.0, 4.0, 4.0, 0.0, 0.0,
+				4, 1.0, 1.0, 2.0, 1.0, 2.0, 2.0, 1.0, 1.0),
+			`{"type":"Polygon","coordinates":[[[0,0],[4,0],[4,4],[0,0]],[[1,1],[2,1],[2,2],[1,1]]],"crs":{"type":"name","properties":{"name":"EPSG:3857"}}}`,
+		},
+		// the members can have another byte order
+		{
+			append(geometryValue(0, le, byte(1), 4, 2, byte(1), 1, 1.0, 2.0),
+				geometryValue(0, be, byte(0), 1, 2.0, 0.0)[4:]...),
+			`{"type":"MultiPoint","coordinates":[[1,2],[2,0]]}`,
+		},
+		{
+			geometryValue(0, le, byte(1), 5, 1, byte(1), 2, 2, 0.0, 0.0, 1.0, 1.0),
+			`{"type":"MultiLineString","coordinates":[[[0,0],[1,1]]]}`,
+		},
+		{
+			geometryValue(0, le, byte(1), 6, 1, byte(1), 3, 1, 3, 0.0, 0.0, 1.0, 0.0, 0.0, 0.0),
+			`{"type":"MultiPolygon","coordinates":[[[[0,0],[1,0],[0,0]]]]}`,
+		},
+		{
+			geometryValue(4326, le, byte(1), 7, 2, byte(1), 1, 1.0, 2.0, byte(1), 7, 0),
+			`{"type":"GeometryCollection","geometries":[{"type":"Point","coordinates":[1,2]},{"type":"GeometryCollection","geometries":[]}],"crs":{"type":"name","properties":{"name":"EPSG:4326"}}}`,
+		},
+	}
+	for _, tc := range testcases {
+		s, err := GeometryToGeoJSON(tc.data)
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, s)
+		require.True(t, json.Valid([]byte(s)), s)
+	}
+}
+
+func TestGeometryToGeoJSONWithAxisOrder(t *testing.T) {
+	le := binary.LittleEndian
+	latLon := func(srid uint32) GeometryAxisOrder {
+		if srid == 4326 {
+			return GeometryAxisOrderSwapped
+		}
+		return GeometryAxisOrderStored
+	}
+
+	point := geometryValue(4326, le, byte(1), wkbPoint, 1.0, 2.0)
+	s, err := GeometryToGeoJSON(point)
+	require.NoError(t, err)
+	require.Equal(t, `{"type":"Point","coordinates":[1,2],"crs":{"type":"name","properties":{"name":"EPSG:4326"}}}`, s)
+	s, err = GeometryToGeoJSONWithAxisOrder(point, nil)
+	require.NoError(t, err)
+	require.Equal(t, `{"type":"Point","coordinates":[1,2],"crs":{"type":"name","properties":{"name":"EPSG:4326"}}}`, s)
+	s, err = GeometryToGeoJSONWithAxisOrder(point, latLon)
+	require.NoError(t, err)
+	require.Equal(t, `{"type":"Point","coordinates":[2,1],"crs":{"type":"name","properties":{"name":"EPSG:4326"}}}`, s)
+
+	// every nested point is swapped
+	collection := geometryValue(4326, le, byte(1), wkbGeometryCollection, 1,
+		byte(1), wkbMultiLineString, 1, byte(1), wkbLineString, 2, 1.0, 2.0, 3.0, 4.0)
+	s, err = GeometryToGeoJSONWithAxisOrder(collection, latLon)
+	require.NoError(t, err)
+	require.Equal(t, `{"type":"GeometryCollection","geometries":[{"type":"MultiLineString","coordinates":[[[2,1],[4,3]]]}],"crs":{"type":"name","properties":{"name":"EPSG:4326"}}}`, s)
+
+	// the policy depends on the SRID
+	s, err = GeometryToGeoJSONWithAxisOrder(geometryValue(0, le, byte(1), wkbPoint, 1.0, 2.0), latLon)
+	require.NoError(t, err)
+	require.Equal(t, `{"type":"Point","coordinates":[1,2]}`, s)
+}
+
+func TestGeometryToGeoJSONInvalid(t *testing.T) {
+	le := binary.LittleEndian
+	testcases := []struct {
+		data   []byte
+		errMsg string
+	}{
+		{[]byte{0, 0}, "at least 4 bytes"},
+		{geometryValue(0, le), "truncated"},
+		{geometryValue(0, le, byte(2), 1, 1.0, 1.0), "invalid WKB byte order 2"},
+		{geometryValue(0, le, byte(1), 1001, 1.0, 1.0, 1.0), "unsupported geometry type PointZ"},
+		{geometryValue(0, le, byte(1), 3002, 1.0, 1.0), "unsupported geometry type LineStringZM"},
+		{geometryValue(0, le, byte(1), 8), "unsupported geometry type Unknown(8)"},
+		{geometryValue(0, le, byte(1), 1, 1.0), "truncated"},
+		{geometryValue(0, le, byte(1), 1, 1.0, math.NaN()), "invalid coordinate"},
+		{geometryValue(0, le, byte(1), 2, 1<<30, 1.0, 1.0), "exceeds the data"},
+		{geometryValue(0, le, byte(1), 4, 1, byte(1), 2, 0), "invalid MultiPoint member type LineString"},
+		{geometryValue(0, le, byte(1), 1, 1.0, 1.0, byte(0)), "1 bytes after the WKB"},
+	}
+	for _, tc := range testcases {
+		_, err := GeometryToGeoJSON(tc.data)
+		require.ErrorContains(t, err, tc.errMsg)
+	}
+
+	// nested geometry collections, the innermost one empty
+	nested := func(depth int) []byte {
+		values := make([]interface{}, 0, 3*depth)
+		for i := 1; i <= depth; i++ {
+			count := 1
+			if i == depth {
+				count = 0
+			}
+			values = append(values, byte(1), wkbGeometryCollection, count)
+		}
+		return geometryValue(0, le, values...)
+	}
+	_, err := GeometryToGeoJSON(nested(maxGeometryDepth))
+	require.NoError(t, err)
+	for _, depth := range []int{maxGeometryDepth + 1, 100000} {
+		_, err = GeometryToGeoJSON(nested(depth))
+		require.ErrorContains(t, err, "nested deeper than 64 levels")
+	}
+}
+
+func TestGeometryDimensionOf(t *testing.T) {
+	le, be := binary.LittleEndian, binary.BigEndian
+	testcases := []struct {
+		data     []byte
+		expected GeometryDimension
+	}{
+		{geometryValue(0, le, byte(1), wkbPoint, 1.0, 2.0), GeometryXY},
+		{geometryValue(4326, be, byte(0), wkbPolygon, 0), GeometryXY},
+		// ISO WKB
+		{geometryValue(0, le, byte(1), 1001, 1.0, 2.0, 3.0), GeometryXYZ},
+		{geometryValue(0, le, byte(1), 2002, 0), GeometryXYM},
+		{geometryValue(0, be, byte(0), 3007, 0), GeometryXYZM},
+		// EWKB
+		{geometryValue(0, le, byte(1), 0x80000001, 1.0, 2.0, 3.0), GeometryXYZ},
+		{geometryValue(0, le, byte(1), 0x40000002, 0), GeometryXYM},
+		{geometryValue(0, le, byte(1), 0xc0000003, 0), GeometryXYZM},
+	}
+	for _, tc := range testcases {
+		d, err := GeometryDimensionOf(tc.data)
+		require.NoError(t, err, "%x", tc.data)
+		require.Equal(t, tc.expected, d, "%x", tc.data)
+	}
+	require.Equal(t, "XYZM", GeometryXYZM.String())
+	require.Equal(t, 3, GeometryXYM.Coordinates())
+
+	for _, data := range [][]byte{
+		nil,
+		geometryValue(0, le),
+		geometryValue(0, le, byte(2), wkbPoint),
+		geometryValue(0, le, byte(1), 8),
+		geometryValue(0, le, byte(1), 4001),
+		geometryValue(0, le, byte(1), 0x80000000|1001),
+	} {
+		_, err := GeometryDimensionOf(data)
+		require.Error(t, err, "%x", data)
+	}
+
+	// CREATE TABLE t (id INT, g POINT), the geometry type is logged with binlog_row_metadata=FULL
+	table := &TableMapEvent{
+		ColumnCount: 2,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_GEOMETRY},
+		ColumnMeta:  []uint16{0, 4},
+	}
+	_, ok := table.GeometryDimension(1)
+	require.False(t, ok)
+	table.GeometryType = []uint64{1}
+	d, ok := table.GeometryDimension(1)
+	require.True(t, ok)
+	require.Equal(t, GeometryXY, d)
+	_, ok = table.GeometryDimension(0)
+	require.False(t, ok)
+}
@@ -1,8 +1,12 @@
 package replication
 
 import (
+	"bytes"
 	"fmt"
 	"math"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/goccy/go-json"
 	"github.com/pingcap/errors"
@@ -99,6 +103,103 @@ func (jd *JsonDiff) String() string {
 	return fmt.Sprintf("json_diff(op:%s path:%s value:%s)", jd.Op, jd.Path, jd.Value)
 }
 
+type jsonPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// JSONPatch renders the diff as a JSON Patch document (RFC 6902) with one operation,
+// e.g. [{"op":"replace","path":"/a/0","value":1}]. Replace and Remove are mapped to
+// "replace" and "remove", Insert is mapped to "add", which for an object member
+// also replaces an existing value unlike JSON_INSERT.
+//
+// The MySQL JSON path is converted to a JSON Pointer (RFC 6901), an error is
+// returned if it contains wildcards or ranges.
+func (jd *JsonDiff) JSONPatch() ([]byte, error) {
+	pointer, err := jsonPathToPointer(jd.Path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	op := jsonPatchOperation{Path: pointer}
+	switch jd.Op {
+	case JsonDiffOperationReplace:
+		op.Op = "replace"
+	case JsonDiffOperationInsert:
+		op.Op = "add"
+	case JsonDiffOperationRemove:
+		op.Op = "remove"
+	default:
+		return nil, errors.Errorf("unknown JSON diff operation %s", jd.Op)
+	}
+	if jd.Op != JsonDiffOperationRemove {
+		op.Value = json.RawMessage(jd.Value)
+	}
+
+	return json.Marshal([]jsonPatchOperation{op})
+}
+
+// jsonPathToPointer converts a MySQL JSON path like $.a."b c"[1] to a JSON Pointer like /a/b c/1.
+func jsonPathToPointer(path string) (string, error) {
+	if !strings.HasPrefix(path, "$") {
+		return "", errors.Errorf("invalid JSON path %q", path)
+	}
+
+	var pointer strings.Builder
+	for i := 1; i < len(path); {
+		var token string
+		switch path[i] {
+		case '.':
+			i++
+			if i < len(path) && path[i] == '"' {
+				// quoted member name, e.g. ."a b"
+				end := i + 1
+				for end < len(path) && path[end] != '"' {
+					if path[end] == '\\' {
+						end++
+					}
+					end++
+				}
+				if end >= len(path) {
+					return "", errors.Errorf("invalid JSON path %q", path)
+				}
+				if err := json.Unmarshal([]byte(path[i:end+1]), &token); err != nil {
+					return "", errors.Errorf("invalid JSON path %q", path)
+				}
+				i = end + 1
+			} else {
+				end := i
+				for end < len(path) && path[end] != '.' && path[end] != '[' {
+					end++
+				}
+				token = path[i:end]
+				i = end
+				if token == "" || token == "*" || strings.HasPrefix(token, "*") {
+					return "", errors.Errorf("unsupported JSON path %q", path)
+				}
+			}
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return "", errors.Errorf("invalid JSON path %q", path)
+			}
+			token = strings.TrimSpace(path[i+1 : i+end])
+			i += end + 1
+			if _, err := strconv.ParseUint(token, 10, 64); err != nil {
+				return "", errors.Errorf("unsupported JSON path %q", path)
+			}
+		default:
+			return "", errors.Errorf("unsupported JSON path %q", path)
+		}
+
+		pointer.WriteByte('/')
+		pointer.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(token))
+	}
+
+	return pointer.String(), nil
+}
+
 func jsonbGetOffsetSize(isSmall bool) int {
 	if isSmall {
 		return jsonbSmallOffsetSize
@@ -125,10 +226,34 @@ func jsonbGetValueEntrySize(isSmall bool) int {
 
 // decodeJsonBinary decodes the JSON binary encoding data and returns
 // the common JSON encoding data.
+//
+// Object keys are emitted in lexicographic order by default. If jsonStoredKeyOrder
+// is set, they are emitted in the order MySQL stores them, which is sorted by key
+// length first and then by key bytes. The original insertion order is not kept by
+// MySQL, so neither ordering can reproduce it.
 func (e *RowsEvent) decodeJsonBinary(data []byte) ([]byte, error) {
+	v, err := e.decodeJsonBinaryValue(data, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(v)
+}
+
+// decodeJsonBinaryValue decodes the JSON binary encoding data to Go values.
+// If native is set, the integers are int64 or uint64 whatever their stored width,
+// the doubles float64 and the objects map[string]interface{}, so they can be used
+// without a type switch on every integer width and without the precision loss of
+// a float64 for the integers above 2^53.
+func (e *RowsEvent) decodeJsonBinaryValue(data []byte, native bool) (interface{}, error) {
+	// the temporal values are only time.Time for native values, the JSON text keeps
+	// their MySQL format
 	d := jsonBinaryDecoder{
 		useDecimal:      e.useDecimal,
 		ignoreDecodeErr: e.ignoreJSONDecodeErr,
+		storedKeyOrder:  e.jsonStoredKeyOrder && !native,
+		parseTime:       e.parseTime && native,
+		native:          native,
 	}
 
 	if d.isDataShort(data, 1) {
@@ -136,17 +261,52 @@ func (e *RowsEvent) decodeJsonBinary(data []byte) ([]byte, error) {
 	}
 
 	v := d.decodeValue(data[0], data[1:])
+	e.stats.Warnings += d.warnings
 	if d.err != nil {
 		return nil, d.err
 	}
 
-	return json.Marshal(v)
+	return v, nil
 }
 
 type jsonBinaryDecoder struct {
 	useDecimal      bool
 	ignoreDecodeErr bool
+	storedKeyOrder  bool
+	parseTime       bool
+	native          bool
 	err             error
+	// warnings counts the ignored decode errors
+	warnings int
+}
+
+// jsonObject is a decoded JSON object that marshals its keys in the stored order.
+type jsonObject struct {
+	keys   []string
+	values []interface{}
+}
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		kb, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(kb)
+		buf.WriteByte(':')
+		vb, err := json.Marshal(o.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(vb)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
 }
 
 func (d *jsonBinaryDecoder) decodeValue(tp byte, data []byte) interface{} {
@@ -166,13 +326,13 @@ func (d *jsonBinaryDecoder) decodeValue(tp byte, data []byte) interface{} {
 	case JSONB_LITERAL:
 		return d.decodeLiteral(data)
 	case JSONB_INT16:
-		return d.decodeInt16(data)
+		return d.nativeInt(d.decodeInt16(data))
 	case JSONB_UINT16:
-		return d.decodeUint16(data)
+		return d.nativeInt(d.decodeUint16(data))
 	case JSONB_INT32:
-		return d.decodeInt32(data)
+		return d.nativeInt(d.decodeInt32(data))
 	case JSONB_UINT32:
-		return d.decodeUint32(data)
+		return d.nativeInt(d.decodeUint32(data))
 	case JSONB_INT64:
 		return d.decodeInt64(data)
 	case JSONB_UINT64:
@@ -206,6 +366,7 @@ func (d *jsonBinaryDecoder) decodeObjectOrArray(data []byte, isSmall bool, isObj
 		// this error and return a dummy value for this column.
 		if d.ignoreDecodeErr {
 			d.err = nil
+			d.warnings++
 		}
 		return nil
 	}
@@ -239,6 +400,12 @@ func (d *jsonBinaryDecoder) decodeObjectOrArray(data []byte, isSmall bool, isObj
 				return nil
 			}
 
+			// Key must be inside the object, the data may go beyond it for a nested object
+			if keyOffset+keyLength > size {
+				d.err = errors.Errorf("invalid key offset %d with length %d, must <= size %d", keyOffset, keyLength, size)
+				return nil
+			}
+
 			if d.isDataShort(data, keyOffset+keyLength) {
 				return nil
 			}
@@ -268,6 +435,12 @@ func (d *jsonBinaryDecoder) decodeObjectOrArray(data []byte, isSmall bool, isObj
 
 		valueOffset := d.decodeCount(data[entryOffset+1:], isSmall)
 
+		// Value must be after the header and inside the object or array
+		if valueOffset < headerSize || valueOffset >= size {
+			d.err = errors.Errorf("invalid value offset %d, must in [%d, %d)", valueOffset, headerSize, size)
+			return nil
+		}
+
 		if d.isDataShort(data, valueOffset) {
 			return nil
 		}
@@ -283,6 +456,10 @@ func (d *jsonBinaryDecoder) decodeObjectOrArray(data []byte, isSmall bool, isObj
 		return values
 	}
 
+	if d.storedKeyOrder {
+		return jsonObject{keys: keys, values: values}
+	}
+
 	m := make(map[string]interface{}, count)
 	for i := 0; i < count; i++ {
 		m[keys[i]] = values[i]
@@ -291,6 +468,24 @@ func (d *jsonBinaryDecoder) decodeObjectOrArray(data []byte, isSmall bool, isObj
 	return m
 }
 
+// nativeInt widens an integer of the stored width to int64 or uint64 if native is set.
+func (d *jsonBinaryDecoder) nativeInt(v interface{}) interface{} {
+	if !d.native {
+		return v
+	}
+	switch v := v.(type) {
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint16:
+		return uint64(v)
+	case uint32:
+		return uint64(v)
+	}
+	return v
+}
+
 func isInlineValue(tp byte, isSmall bool) bool {
 	switch tp {
 	case JSONB_INT16, JSONB_UINT16, JSONB_LITERAL:
@@ -499,6 +694,10 @@ func (d *jsonBinaryDecoder) decodeDateTime(data []byte) interface{} {
 	second := hms % (1 << 6)
 	frac := v % (1 << 24)
 
+	if d.parseTime && month != 0 && day != 0 {
+		return time.Date(int(year), time.Month(month), int(day), int(hour), int(minute), int(second), int(frac*1000), time.UTC)
+	}
+
 	return fmt.Sprintf("%04d-%02d-%02d %02d:%02d:%02d.%06d", year, month, day, hour, minute, second, frac)
 }
 
@@ -508,7 +707,13 @@ func (d *jsonBinaryDecoder) decodeCount(data []byte, isSmall bool) int {
 		return int(v)
 	}
 
-	return int(d.decodeUint32(data))
+	v := d.decodeUint32(data)
+	// int is 32 bits on 32-bit platforms
+	if uint64(v) > math.MaxInt {
+		d.err = errors.Errorf("count %d overflows int", v)
+		return 0
+	}
+	return int(v)
 }
 
 func (d *jsonBinaryDecoder) decodeVariableLength(data []byte) (int, int) {
@@ -530,9 +735,13 @@ func (d *jsonBinaryDecoder) decodeVariableLength(data []byte) (int, int) {
 				d.err = errors.Errorf("variable length %d must <= %d", length, int64(math.MaxUint32))
 				return 0, 0
 			}
+			// int is 32 bits on 32-bit platforms
+			if length > math.MaxInt {
+				d.err = errors.Errorf("variable length %d overflows int", length)
+				return 0, 0
+			}
 
 			pos += 1
-			// TODO: should consider length overflow int here.
 			return int(length), pos
 		}
 	}
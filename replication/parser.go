@@ -7,6 +7,7 @@ import (
 	"hash/crc32"
 	"io"
 	"os"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -28,22 +29,57 @@ type BinlogParser struct {
 
 	tables map[uint64]*TableMapEvent
 
+	// the query of the last RowsQueryEvent or MariadbAnnotateRowsEvent in the current statement
+	rowsQuery []byte
+
+	// set by the caller and given to every following rows event
+	transactionID uint64
+
 	// for rawMode, we only parse FormatDescriptionEvent and RotateEvent
 	rawMode bool
 
 	parseTime               bool
 	timestampStringLocation *time.Location
+	temporalStringLocation  *time.Location
 
 	// used to start/stop processing
 	stopProcessing uint32
 
-	useDecimal          bool
-	ignoreJSONDecodeErr bool
-	verifyChecksum      bool
+	useDecimal            bool
+	bitAsBytes            bool
+	ignoreJSONDecodeErr   bool
+	jsonStoredKeyOrder    bool
+	jsonAsNative          bool
+	usePartialDate        bool
+	useMySQLTime          bool
+	useTimeWithDec        bool
+	transcodeToUTF8       bool
+	defaultCharset        string
+	enumSetWithLabels     bool
+	trimEnumSetLabels     bool
+	binaryAsBytes         bool
+	binaryAsHex           bool
+	binaryAsUpperHex      bool
+	maxRows               int
+	continueOnRowError    bool
+	parallelDecodeMinRows int
+	verifyChecksum        bool
 
 	rowsEventDecodeFunc func(*RowsEvent, []byte) error
 
+	unknownTypeDecodeFunc func(tp byte, meta uint16, data []byte) (interface{}, int, error)
+
+	legacyDecimalLengthFunc func(schema, table string, column int) int
+
+	blobDecodeFunc func(col int, r io.Reader) (interface{}, error)
+
+	rowsEventPanicHandler func(recovered interface{}, partialEvent *RowsEvent)
+
+	tableResolver func(tableID uint64) (*TableMapEvent, bool)
+
 	tableMapOptionalMetaDecodeFunc func([]byte) error
+
+	enumSetLabelsFunc func(schema, table string, column int) []string
 }
 
 func NewBinlogParser() *BinlogParser {
@@ -62,8 +98,28 @@ func (p *BinlogParser) Resume() {
 	atomic.StoreUint32(&p.stopProcessing, 0)
 }
 
+// Reset clears the state of the stream being parsed, e.g. before parsing a new
+// connection: the format description, and the originating query and transaction
+// id given to the next rows events, which belong to an unfinished statement.
 func (p *BinlogParser) Reset() {
 	p.format = nil
+	p.rowsQuery = nil
+	p.transactionID = 0
+}
+
+// KnownTables returns the table map events cached by the parser, keyed by table id.
+// MySQL logs the table map events again for every statement, so the cache only holds
+// those of the current statement and is emptied at its last rows event.
+//
+// The returned map is a copy and can be modified, but the events are shared with the
+// parser and the rows events decoded with them, so they must not be modified.
+// It must not be called concurrently with parsing.
+func (p *BinlogParser) KnownTables() map[uint64]*TableMapEvent {
+	tables := make(map[uint64]*TableMapEvent, len(p.tables))
+	for id, table := range p.tables {
+		tables[id] = table
+	}
+	return tables
 }
 
 type OnEventFunc func(*BinlogEvent) error
@@ -200,10 +256,106 @@ func (p *BinlogParser) SetTimestampStringLocation(timestampStringLocation *time.
 	p.timestampStringLocation = timestampStringLocation
 }
 
+// SetTemporalStringLocation sets the location used to render all TIMESTAMP and DATETIME
+// values as strings when parseTime is false. DATETIME values are regarded as UTC.
+// It takes precedence over the timestamp string location.
+func (p *BinlogParser) SetTemporalStringLocation(temporalStringLocation *time.Location) {
+	p.temporalStringLocation = temporalStringLocation
+}
+
 func (p *BinlogParser) SetUseDecimal(useDecimal bool) {
 	p.useDecimal = useDecimal
 }
 
+// SetUsePartialDate makes DATE and DATETIME values with a zero month or day
+// decode to PartialDate instead of a string.
+func (p *BinlogParser) SetUsePartialDate(usePartialDate bool) {
+	p.usePartialDate = usePartialDate
+}
+
+// SetUseMySQLTime makes TIME values decode to MySQLTime instead of a string.
+func (p *BinlogParser) SetUseMySQLTime(useMySQLTime bool) {
+	p.useMySQLTime = useMySQLTime
+}
+
+// SetUseTimeWithDec makes DATETIME and TIMESTAMP values parsed by ParseTime decode to
+// TimeWithDec instead of time.Time.
+func (p *BinlogParser) SetUseTimeWithDec(useTimeWithDec bool) {
+	p.useTimeWithDec = useTimeWithDec
+}
+
+// SetTranscodeToUTF8 makes string values of character columns be converted to
+// UTF-8 from the charset of the column collation, see BinlogSyncerConfig.TranscodeToUTF8.
+func (p *BinlogParser) SetTranscodeToUTF8(transcodeToUTF8 bool) {
+	p.transcodeToUTF8 = transcodeToUTF8
+}
+
+// SetDefaultCharset sets the charset, e.g. "latin1", that SetTranscodeToUTF8 converts from
+// for the character columns whose collation isn't logged, see BinlogSyncerConfig.DefaultCharset.
+func (p *BinlogParser) SetDefaultCharset(charsetName string) {
+	p.defaultCharset = strings.ToLower(charsetName)
+}
+
+// SetEnumSetWithLabels makes enum and set columns be decoded as EnumValue and SetValue,
+// see BinlogSyncerConfig.EnumSetWithLabels.
+func (p *BinlogParser) SetEnumSetWithLabels(enumSetWithLabels bool) {
+	p.enumSetWithLabels = enumSetWithLabels
+}
+
+// SetTrimEnumSetLabels makes the labels of EnumValue and SetValue be returned without
+// trailing spaces, see BinlogSyncerConfig.TrimEnumSetLabels.
+func (p *BinlogParser) SetTrimEnumSetLabels(trimEnumSetLabels bool) {
+	p.trimEnumSetLabels = trimEnumSetLabels
+}
+
+// SetBinaryAsBytes makes BINARY and VARBINARY columns decode to []byte instead of string,
+// see BinlogSyncerConfig.BinaryAsBytes.
+func (p *BinlogParser) SetBinaryAsBytes(binaryAsBytes bool) {
+	p.binaryAsBytes = binaryAsBytes
+}
+
+// SetBinaryAsHex makes BLOB, BINARY and VARBINARY columns decode to hex strings,
+// see BinlogSyncerConfig.BinaryAsHex.
+func (p *BinlogParser) SetBinaryAsHex(binaryAsHex bool) {
+	p.binaryAsHex = binaryAsHex
+}
+
+// SetBinaryAsUpperHex makes the hex strings of SetBinaryAsHex uppercase.
+func (p *BinlogParser) SetBinaryAsUpperHex(binaryAsUpperHex bool) {
+	p.binaryAsUpperHex = binaryAsUpperHex
+}
+
+// SetMaxRows limits the number of row images decoded from a rows event, 0 means unlimited.
+// Decoding an event with more rows fails with ErrTooManyRows.
+func (p *BinlogParser) SetMaxRows(maxRows int) {
+	p.maxRows = maxRows
+}
+
+// SetContinueOnRowError makes a row image that fails to decode be recorded in
+// RowsEvent.RowErrors instead of failing the whole event, see
+// BinlogSyncerConfig.ContinueOnRowError.
+func (p *BinlogParser) SetContinueOnRowError(continueOnRowError bool) {
+	p.continueOnRowError = continueOnRowError
+}
+
+// SetTransactionID sets RowsEvent.TransactionID of the rows events parsed from now on,
+// so that the rows of the same transaction can be grouped. The caller may set it when
+// it sees the start of a transaction, e.g. a GTIDEvent or a TransactionContextEvent.
+func (p *BinlogParser) SetTransactionID(id uint64) {
+	p.transactionID = id
+}
+
+// SetParallelDecodeMinRows makes the rows of events with at least minRows row images
+// be decoded concurrently, see BinlogSyncerConfig.ParallelDecodeMinRows. 0 disables it.
+func (p *BinlogParser) SetParallelDecodeMinRows(minRows int) {
+	p.parallelDecodeMinRows = minRows
+}
+
+// SetBitAsBytes makes BIT columns decode to their raw big-endian bytes instead of int64.
+func (p *BinlogParser) SetBitAsBytes(bitAsBytes bool) {
+	p.bitAsBytes = bitAsBytes
+}
+
 func (p *BinlogParser) SetIgnoreJSONDecodeError(ignoreJSONDecodeErr bool) {
 	p.ignoreJSONDecodeErr = ignoreJSONDecodeErr
 }
@@ -216,14 +368,74 @@ func (p *BinlogParser) SetFlavor(flavor string) {
 	p.flavor = flavor
 }
 
+// SetJSONStoredKeyOrder makes JSON object keys be emitted in MySQL's stored order
+// (by key length, then key bytes) instead of lexicographic order.
+func (p *BinlogParser) SetJSONStoredKeyOrder(jsonStoredKeyOrder bool) {
+	p.jsonStoredKeyOrder = jsonStoredKeyOrder
+}
+
+// SetJSONAsNative makes JSON columns decode to Go values instead of the JSON text,
+// see BinlogSyncerConfig.JSONAsNative.
+func (p *BinlogParser) SetJSONAsNative(jsonAsNative bool) {
+	p.jsonAsNative = jsonAsNative
+}
+
 func (p *BinlogParser) SetRowsEventDecodeFunc(rowsEventDecodeFunc func(*RowsEvent, []byte) error) {
 	p.rowsEventDecodeFunc = rowsEventDecodeFunc
 }
 
+// SetUnknownTypeDecodeFunc sets a function to decode column types the library doesn't
+// know. It returns the value and the number of bytes consumed from data.
+// When unset, decoding such a column fails.
+func (p *BinlogParser) SetUnknownTypeDecodeFunc(unknownTypeDecodeFunc func(tp byte, meta uint16, data []byte) (interface{}, int, error)) {
+	p.unknownTypeDecodeFunc = unknownTypeDecodeFunc
+}
+
+// SetLegacyDecimalLengthFunc sets a function returning the field length of a legacy
+// DECIMAL (MYSQL_TYPE_DECIMAL) column, which is not logged, so that its values are
+// decoded by DecodeLegacyDecimal. When unset, such columns are left to the
+// unknown type decode function.
+func (p *BinlogParser) SetLegacyDecimalLengthFunc(legacyDecimalLengthFunc func(schema, table string, column int) int) {
+	p.legacyDecimalLengthFunc = legacyDecimalLengthFunc
+}
+
+// SetTableResolver sets the function rows events look up their table map event
+// with, see RowsEvent.SetTableResolver. Table map events are still parsed and
+// passed to the event handler, so the resolver's storage can be filled from there.
+// When unset, the table map events cached by the parser are used.
+func (p *BinlogParser) SetTableResolver(resolver func(tableID uint64) (*TableMapEvent, bool)) {
+	p.tableResolver = resolver
+}
+
+// SetBlobDecodeFunc sets a function which is handed a reader over the value of each
+// non-NULL BLOB/TEXT column, so it can be streamed to external storage. The reader is
+// over the event data, which is already in memory as a whole event is read before it
+// is decoded. The value it returns is stored in the row instead of the bytes, so the
+// rows don't keep references to the event data. When unset, the values are []byte.
+func (p *BinlogParser) SetBlobDecodeFunc(blobDecodeFunc func(col int, r io.Reader) (interface{}, error)) {
+	p.blobDecodeFunc = blobDecodeFunc
+}
+
+// SetRowsEventPanicHandler sets a function which is called when decoding the rows of
+// a rows event panics, with the recovered value and the event holding the rows decoded
+// so far. It's called by the deferred recover, so runtime/debug.Stack still has the
+// stack of the panic. The panic is recovered and returned as an error either way.
+func (p *BinlogParser) SetRowsEventPanicHandler(handler func(recovered interface{}, partialEvent *RowsEvent)) {
+	p.rowsEventPanicHandler = handler
+}
+
+// SetTableMapOptionalMetaDecodeFunc sets the function that decodes the optional
+// metadata of every table map event, see TableMapEvent.SetOptionalMetaDecodeFunc.
 func (p *BinlogParser) SetTableMapOptionalMetaDecodeFunc(tableMapOptionalMetaDecondeFunc func([]byte) error) {
 	p.tableMapOptionalMetaDecodeFunc = tableMapOptionalMetaDecondeFunc
 }
 
+// SetEnumSetLabelsFunc sets the function giving the enum and set values not logged
+// for every table map event, see TableMapEvent.SetEnumSetLabelsFunc.
+func (p *BinlogParser) SetEnumSetLabelsFunc(f func(schema, table string, column int) []string) {
+	p.enumSetLabelsFunc = f
+}
+
 func (p *BinlogParser) parseHeader(data []byte) (*EventHeader, error) {
 	h := new(EventHeader)
 	err := h.Decode(data)
@@ -265,6 +477,7 @@ func (p *BinlogParser) parseEvent(h *EventHeader, data []byte, rawData []byte) (
 				te := &TableMapEvent{
 					flavor:                 p.flavor,
 					optionalMetaDecodeFunc: p.tableMapOptionalMetaDecodeFunc,
+					enumSetLabelsFunc:      p.enumSetLabelsFunc,
 				}
 				if p.format.EventTypeHeaderLengths[TABLE_MAP_EVENT-1] == 6 {
 					te.tableIDSize = 4
@@ -335,10 +548,20 @@ func (p *BinlogParser) parseEvent(h *EventHeader, data []byte, rawData []byte) (
 		p.tables[te.TableID] = te
 	}
 
+	switch ev := e.(type) {
+	case *RowsQueryEvent:
+		p.rowsQuery = ev.Query
+	case *MariadbAnnotateRowsEvent:
+		p.rowsQuery = ev.Query
+	}
+
 	if re, ok := e.(*RowsEvent); ok {
-		if (re.Flags & RowsEventStmtEndFlag) > 0 {
+		re.OriginatingQuery = p.rowsQuery
+		re.TransactionID = p.transactionID
+		if re.IsStatementEnd() {
 			// Refer https://github.com/alibaba/canal/blob/38cc81b7dab29b51371096fb6763ca3a8432ffee/dbsync/src/main/java/com/taobao/tddl/dbsync/binlog/event/RowsLogEvent.java#L176
 			p.tables = make(map[uint64]*TableMapEvent)
+			p.rowsQuery = nil
 		}
 	}
 
@@ -410,14 +633,37 @@ func (p *BinlogParser) newRowsEvent(h *EventHeader) *RowsEvent {
 	e.eventType = h.EventType
 	e.parseTime = p.parseTime
 	e.timestampStringLocation = p.timestampStringLocation
+	e.temporalStringLocation = p.temporalStringLocation
 	e.useDecimal = p.useDecimal
+	e.bitAsBytes = p.bitAsBytes
+	e.unknownTypeDecodeFunc = p.unknownTypeDecodeFunc
+	e.legacyDecimalLengthFunc = p.legacyDecimalLengthFunc
+	e.blobDecodeFunc = p.blobDecodeFunc
+	e.panicHandler = p.rowsEventPanicHandler
+	e.tableResolver = p.tableResolver
 	e.ignoreJSONDecodeErr = p.ignoreJSONDecodeErr
+	e.jsonStoredKeyOrder = p.jsonStoredKeyOrder
+	e.jsonAsNative = p.jsonAsNative
+	e.usePartialDate = p.usePartialDate
+	e.useMySQLTime = p.useMySQLTime
+	e.useTimeWithDec = p.useTimeWithDec
+	e.transcodeToUTF8 = p.transcodeToUTF8
+	e.defaultCharset = p.defaultCharset
+	e.enumSetWithLabels = p.enumSetWithLabels
+	e.trimEnumSetLabels = p.trimEnumSetLabels
+	e.binaryAsBytes = p.binaryAsBytes
+	e.binaryAsHex = p.binaryAsHex
+	e.binaryAsUpperHex = p.binaryAsUpperHex
+	e.maxRows = p.maxRows
+	e.continueOnRowError = p.continueOnRowError
+	e.parallelDecodeMinRows = p.parallelDecodeMinRows
 
 	switch h.EventType {
 	case WRITE_ROWS_EVENTv0:
 		e.Version = 0
 	case UPDATE_ROWS_EVENTv0:
 		e.Version = 0
+		e.needBitmap2 = true
 	case DELETE_ROWS_EVENTv0:
 		e.Version = 0
 	case WRITE_ROWS_EVENTv1:
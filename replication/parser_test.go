@@ -140,3 +140,138 @@ func TestRowsEventDecodeImageWithEmptyJSON(t *testing.T) {
 	require.Equal(t, []byte{}, row[4]) // empty json
 	require.Equal(t, int32(4404), row[7])
 }
+
+func TestRowsEventIsCompressed(t *testing.T) {
+	parser := NewBinlogParser()
+	parser.format = &FormatDescriptionEvent{EventTypeHeaderLengths: make([]byte, 256)}
+
+	e := parser.newRowsEvent(&EventHeader{EventType: WRITE_ROWS_EVENTv2})
+	require.False(t, e.IsCompressed())
+
+	for _, tp := range []EventType{
+		MARIADB_WRITE_ROWS_COMPRESSED_EVENT_V1,
+		MARIADB_UPDATE_ROWS_COMPRESSED_EVENT_V1,
+		MARIADB_DELETE_ROWS_COMPRESSED_EVENT_V1,
+	} {
+		e = parser.newRowsEvent(&EventHeader{EventType: tp})
+		require.True(t, e.IsCompressed())
+	}
+}
+
+func TestRowsEventOriginatingQuery(t *testing.T) {
+	parser := NewBinlogParser()
+	parser.format = &FormatDescriptionEvent{EventTypeHeaderLengths: make([]byte, 256)}
+	// the first byte is used as the flags of the rows event
+	parser.SetRowsEventDecodeFunc(func(re *RowsEvent, data []byte) error {
+		re.Flags = uint16(data[0])
+		return nil
+	})
+
+	parse := func(tp EventType, data string) Event {
+		e, err := parser.parseEvent(&EventHeader{EventType: tp}, []byte(data), nil)
+		require.NoError(t, err)
+		return e
+	}
+	rowsQuery := func(tp EventType, data string) []byte {
+		return parse(tp, data).(*RowsEvent).OriginatingQuery
+	}
+
+	require.Nil(t, rowsQuery(WRITE_ROWS_EVENTv2, "\x01"))
+
+	// a statement with two rows events
+	parse(ROWS_QUERY_EVENT, "\x00INSERT INTO t VALUES (1), (2)")
+	require.Equal(t, []byte("INSERT INTO t VALUES (1), (2)"), rowsQuery(WRITE_ROWS_EVENTv2, "\x00"))
+	require.Equal(t, []byte("INSERT INTO t VALUES (1), (2)"), rowsQuery(WRITE_ROWS_EVENTv2, "\x01"))
+
+	// the query is not carried over to the next statement
+	require.Nil(t, rowsQuery(DELETE_ROWS_EVENTv2, "\x01"))
+
+	parse(MARIADB_ANNOTATE_ROWS_EVENT, "DELETE FROM t")
+	require.Equal(t, []byte("DELETE FROM t"), rowsQuery(DELETE_ROWS_EVENTv1, "\x01"))
+}
+
+func TestKnownTables(t *testing.T) {
+	parser := NewBinlogParser()
+	parser.format = &FormatDescriptionEvent{EventTypeHeaderLengths: make([]byte, 256)}
+	parser.format.EventTypeHeaderLengths[TABLE_MAP_EVENT-1] = 8
+	parser.SetRowsEventDecodeFunc(func(re *RowsEvent, data []byte) error {
+		re.Flags = uint16(data[0])
+		return nil
+	})
+	require.Empty(t, parser.KnownTables())
+
+	// create table _null (c1 int null, c2 int not null default '2', c3 timestamp default now(), c4 text); mysql 8.0
+	data := []byte("z\x00\x00\x00\x00\x00\x01\x00\x04test\x00\x05_null\x00\x04\x03\x03\x11\xfc\x02\x00\x02\t\x01\x01\x00\x02\x01\xe0\x04\f\x02c1\x02c2\x02c3\x02c4")
+	_, err := parser.parseEvent(&EventHeader{EventType: TABLE_MAP_EVENT}, data, nil)
+	require.NoError(t, err)
+
+	tables := parser.KnownTables()
+	require.Len(t, tables, 1)
+	require.Equal(t, []byte("_null"), tables[122].Table)
+
+	// the returned map is a copy
+	delete(tables, 122)
+	require.Len(t, parser.KnownTables(), 1)
+
+	// the cache is emptied at the end of the statement
+	_, err = parser.parseEvent(&EventHeader{EventType: WRITE_ROWS_EVENTv2}, []byte{byte(RowsEventStmtEndFlag)}, nil)
+	require.NoError(t, err)
+	require.Empty(t, parser.KnownTables())
+}
+
+func TestRowsEventTransactionID(t *testing.T) {
+	parser := NewBinlogParser()
+	parser.format = &FormatDescriptionEvent{EventTypeHeaderLengths: make([]byte, 256)}
+	parser.SetRowsEventDecodeFunc(func(re *RowsEvent, data []byte) error {
+		re.Flags = uint16(data[0])
+		return nil
+	})
+
+	parse := func() *RowsEvent {
+		e, err := parser.parseEvent(&EventHeader{EventType: WRITE_ROWS_EVENTv2}, []byte("\x01"), nil)
+		require.NoError(t, err)
+		return e.(*RowsEvent)
+	}
+
+	require.Equal(t, uint64(0), parse().TransactionID)
+
+	parser.SetTransactionID(7)
+	e := parse()
+	require.Equal(t, uint64(7), e.TransactionID)
+	require.Equal(t, uint64(7), parse().TransactionID)
+
+	var buf bytes.Buffer
+	e.Dump(&buf)
+	require.Contains(t, buf.String(), "Transaction ID: 7\n")
+
+	parser.SetTransactionID(8)
+	require.Equal(t, uint64(8), parse().TransactionID)
+}
+
+func TestBinlogParserReset(t *testing.T) {
+	parser := NewBinlogParser()
+	parser.SetRowsEventDecodeFunc(func(re *RowsEvent, data []byte) error {
+		re.Flags = uint16(data[0])
+		return nil
+	})
+	parse := func(tp EventType, data string) Event {
+		e, err := parser.parseEvent(&EventHeader{EventType: tp}, []byte(data), nil)
+		require.NoError(t, err)
+		return e
+	}
+
+	// a reconnect in the middle of a statement
+	parser.format = &FormatDescriptionEvent{EventTypeHeaderLengths: make([]byte, 256)}
+	parser.SetTransactionID(7)
+	parse(ROWS_QUERY_EVENT, "\x00INSERT INTO t VALUES (1), (2)")
+	e := parse(WRITE_ROWS_EVENTv2, "\x00").(*RowsEvent)
+	require.Equal(t, []byte("INSERT INTO t VALUES (1), (2)"), e.OriginatingQuery)
+	require.Equal(t, uint64(7), e.TransactionID)
+
+	parser.Reset()
+	require.Nil(t, parser.format)
+	parser.format = &FormatDescriptionEvent{EventTypeHeaderLengths: make([]byte, 256)}
+	e = parse(WRITE_ROWS_EVENTv2, "\x01").(*RowsEvent)
+	require.Nil(t, e.OriginatingQuery)
+	require.Equal(t, uint64(0), e.TransactionID)
+}
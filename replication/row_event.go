@@ -1,15 +1,26 @@
 package replication
 
 import (
+	"bytes"
+	"database/sql/driver"
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
+	"math/bits"
+	"reflect"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/pkg/parser/charset"
 	"github.com/shopspring/decimal"
 	"github.com/siddontang/go/hack"
 
@@ -18,6 +29,38 @@ import (
 
 var errMissingTableMapEvent = errors.New("invalid table id, no corresponding table map event")
 
+var (
+	// ErrTruncatedDecimal indicates the binary DECIMAL value is shorter than its precision and scale require.
+	ErrTruncatedDecimal = errors.New("truncated decimal value")
+
+	// ErrInvalidDecimalMeta indicates the precision and scale of a DECIMAL column in the
+	// table map event are out of range, e.g. a precision of 0 or a scale greater than
+	// the precision, so the values of the column can't be decoded.
+	ErrInvalidDecimalMeta = errors.New("invalid decimal meta")
+
+	// ErrTruncatedString indicates the length prefix of a CHAR, VARCHAR or BINARY value
+	// claims more bytes than are left in the row data, usually because the event is
+	// corrupted, e.g. a damaged relay log.
+	ErrTruncatedString = errors.New("truncated string value")
+
+	// ErrTableIDSizeMismatch indicates the rows event and its cached table map event were decoded
+	// with different table id sizes, usually because the table map cache outlived a reconnect to a
+	// server with a different configuration. The table map cache should be flushed on reconnect.
+	ErrTableIDSizeMismatch = errors.New("table id size mismatch between rows event and table map event")
+
+	// ErrTooManyRows indicates the rows event has more row images than allowed by
+	// BinlogSyncerConfig.MaxRows. The rows decoded before the limit are kept in the event.
+	ErrTooManyRows = errors.New("too many rows in rows event")
+
+	// ErrRowImageMismatch indicates the row images of a rows event don't line up with the
+	// columns of its table map event, usually because the cached TableMapEvent is stale,
+	// e.g. the table was altered and its new table map event was not seen. Decoding the
+	// event again requires the table map event logged with it. It's found after decoding,
+	// when the row images don't end exactly at the end of the data or reading them goes
+	// out of range, so the rows decoded before are kept in the event.
+	ErrRowImageMismatch = errors.New("row images don't match the table map event")
+)
+
 type TableMapEvent struct {
 	flavor      string
 	tableIDSize int
@@ -71,6 +114,11 @@ type TableMapEvent struct {
 	// GeometryType stores real type for geometry columns.
 	GeometryType []uint64
 
+	// GeometrySRID stores the SRID constraint for geometry columns.
+	// Neither MySQL nor MariaDB log it in the table map event so far, so it is
+	// only available when set by the caller, e.g. from a known schema.
+	GeometrySRID []uint64
+
 	// PrimaryKey is a sequence of column indexes of primary key.
 	PrimaryKey []uint64
 
@@ -85,12 +133,60 @@ type TableMapEvent struct {
 	// VisibilityBitmap stores bits that are set if corresponding column is not invisible (MySQL 8.0.23+)
 	VisibilityBitmap []byte
 
+	// optionalMetaDecodeFunc replaces decodeOptionalMeta when set, see SetOptionalMetaDecodeFunc.
 	optionalMetaDecodeFunc func(data []byte) (err error)
+
+	// enumSetLabelsFunc gives the values of the enum/set columns not logged, see SetEnumSetLabelsFunc.
+	enumSetLabelsFunc func(schema, table string, column int) []string
+
+	collations map[int]uint64   // the same as CollationMap(), just for reuse
+	enumLabels map[int][]string // the same as EnumStrValueMap(), just for reuse
+	setLabels  map[int][]string // the same as SetStrValueMap(), just for reuse
+
+	enumSetCollations map[int]uint64 // the same as EnumSetCollationMap(), just for reuse
+}
+
+// NewTableMapEvent builds a table map event from a known schema, for decoding
+// rows events whose table map event is not available, e.g. when starting in the
+// middle of a statement. columnType and columnMeta have an entry per column and
+// hold the same values as ColumnType and ColumnMeta of a decoded event, e.g.
+// MYSQL_TYPE_NEWDECIMAL with precision<<8 | scale, MYSQL_TYPE_STRING with the real
+// type<<8 | length for ENUM and SET. The event can be supplied with
+// RowsEvent.SetTableResolver or BinlogSyncerConfig.TableResolver.
+//
+// The optional metadata is not available, so there are no column names, enum and
+// set labels, charsets or signedness, and the methods depending on them behave as
+// if the server didn't log them. The null bits are not available either.
+func NewTableMapEvent(tableID uint64, schema, table string, columnType []byte, columnMeta []uint16) (*TableMapEvent, error) {
+	if len(columnType) != len(columnMeta) {
+		return nil, errors.Errorf("column types count %d and column metas count %d mismatch", len(columnType), len(columnMeta))
+	}
+	return &TableMapEvent{
+		TableID:     tableID,
+		Schema:      []byte(schema),
+		Table:       []byte(table),
+		ColumnCount: uint64(len(columnType)),
+		ColumnType:  columnType,
+		ColumnMeta:  columnMeta,
+	}, nil
+}
+
+// DecodeFrom reads exactly size bytes of the event body from r and decodes them.
+func (e *TableMapEvent) DecodeFrom(r io.Reader, size int) error {
+	data, err := readEventBody(r, size)
+	if err != nil {
+		return err
+	}
+	return e.Decode(data)
 }
 
 func (e *TableMapEvent) Decode(data []byte) error {
+	if err := checkTableIDSize(e.tableIDSize, len(data)); err != nil {
+		return err
+	}
+
 	pos := 0
-	e.TableID = FixedLengthInt(data[0:e.tableIDSize])
+	e.TableID = PeekTableID(data, e.tableIDSize)
 	pos += e.tableIDSize
 
 	e.Flags = binary.LittleEndian.Uint16(data[pos:])
@@ -103,6 +199,9 @@ func (e *TableMapEvent) Decode(data []byte) error {
 	pos += int(schemaLength)
 
 	// skip 0x00
+	if err := e.checkNameTerminator(data, pos, "schema"); err != nil {
+		return err
+	}
 	pos++
 
 	tableLength := data[pos]
@@ -112,6 +211,9 @@ func (e *TableMapEvent) Decode(data []byte) error {
 	pos += int(tableLength)
 
 	// skip 0x00
+	if err := e.checkNameTerminator(data, pos, "table"); err != nil {
+		return err
+	}
 	pos++
 
 	var n int
@@ -152,13 +254,95 @@ func (e *TableMapEvent) Decode(data []byte) error {
 		}
 	}
 
+	// the rows events of parsers sharing the table map event, see TableResolver,
+	// may decode concurrently, so they must only read the caches
+	e.fillCaches()
+	return nil
+}
+
+// SetOptionalMetaDecodeFunc overrides the decoding of the optional metadata
+// of the event. f receives the bytes after the null bitmap up to the end of
+// the event body, i.e. the TLV encoded optional metadata (type byte, packed
+// length, value) without the checksum. It may be empty when the server writes
+// no optional metadata. f is called instead of the default decoding, so it
+// should call DecodeOptionalMeta for the types it does not handle itself.
+// A nil f restores the default decoding.
+func (e *TableMapEvent) SetOptionalMetaDecodeFunc(f func(data []byte) error) {
+	e.optionalMetaDecodeFunc = f
+}
+
+// SetEnumSetLabelsFunc sets the function giving the values of the enum and set columns
+// whose values are not logged (binlog_row_metadata is not FULL), e.g. from a known
+// schema, so that they can still be resolved to labels, see EnumValue and SetValue.
+// f is called with the schema and table names and the 0-based column index, once per
+// column, and returns nil if it doesn't know the values. The values logged in the
+// event take precedence. EnumStrValue and SetStrValue are not changed.
+func (e *TableMapEvent) SetEnumSetLabelsFunc(f func(schema, table string, column int) []string) {
+	e.enumSetLabelsFunc = f
+	e.enumLabels = nil
+	e.setLabels = nil
+	e.fillEnumSetLabels()
+}
+
+// DecodeOptionalMeta decodes the optional metadata in data the default way,
+// filling the exported metadata fields. Unknown types are skipped.
+func (e *TableMapEvent) DecodeOptionalMeta(data []byte) error {
+	return e.decodeOptionalMeta(data)
+}
+
+// checkNameTerminator checks the 0x00 after schema/table name, which
+// is not there if the table id is decoded with a wrong size.
+func (e *TableMapEvent) checkNameTerminator(data []byte, pos int, name string) error {
+	if pos >= len(data) || data[pos] != 0x00 {
+		return errors.Errorf("invalid table map event, missing 0x00 after %s name, table id size %d may be wrong", name, e.tableIDSize)
+	}
+	return nil
+}
+
+// checkTableIDSize checks tableIDSize and that data is long enough for
+// table id and flags.
+func checkTableIDSize(tableIDSize int, dataLen int) error {
+	if tableIDSize != 4 && tableIDSize != 6 {
+		return errors.Errorf("invalid table id size %d, must be 4 or 6", tableIDSize)
+	}
+	if dataLen < tableIDSize+2 {
+		return errors.Errorf("event data len %d is too short for table id size %d", dataLen, tableIDSize)
+	}
 	return nil
 }
 
+// PeekTableID returns the table id of a table map or rows event body without
+// decoding the event, so consumers can cheaply filter rows events by table
+// before a full decode. tableIDSize is 4 or 6, see BinlogParser.
+// 0 is returned if data is too short.
+func PeekTableID(data []byte, tableIDSize int) uint64 {
+	if len(data) < tableIDSize {
+		return 0
+	}
+	return FixedLengthInt(data[0:tableIDSize])
+}
+
 func bitmapByteSize(columnCount int) int {
 	return (columnCount + 7) / 8
 }
 
+// NullBitmapSize returns the size in bytes of the NULL bitmap at the start of a row
+// image with the columns set in columnBitmap (ColumnBitmap1 or ColumnBitmap2) among
+// the first columnCount. The NULL bitmap only has a bit for each column present in
+// the image, so it can be shorter than the column bitmap. The bits of columnBitmap
+// beyond its length are considered unset.
+func NullBitmapSize(columnBitmap []byte, columnCount int) int {
+	count := 0
+	for i := 0; i < len(columnBitmap) && i*8 < columnCount; i++ {
+		b := columnBitmap[i]
+		if rest := columnCount - i*8; rest < 8 {
+			b &= 1<<uint(rest) - 1
+		}
+		count += bits.OnesCount8(b)
+	}
+	return bitmapByteSize(count)
+}
+
 // see mysql sql/log_event.h
 /*
 	0 byte
@@ -233,8 +417,7 @@ func (e *TableMapEvent) decodeMeta(data []byte) error {
 			MYSQL_TYPE_TIMESTAMP2:
 			e.ColumnMeta[i] = uint16(data[pos])
 			pos++
-		case MYSQL_TYPE_NEWDATE,
-			MYSQL_TYPE_ENUM,
+		case MYSQL_TYPE_ENUM,
 			MYSQL_TYPE_SET,
 			MYSQL_TYPE_TINY_BLOB,
 			MYSQL_TYPE_MEDIUM_BLOB,
@@ -249,18 +432,15 @@ func (e *TableMapEvent) decodeMeta(data []byte) error {
 }
 
 func (e *TableMapEvent) decodeOptionalMeta(data []byte) (err error) {
-	pos := 0
-	for pos < len(data) {
+	s := newByteScanner(data)
+	for s.Len() > 0 {
 		// optional metadata fields are stored in Type, Length, Value(TLV) format
 		// Type takes 1 byte. Length is a packed integer value. Values takes Length bytes
-		t := data[pos]
-		pos++
-
-		l, _, n := LengthEncodedInt(data[pos:])
-		pos += n
-
-		v := data[pos : pos+int(l)]
-		pos += int(l)
+		t, _ := s.ReadByte()
+		v, err := s.ReadLenEncString()
+		if err != nil {
+			return errors.Annotatef(err, "optional metadata type %d", t)
+		}
 
 		switch t {
 		case TABLE_MAP_OPT_META_SIGNEDNESS:
@@ -335,10 +515,12 @@ func (e *TableMapEvent) decodeOptionalMeta(data []byte) (err error) {
 }
 
 func (e *TableMapEvent) decodeIntSeq(v []byte) (ret []uint64, err error) {
-	p := 0
-	for p < len(v) {
-		i, _, n := LengthEncodedInt(v[p:])
-		p += n
+	s := newByteScanner(v)
+	for s.Len() > 0 {
+		i, err := s.ReadLenEncInt()
+		if err != nil {
+			return nil, err
+		}
 		ret = append(ret, i)
 	}
 	return
@@ -356,13 +538,14 @@ func (e *TableMapEvent) decodeDefaultCharset(v []byte) (ret []uint64, err error)
 }
 
 func (e *TableMapEvent) decodeColumnNames(v []byte) error {
-	p := 0
+	s := newByteScanner(v)
 	e.ColumnName = make([][]byte, 0, e.ColumnCount)
-	for p < len(v) {
-		n := int(v[p])
-		p++
-		e.ColumnName = append(e.ColumnName, v[p:p+n])
-		p += n
+	for s.Len() > 0 {
+		name, err := s.ReadLenEncString()
+		if err != nil {
+			return errors.Annotatef(err, "column name %d", len(e.ColumnName))
+		}
+		e.ColumnName = append(e.ColumnName, name)
 	}
 
 	if len(e.ColumnName) != int(e.ColumnCount) {
@@ -372,17 +555,22 @@ func (e *TableMapEvent) decodeColumnNames(v []byte) error {
 }
 
 func (e *TableMapEvent) decodeStrValue(v []byte) (ret [][][]byte, err error) {
-	p := 0
-	for p < len(v) {
-		nVal, _, n := LengthEncodedInt(v[p:])
-		p += n
+	s := newByteScanner(v)
+	for s.Len() > 0 {
+		nVal, err := s.ReadLenEncInt()
+		if err != nil {
+			return nil, err
+		}
+		// every value takes at least 1 byte
+		if nVal > uint64(s.Len()) {
+			return nil, errors.Errorf("%d values need at least %d bytes but got %d", nVal, nVal, s.Len())
+		}
 		vals := make([][]byte, 0, int(nVal))
 		for i := 0; i < int(nVal); i++ {
-			val, _, n, err := LengthEncodedString(v[p:])
+			val, err := s.ReadLenEncString()
 			if err != nil {
 				return nil, err
 			}
-			p += n
 			vals = append(vals, val)
 		}
 		ret = append(ret, vals)
@@ -391,25 +579,31 @@ func (e *TableMapEvent) decodeStrValue(v []byte) (ret [][][]byte, err error) {
 }
 
 func (e *TableMapEvent) decodeSimplePrimaryKey(v []byte) error {
-	p := 0
-	for p < len(v) {
-		i, _, n := LengthEncodedInt(v[p:])
+	s := newByteScanner(v)
+	for s.Len() > 0 {
+		i, err := s.ReadLenEncInt()
+		if err != nil {
+			return errors.Annotate(err, "primary key")
+		}
 		e.PrimaryKey = append(e.PrimaryKey, i)
 		e.PrimaryKeyPrefix = append(e.PrimaryKeyPrefix, 0)
-		p += n
 	}
 	return nil
 }
 
 func (e *TableMapEvent) decodePrimaryKeyWithPrefix(v []byte) error {
-	p := 0
-	for p < len(v) {
-		i, _, n := LengthEncodedInt(v[p:])
+	s := newByteScanner(v)
+	for s.Len() > 0 {
+		i, err := s.ReadLenEncInt()
+		if err != nil {
+			return errors.Annotate(err, "primary key")
+		}
+		prefix, err := s.ReadLenEncInt()
+		if err != nil {
+			return errors.Annotate(err, "primary key prefix")
+		}
 		e.PrimaryKey = append(e.PrimaryKey, i)
-		p += n
-		i, _, n = LengthEncodedInt(v[p:])
-		e.PrimaryKeyPrefix = append(e.PrimaryKeyPrefix, i)
-		p += n
+		e.PrimaryKeyPrefix = append(e.PrimaryKeyPrefix, prefix)
 	}
 	return nil
 }
@@ -431,6 +625,7 @@ func (e *TableMapEvent) Dump(w io.Writer) {
 	fmt.Fprintf(w, "Enum str value: %v\n", e.EnumStrValueString())
 	fmt.Fprintf(w, "Column name: %v\n", e.ColumnNameString())
 	fmt.Fprintf(w, "Geometry type: %v\n", e.GeometryType)
+	fmt.Fprintf(w, "Geometry SRID: %v\n", e.GeometrySRID)
 	fmt.Fprintf(w, "Primary key: %v\n", e.PrimaryKey)
 	fmt.Fprintf(w, "Primary key prefix: %v\n", e.PrimaryKeyPrefix)
 	fmt.Fprintf(w, "Enum/set default charset: %v\n", e.EnumSetDefaultCharset)
@@ -482,7 +677,8 @@ func (e *TableMapEvent) Dump(w io.Writer) {
 			fmt.Fprintf(w, nameFmt, e.ColumnName[i])
 		}
 
-		fmt.Fprintf(w, "  type=%-3d", e.realType(i))
+		fmt.Fprintf(w, "  type=%-9s(%3d)", e.ColumnTypeName(i), e.realType(i))
+		fmt.Fprintf(w, "  desc=%s", e.ColumnDescriptor(i))
 
 		if e.IsNumericColumn(i) {
 			if len(unsignedMap) == 0 {
@@ -602,6 +798,28 @@ func (e *TableMapEvent) ColumnNameString() []string {
 	return e.columnNameString
 }
 
+// PrimaryKeyNames returns the names of the primary key columns in the order of the
+// primary key, see PrimaryKey. It returns nil if the primary key isn't logged or the
+// table has none, and an error if the column names are not available.
+func (e *TableMapEvent) PrimaryKeyNames() ([]string, error) {
+	if len(e.PrimaryKey) == 0 {
+		return nil, nil
+	}
+	names := e.ColumnNameString()
+	if len(names) == 0 {
+		return nil, errors.Errorf("column names of table %s.%s are not available", e.Schema, e.Table)
+	}
+
+	ret := make([]string, 0, len(e.PrimaryKey))
+	for _, idx := range e.PrimaryKey {
+		if idx >= uint64(len(names)) {
+			return nil, errors.Errorf("invalid primary key column index %d, column names count %d", idx, len(names))
+		}
+		ret = append(ret, names[idx])
+	}
+	return ret, nil
+}
+
 func (e *TableMapEvent) bytesSlice2StrSlice(src [][]byte) []string {
 	if src == nil {
 		return nil
@@ -637,6 +855,21 @@ func (e *TableMapEvent) UnsignedMap() map[int]bool {
 	return ret
 }
 
+// UnsignedSlice returns the unsigned flag of every column, indexed by column, like
+// UnsignedMap but in column order. It's false for the columns which are not numeric.
+// nil is returned if not available.
+func (e *TableMapEvent) UnsignedSlice() []bool {
+	m := e.UnsignedMap()
+	if m == nil {
+		return nil
+	}
+	ret := make([]bool, e.ColumnCount)
+	for i, v := range m {
+		ret[i] = v
+	}
+	return ret
+}
+
 // CollationMap returns a map: column index -> collation id.
 // Note that only character columns will be returned.
 // nil is returned if not available or no character columns at all.
@@ -651,6 +884,128 @@ func (e *TableMapEvent) EnumSetCollationMap() map[int]uint64 {
 	return e.collationMap(e.IsEnumOrSetColumn, e.EnumSetDefaultCharset, e.EnumSetColumnCharset)
 }
 
+// CollationSlice returns the collation id of every column, indexed by column, like
+// CollationMap but in column order. It's 0, which is not a valid collation id, for
+// the columns which are not character columns.
+// nil is returned if not available.
+func (e *TableMapEvent) CollationSlice() []uint64 {
+	return e.collationSlice(e.CollationMap())
+}
+
+// EnumSetCollationSlice returns the collation id of every column, indexed by column,
+// like EnumSetCollationMap but in column order. It's 0 for the columns which are not
+// enum or set columns.
+// nil is returned if not available.
+func (e *TableMapEvent) EnumSetCollationSlice() []uint64 {
+	return e.collationSlice(e.EnumSetCollationMap())
+}
+
+func (e *TableMapEvent) collationSlice(m map[int]uint64) []uint64 {
+	if m == nil {
+		return nil
+	}
+	ret := make([]uint64, e.ColumnCount)
+	for i, v := range m {
+		ret[i] = v
+	}
+	return ret
+}
+
+// EnumSetCharsetName returns the charset name of the i-th column if it is an enum or set column,
+// so that callers can transcode the member strings.
+// false is returned if the collation is not available or unknown.
+func (e *TableMapEvent) EnumSetCharsetName(i int) (string, bool) {
+	collation, ok := e.EnumSetCollationMap()[i]
+	if !ok {
+		return "", false
+	}
+	return collationCharsetName(collation)
+}
+
+// binaryCollationID is the id of the binary collation, used by BINARY/VARBINARY columns.
+const binaryCollationID = 63
+
+func collationCharsetName(collation uint64) (string, bool) {
+	c, err := charset.GetCollationByID(int(collation))
+	if err != nil {
+		return "", false
+	}
+	return c.CharsetName, true
+}
+
+// columnCollation returns the collation of the i-th column if it's a character column.
+func (e *TableMapEvent) columnCollation(i int) (uint64, bool) {
+	if e.collations == nil {
+		// an empty map if not logged, so that the cache is filled once
+		e.collations = e.CollationMap()
+		if e.collations == nil {
+			e.collations = map[int]uint64{}
+		}
+	}
+	collation, ok := e.collations[i]
+	return collation, ok
+}
+
+func (e *TableMapEvent) enumSetCollation(i int) (uint64, bool) {
+	if e.enumSetCollations == nil {
+		e.enumSetCollations = e.EnumSetCollationMap()
+		if e.enumSetCollations == nil {
+			e.enumSetCollations = map[int]uint64{}
+		}
+	}
+	collation, ok := e.enumSetCollations[i]
+	return collation, ok
+}
+
+// fillCaches fills the lazy caches read while decoding the rows, so that the rows
+// events decoding concurrently, e.g. in the goroutines of decodeRowsParallel, only
+// read them. Decode fills them, they are only filled lazily for the table map
+// events built by hand.
+func (e *TableMapEvent) fillCaches() {
+	e.columnCollation(0)
+	e.enumSetCollation(0)
+	e.fillEnumSetLabels()
+}
+
+// enumSetLabels returns the values of the i-th column if it's an enum or set column.
+func (e *TableMapEvent) enumSetLabels(i int) []string {
+	e.fillEnumSetLabels()
+	if e.IsEnumColumn(i) {
+		return e.enumLabels[i]
+	}
+	return e.setLabels[i]
+}
+
+// fillEnumSetLabels fills the caches of the enum and set values, the values not
+// logged are taken from enumSetLabelsFunc.
+func (e *TableMapEvent) fillEnumSetLabels() {
+	if e.enumLabels == nil {
+		e.enumLabels = e.addEnumSetLabels(e.EnumStrValueMap(), e.IsEnumColumn)
+	}
+	if e.setLabels == nil {
+		e.setLabels = e.addEnumSetLabels(e.SetStrValueMap(), e.IsSetColumn)
+	}
+}
+
+func (e *TableMapEvent) addEnumSetLabels(labels map[int][]string, isColumn func(int) bool) map[int][]string {
+	// an empty map if not available, so that the cache is filled once
+	if labels == nil {
+		labels = make(map[int][]string)
+	}
+	if e.enumSetLabelsFunc == nil {
+		return labels
+	}
+	for i := range e.ColumnType {
+		if _, ok := labels[i]; ok || !isColumn(i) {
+			continue
+		}
+		if values := e.enumSetLabelsFunc(string(e.Schema), string(e.Table), i); values != nil {
+			labels[i] = values
+		}
+	}
+	return labels
+}
+
 func (e *TableMapEvent) collationMap(includeType func(int) bool, defaultCharset, columnCharset []uint64) map[int]uint64 {
 	if len(defaultCharset) != 0 {
 		defaultCollation := defaultCharset[0]
@@ -731,7 +1086,54 @@ func (e *TableMapEvent) strValueMap(includeType func(int) bool, strValue [][]str
 // Note that only geometry columns will be returned.
 // nil is returned if not available or no geometry columns at all.
 func (e *TableMapEvent) GeometryTypeMap() map[int]uint64 {
-	if len(e.GeometryType) == 0 {
+	return e.geometryMap(e.GeometryType)
+}
+
+// GeometrySRIDMap returns a map: column index -> SRID constraint.
+// Note that only geometry columns will be returned.
+// nil is returned if not available or no geometry columns at all.
+func (e *TableMapEvent) GeometrySRIDMap() map[int]uint64 {
+	return e.geometryMap(e.GeometrySRID)
+}
+
+// geometryTypeNames are the names of the geometry types, see Field::geometry_type in MySQL.
+var geometryTypeNames = map[uint64]string{
+	0: "GEOMETRY",
+	1: "POINT",
+	2: "LINESTRING",
+	3: "POLYGON",
+	4: "MULTIPOINT",
+	5: "MULTILINESTRING",
+	6: "MULTIPOLYGON",
+	7: "GEOMETRYCOLLECTION",
+}
+
+// GeometryTypeName returns the geometry type name of the i-th column, e.g. "POINT".
+// false is returned if it's not a geometry column, the geometry type is not
+// available or unknown.
+func (e *TableMapEvent) GeometryTypeName(i int) (string, bool) {
+	tp, ok := e.GeometryTypeMap()[i]
+	if !ok {
+		return "", false
+	}
+	name, ok := geometryTypeNames[tp]
+	return name, ok
+}
+
+// GeometryDimension returns the coordinate dimension of the i-th column. MySQL and
+// MariaDB only store 2D geometries, so it's GeometryXY if the geometry type of the
+// column is logged, see GeometryTypeMap. false is returned if it's not a geometry
+// column or the geometry type is not available, GeometryDimensionOf can be used on
+// a decoded value then.
+func (e *TableMapEvent) GeometryDimension(i int) (GeometryDimension, bool) {
+	if _, ok := e.GeometryTypeName(i); !ok {
+		return 0, false
+	}
+	return GeometryXY, true
+}
+
+func (e *TableMapEvent) geometryMap(seq []uint64) map[int]uint64 {
+	if len(seq) == 0 {
 		return nil
 	}
 	p := 0
@@ -741,12 +1143,58 @@ func (e *TableMapEvent) GeometryTypeMap() map[int]uint64 {
 			continue
 		}
 
-		ret[i] = e.GeometryType[p]
+		ret[i] = seq[p]
 		p++
 	}
 	return ret
 }
 
+// TypeOrdinal returns the position of the i-th column among the columns of its
+// metadata group, which is the index of its entry in the per-type metadata:
+//   - numeric columns: SignednessBitmap
+//   - character columns: ColumnCharset
+//   - enum columns: EnumStrValue
+//   - set columns: SetStrValue
+//   - geometry columns: GeometryType, GeometrySRID
+//
+// Note that EnumSetColumnCharset is indexed among enum and set columns together.
+// MariaDB also counts geometry columns as character columns, so for MariaDB the
+// position of a geometry column in ColumnCharset is returned, and GeometryTypeMap
+// gives its geometry type.
+// -1 is returned if the column has no per-type metadata or i is out of range.
+func (e *TableMapEvent) TypeOrdinal(i int) int {
+	if i < 0 || i >= int(e.ColumnCount) || i >= len(e.ColumnType) {
+		return -1
+	}
+	includeTypes := []func(int) bool{
+		e.IsEnumColumn,
+		e.IsSetColumn,
+		e.IsGeometryColumn,
+		e.IsNumericColumn,
+		e.IsCharacterColumn,
+	}
+	if e.flavor == MariaDBFlavor {
+		includeTypes[2], includeTypes[4] = e.IsCharacterColumn, e.IsGeometryColumn
+	}
+	for _, includeType := range includeTypes {
+		if includeType(i) {
+			return e.columnOrdinal(i, includeType)
+		}
+	}
+	return -1
+}
+
+// columnOrdinal returns the number of columns before the i-th one that includeType accepts.
+func (e *TableMapEvent) columnOrdinal(i int, includeType func(int) bool) int {
+	p := 0
+	for j := 0; j < i; j++ {
+		if includeType(j) {
+			p++
+		}
+	}
+	return p
+}
+
 // VisibilityMap returns a map: column index -> visiblity.
 // Invisible column was introduced in MySQL 8.0.23
 // nil is returned if not available.
@@ -755,15 +1203,93 @@ func (e *TableMapEvent) VisibilityMap() map[int]bool {
 		return nil
 	}
 	ret := make(map[int]bool)
-	i := 0
-	for _, field := range e.VisibilityBitmap {
-		for c := 0x80; c != 0; c >>= 1 {
-			ret[i] = field&byte(c) != 0
-			i++
-			if uint64(i) >= e.ColumnCount {
-				return ret
-			}
+	// the bitmap is (ColumnCount+7)/8 bytes, the padding bits of the last byte are ignored
+	for i := 0; uint64(i) < e.ColumnCount && i/8 < len(e.VisibilityBitmap); i++ {
+		ret[i] = e.VisibilityBitmap[i/8]&(0x80>>uint(i%8)) != 0
+	}
+	return ret
+}
+
+// VisibilitySlice returns the visibility of every column, indexed by column, like
+// VisibilityMap but in column order. The columns missing from a short
+// VisibilityBitmap are visible.
+// nil is returned if not available.
+func (e *TableMapEvent) VisibilitySlice() []bool {
+	m := e.VisibilityMap()
+	if m == nil {
+		return nil
+	}
+	ret := make([]bool, e.ColumnCount)
+	for i := range ret {
+		visible, ok := m[i]
+		ret[i] = visible || !ok
+	}
+	return ret
+}
+
+// ColumnInfo bundles the metadata of one column in a TableMapEvent.
+// Fields whose metadata is not logged by the server are left as zero values.
+type ColumnInfo struct {
+	// Name is empty if column names are not available.
+	Name string
+	// Type is the real type of the column, e.g. MYSQL_TYPE_ENUM for an enum
+	// column which is logged as MYSQL_TYPE_STRING.
+	Type byte
+	Meta uint16
+
+	// Unsigned is only set for numeric columns.
+	Unsigned bool
+	// Collation is the collation id of character, enum and set columns.
+	// 0 means not available.
+	Collation uint64
+	Nullable  bool
+
+	PrimaryKey bool
+	// Invisible is only set for invisible columns, MySQL 8.0.23+.
+	Invisible bool
+
+	// EnumValues/SetValues list the members of enum/set columns.
+	EnumValues []string
+	SetValues  []string
+}
+
+// Columns returns the metadata of all columns in order, which is the
+// structured equivalent of the columns part of Dump.
+func (e *TableMapEvent) Columns() []ColumnInfo {
+	names := e.ColumnNameString()
+	unsignedMap := e.UnsignedMap()
+	collationMap := e.CollationMap()
+	enumSetCollationMap := e.EnumSetCollationMap()
+	enumStrValueMap := e.EnumStrValueMap()
+	setStrValueMap := e.SetStrValueMap()
+	visibilityMap := e.VisibilityMap()
+
+	primaryKey := map[int]struct{}{}
+	for _, pk := range e.PrimaryKey {
+		primaryKey[int(pk)] = struct{}{}
+	}
+
+	ret := make([]ColumnInfo, e.ColumnCount)
+	for i := range ret {
+		col := &ret[i]
+		if len(names) > i {
+			col.Name = names[i]
+		}
+		col.Type = e.realType(i)
+		col.Meta = e.ColumnMeta[i]
+		col.Unsigned = unsignedMap[i]
+		if collation, ok := collationMap[i]; ok {
+			col.Collation = collation
+		} else {
+			col.Collation = enumSetCollationMap[i]
+		}
+		_, col.Nullable = e.Nullable(i)
+		_, col.PrimaryKey = primaryKey[i]
+		if visible, ok := visibilityMap[i]; ok {
+			col.Invisible = !visible
 		}
+		col.EnumValues = enumStrValueMap[i]
+		col.SetValues = setStrValueMap[i]
 	}
 	return ret
 }
@@ -789,64 +1315,380 @@ func (e *TableMapEvent) realType(i int) byte {
 	return typ
 }
 
-func (e *TableMapEvent) IsNumericColumn(i int) bool {
-	switch e.realType(i) {
-	case MYSQL_TYPE_TINY,
-		MYSQL_TYPE_SHORT,
-		MYSQL_TYPE_INT24,
-		MYSQL_TYPE_LONG,
-		MYSQL_TYPE_LONGLONG,
-		MYSQL_TYPE_NEWDECIMAL,
-		MYSQL_TYPE_FLOAT,
-		MYSQL_TYPE_DOUBLE:
-		return true
-
+// ColumnTypeName returns the SQL type name of the i-th column, e.g. "ENUM" or "VARCHAR",
+// based on its real type. Note that BLOB/TEXT and CHAR/BINARY are not distinguished.
+func (e *TableMapEvent) ColumnTypeName(i int) string {
+	switch rtyp := e.realType(i); rtyp {
+	case MYSQL_TYPE_DECIMAL, MYSQL_TYPE_NEWDECIMAL:
+		return "DECIMAL"
+	case MYSQL_TYPE_TINY:
+		return "TINYINT"
+	case MYSQL_TYPE_SHORT:
+		return "SMALLINT"
+	case MYSQL_TYPE_INT24:
+		return "MEDIUMINT"
+	case MYSQL_TYPE_LONG:
+		return "INT"
+	case MYSQL_TYPE_LONGLONG:
+		return "BIGINT"
+	case MYSQL_TYPE_FLOAT:
+		return "FLOAT"
+	case MYSQL_TYPE_DOUBLE:
+		return "DOUBLE"
+	case MYSQL_TYPE_NULL:
+		return "NULL"
+	case MYSQL_TYPE_TIMESTAMP, MYSQL_TYPE_TIMESTAMP2:
+		return "TIMESTAMP"
+	case MYSQL_TYPE_DATE, MYSQL_TYPE_NEWDATE:
+		return "DATE"
+	case MYSQL_TYPE_TIME, MYSQL_TYPE_TIME2:
+		return "TIME"
+	case MYSQL_TYPE_DATETIME, MYSQL_TYPE_DATETIME2:
+		return "DATETIME"
+	case MYSQL_TYPE_YEAR:
+		return "YEAR"
+	case MYSQL_TYPE_VARCHAR, MYSQL_TYPE_VAR_STRING:
+		return "VARCHAR"
+	case MYSQL_TYPE_STRING:
+		return "CHAR"
+	case MYSQL_TYPE_BIT:
+		return "BIT"
+	case MYSQL_TYPE_JSON:
+		return "JSON"
+	case MYSQL_TYPE_ENUM:
+		return "ENUM"
+	case MYSQL_TYPE_SET:
+		return "SET"
+	case MYSQL_TYPE_TINY_BLOB, MYSQL_TYPE_MEDIUM_BLOB, MYSQL_TYPE_LONG_BLOB, MYSQL_TYPE_BLOB:
+		return "BLOB"
+	case MYSQL_TYPE_GEOMETRY:
+		return "GEOMETRY"
 	default:
-		return false
+		return fmt.Sprintf("UNKNOWN(%d)", rtyp)
 	}
 }
 
-// IsCharacterColumn returns true if the column type is considered as character type.
-// Note that JSON/GEOMETRY types are treated as character type in mariadb.
-// (JSON is an alias for LONGTEXT in mariadb: https://mariadb.com/kb/en/json-data-type/)
-func (e *TableMapEvent) IsCharacterColumn(i int) bool {
+// ColumnLength returns the declared length of the i-th column decoded from its
+// meta, which is only meaningful for:
+//   - VARCHAR/VARBINARY: the maximum length in bytes, e.g. 80 for VARCHAR(20)
+//     CHARACTER SET utf8mb4
+//   - CHAR/BINARY: the length in bytes, likewise
+//   - BIT: the number of bits
+//   - DECIMAL: the precision, see also ColumnDecimalSize
+//
+// ok is false for the other types.
+func (e *TableMapEvent) ColumnLength(i int) (length int, ok bool) {
+	if i < 0 || i >= len(e.ColumnType) || i >= len(e.ColumnMeta) {
+		return 0, false
+	}
+	meta := e.ColumnMeta[i]
 	switch e.realType(i) {
-	case MYSQL_TYPE_STRING,
-		MYSQL_TYPE_VAR_STRING,
-		MYSQL_TYPE_VARCHAR,
-		MYSQL_TYPE_BLOB:
-		return true
-
-	case MYSQL_TYPE_GEOMETRY:
-		if e.flavor == "mariadb" {
-			return true
-		}
-		return false
-
+	case MYSQL_TYPE_VARCHAR, MYSQL_TYPE_VAR_STRING:
+		return int(meta), true
+	case MYSQL_TYPE_STRING:
+		_, length = realStringType(meta)
+		return length, true
+	case MYSQL_TYPE_BIT:
+		return int(meta>>8)*8 + int(meta&0xFF), true
+	case MYSQL_TYPE_NEWDECIMAL:
+		return int(meta >> 8), true
 	default:
-		return false
+		return 0, false
 	}
 }
 
-func (e *TableMapEvent) IsEnumColumn(i int) bool {
-	return e.realType(i) == MYSQL_TYPE_ENUM
-}
-
-func (e *TableMapEvent) IsSetColumn(i int) bool {
-	return e.realType(i) == MYSQL_TYPE_SET
+// DecimalSize is the precision and scale of DECIMAL(M, D) columns.
+type DecimalSize struct {
+	Precision int
+	Scale     int
 }
 
-func (e *TableMapEvent) IsGeometryColumn(i int) bool {
-	return e.realType(i) == MYSQL_TYPE_GEOMETRY
+// ColumnDecimalSize returns the precision and scale of the i-th column if it's
+// a DECIMAL column.
+func (e *TableMapEvent) ColumnDecimalSize(i int) (DecimalSize, bool) {
+	if i < 0 || i >= len(e.ColumnType) || i >= len(e.ColumnMeta) || e.realType(i) != MYSQL_TYPE_NEWDECIMAL {
+		return DecimalSize{}, false
+	}
+	meta := e.ColumnMeta[i]
+	return DecimalSize{Precision: int(meta >> 8), Scale: int(meta & 0xFF)}, true
 }
 
-func (e *TableMapEvent) IsEnumOrSetColumn(i int) bool {
-	rtyp := e.realType(i)
-	return rtyp == MYSQL_TYPE_ENUM || rtyp == MYSQL_TYPE_SET
+// columnFsp returns the fractional seconds precision of the i-th column,
+// 0 for the types without one.
+func (e *TableMapEvent) columnFsp(i int) int {
+	switch e.realType(i) {
+	case MYSQL_TYPE_DATETIME2, MYSQL_TYPE_TIMESTAMP2:
+		if i < len(e.ColumnMeta) && e.ColumnMeta[i] <= 6 {
+			return int(e.ColumnMeta[i])
+		}
+	}
+	return 0
 }
 
-// JsonColumnCount returns the number of JSON columns in this table
-func (e *TableMapEvent) JsonColumnCount() uint64 {
+// ColumnDescriptor returns the definition of the i-th column as far as it can be
+// told from the event, e.g. "VARCHAR(200)", "DECIMAL(10,2)", "ENUM('a','b')",
+// "BIT(12)" or "INT UNSIGNED". The parts that need the optional metadata are left
+// out if it is not logged (binlog_row_metadata is not FULL): the labels of ENUM/SET,
+// UNSIGNED, the geometry type, and the charset, so the length of CHAR/VARCHAR is
+// in bytes and BINARY/VARBINARY and BLOB/TEXT can't be told apart.
+// i must be in range [0, ColumnCount).
+func (e *TableMapEvent) ColumnDescriptor(i int) string {
+	name := e.ColumnTypeName(i)
+	meta := e.ColumnMeta[i]
+	collation, hasCollation := e.columnCollation(i)
+	isBinary := hasCollation && collation == binaryCollationID
+
+	// the length in characters if the charset is known
+	charLength := func(length int) int {
+		if !hasCollation {
+			return length
+		}
+		csName, ok := collationCharsetName(collation)
+		if !ok {
+			return length
+		}
+		cs, err := charset.GetCharsetInfo(csName)
+		if err != nil || cs.Maxlen <= 1 {
+			return length
+		}
+		return length / cs.Maxlen
+	}
+	quoteLabels := func(labels []string) string {
+		quoted := make([]string, len(labels))
+		for j, label := range labels {
+			quoted[j] = "'" + strings.ReplaceAll(label, "'", "''") + "'"
+		}
+		return strings.Join(quoted, ",")
+	}
+
+	switch rtyp := e.realType(i); rtyp {
+	case MYSQL_TYPE_NEWDECIMAL:
+		size, _ := e.ColumnDecimalSize(i)
+		name = fmt.Sprintf("DECIMAL(%d,%d)", size.Precision, size.Scale)
+	case MYSQL_TYPE_VARCHAR, MYSQL_TYPE_VAR_STRING, MYSQL_TYPE_STRING:
+		length, _ := e.ColumnLength(i)
+		if isBinary {
+			name = "BINARY"
+			if rtyp != MYSQL_TYPE_STRING {
+				name = "VARBINARY"
+			}
+		}
+		name = fmt.Sprintf("%s(%d)", name, charLength(length))
+	case MYSQL_TYPE_BIT:
+		length, _ := e.ColumnLength(i)
+		name = fmt.Sprintf("BIT(%d)", length)
+	case MYSQL_TYPE_ENUM, MYSQL_TYPE_SET:
+		if labels := e.enumSetLabels(i); len(labels) > 0 {
+			name += "(" + quoteLabels(labels) + ")"
+		}
+	case MYSQL_TYPE_TIME2, MYSQL_TYPE_DATETIME2, MYSQL_TYPE_TIMESTAMP2:
+		if meta > 0 {
+			name = fmt.Sprintf("%s(%d)", name, meta)
+		}
+	case MYSQL_TYPE_BLOB:
+		if hasCollation && !isBinary {
+			name = "TEXT"
+		}
+		switch meta {
+		case 1:
+			name = "TINY" + name
+		case 3:
+			name = "MEDIUM" + name
+		case 4:
+			name = "LONG" + name
+		}
+	case MYSQL_TYPE_GEOMETRY:
+		if geometryName, ok := e.GeometryTypeName(i); ok {
+			name = geometryName
+		}
+	}
+
+	if unsigned, ok := e.UnsignedMap()[i]; ok && unsigned {
+		name += " UNSIGNED"
+	}
+	return name
+}
+
+// DecodeOptions are the decode options of BinlogSyncerConfig (or BinlogParser) which
+// change the Go type of the decoded values, see TableMapEvent.GoType.
+type DecodeOptions struct {
+	UseDecimal        bool
+	ParseTime         bool
+	UseMySQLTime      bool
+	UseTimeWithDec    bool
+	BitAsBytes        bool
+	EnumSetWithLabels bool
+	BinaryAsBytes     bool
+	BinaryAsHex       bool
+	JSONAsNative      bool
+}
+
+var (
+	bytesType     = reflect.TypeOf([]byte(nil))
+	stringType    = reflect.TypeOf("")
+	interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+// GoType returns the Go type the non-NULL values of the i-th column are decoded to
+// with opts, following the mapping documented on RowsEvent, so that a schema can be
+// built before decoding any row. nil is returned for MYSQL_TYPE_NULL and the types
+// which are not supported. Some values have another type whatever the column type:
+// the zero and invalid dates with ParseTime and the partial dates with
+// BinlogSyncerConfig.UsePartialDate are not time.Time, an empty JSON document is []byte
+// and a partial JSON update is *JsonDiff. Any type may also be returned by
+// BinlogSyncerConfig.BlobDecodeFunc, which is not taken into account.
+// i must be in range [0, ColumnCount).
+func (e *TableMapEvent) GoType(i int, opts DecodeOptions) reflect.Type {
+	switch e.realType(i) {
+	case MYSQL_TYPE_TINY:
+		return reflect.TypeOf(int8(0))
+	case MYSQL_TYPE_SHORT:
+		return reflect.TypeOf(int16(0))
+	case MYSQL_TYPE_INT24, MYSQL_TYPE_LONG:
+		return reflect.TypeOf(int32(0))
+	case MYSQL_TYPE_LONGLONG:
+		return reflect.TypeOf(int64(0))
+	case MYSQL_TYPE_YEAR:
+		return reflect.TypeOf(0)
+	case MYSQL_TYPE_NEWDECIMAL:
+		if opts.UseDecimal {
+			return reflect.TypeOf(decimal.Decimal{})
+		}
+		return stringType
+	case MYSQL_TYPE_FLOAT:
+		return reflect.TypeOf(float32(0))
+	case MYSQL_TYPE_DOUBLE:
+		return reflect.TypeOf(float64(0))
+	case MYSQL_TYPE_BIT:
+		if opts.BitAsBytes {
+			return bytesType
+		}
+		return reflect.TypeOf(int64(0))
+	case MYSQL_TYPE_TIMESTAMP, MYSQL_TYPE_TIMESTAMP2, MYSQL_TYPE_DATETIME, MYSQL_TYPE_DATETIME2:
+		if opts.ParseTime {
+			if opts.UseTimeWithDec {
+				return reflect.TypeOf(TimeWithDec{})
+			}
+			return reflect.TypeOf(time.Time{})
+		}
+		return stringType
+	case MYSQL_TYPE_TIME, MYSQL_TYPE_TIME2:
+		if opts.UseMySQLTime {
+			return reflect.TypeOf(MySQLTime{})
+		}
+		return stringType
+	case MYSQL_TYPE_DATE, MYSQL_TYPE_NEWDATE:
+		return stringType
+	case MYSQL_TYPE_ENUM:
+		if opts.EnumSetWithLabels {
+			return reflect.TypeOf(EnumValue{})
+		}
+		return reflect.TypeOf(int64(0))
+	case MYSQL_TYPE_SET:
+		if opts.EnumSetWithLabels {
+			return reflect.TypeOf(SetValue{})
+		}
+		return reflect.TypeOf(int64(0))
+	case MYSQL_TYPE_BLOB, MYSQL_TYPE_GEOMETRY:
+		if opts.BinaryAsHex && e.isBinaryStringColumn(i) {
+			return stringType
+		}
+		return bytesType
+	case MYSQL_TYPE_VARCHAR, MYSQL_TYPE_VAR_STRING, MYSQL_TYPE_STRING:
+		if opts.BinaryAsHex {
+			return stringType
+		}
+		if opts.BinaryAsBytes {
+			if collation, ok := e.columnCollation(i); ok && collation == binaryCollationID {
+				return bytesType
+			}
+		}
+		return stringType
+	case MYSQL_TYPE_JSON:
+		if opts.JSONAsNative {
+			return interfaceType
+		}
+		return stringType
+	default:
+		return nil
+	}
+}
+
+// isBinaryStringColumn returns true if the i-th column is a BLOB column which is not
+// known to be TEXT, or a BINARY or VARBINARY column.
+func (e *TableMapEvent) isBinaryStringColumn(i int) bool {
+	collation, ok := e.columnCollation(i)
+	switch e.realType(i) {
+	case MYSQL_TYPE_BLOB:
+		return !ok || collation == binaryCollationID
+	case MYSQL_TYPE_VARCHAR, MYSQL_TYPE_VAR_STRING, MYSQL_TYPE_STRING:
+		return ok && collation == binaryCollationID
+	default:
+		return false
+	}
+}
+
+func (e *TableMapEvent) IsNumericColumn(i int) bool {
+	switch e.realType(i) {
+	case MYSQL_TYPE_TINY,
+		MYSQL_TYPE_SHORT,
+		MYSQL_TYPE_INT24,
+		MYSQL_TYPE_LONG,
+		MYSQL_TYPE_LONGLONG,
+		MYSQL_TYPE_NEWDECIMAL,
+		MYSQL_TYPE_FLOAT,
+		MYSQL_TYPE_DOUBLE:
+		return true
+
+	default:
+		return false
+	}
+}
+
+// IsCharacterColumn returns true if the column type is considered as character type.
+// Note that JSON/GEOMETRY types are treated as character type in mariadb.
+// (JSON is an alias for LONGTEXT in mariadb: https://mariadb.com/kb/en/json-data-type/)
+// For GEOMETRY this only reflects that MariaDB logs a (binary) charset for it in
+// the optional metadata: the values are SRID + WKB and decode to []byte in both
+// flavors, like BLOB/TEXT values, so TranscodeToUTF8 and BinaryAsBytes don't apply.
+func (e *TableMapEvent) IsCharacterColumn(i int) bool {
+	switch e.realType(i) {
+	case MYSQL_TYPE_STRING,
+		MYSQL_TYPE_VAR_STRING,
+		MYSQL_TYPE_VARCHAR,
+		MYSQL_TYPE_BLOB:
+		return true
+
+	case MYSQL_TYPE_GEOMETRY:
+		if e.flavor == "mariadb" {
+			return true
+		}
+		return false
+
+	default:
+		return false
+	}
+}
+
+func (e *TableMapEvent) IsEnumColumn(i int) bool {
+	return e.realType(i) == MYSQL_TYPE_ENUM
+}
+
+func (e *TableMapEvent) IsSetColumn(i int) bool {
+	return e.realType(i) == MYSQL_TYPE_SET
+}
+
+func (e *TableMapEvent) IsGeometryColumn(i int) bool {
+	return e.realType(i) == MYSQL_TYPE_GEOMETRY
+}
+
+func (e *TableMapEvent) IsEnumOrSetColumn(i int) bool {
+	rtyp := e.realType(i)
+	return rtyp == MYSQL_TYPE_ENUM || rtyp == MYSQL_TYPE_SET
+}
+
+// JsonColumnCount returns the number of JSON columns in this table
+func (e *TableMapEvent) JsonColumnCount() uint64 {
 	count := uint64(0)
 	for _, t := range e.ColumnType {
 		if t == MYSQL_TYPE_JSON {
@@ -860,6 +1702,76 @@ func (e *TableMapEvent) JsonColumnCount() uint64 {
 // RowsEventStmtEndFlag is set in the end of the statement.
 const RowsEventStmtEndFlag = 0x01
 
+// Other rows event flags, see Rows_log_event::enum_flag in MySQL and MariaDB.
+const (
+	RowsEventNoForeignKeyChecksFlag  = 0x02
+	RowsEventRelaxedUniqueChecksFlag = 0x04
+	RowsEventCompleteRowsFlag        = 0x08
+	// RowsEventNoCheckConstraintChecksFlag is only defined by MariaDB.
+	RowsEventNoCheckConstraintChecksFlag = 0x80
+)
+
+// RowsEventFlags are the flags of a rows event, see RowsEvent.FlagSet.
+type RowsEventFlags uint16
+
+var rowsEventFlagNames = []struct {
+	flag RowsEventFlags
+	name string
+}{
+	{RowsEventStmtEndFlag, "STMT_END_F"},
+	{RowsEventNoForeignKeyChecksFlag, "NO_FOREIGN_KEY_CHECKS_F"},
+	{RowsEventRelaxedUniqueChecksFlag, "RELAXED_UNIQUE_CHECKS_F"},
+	{RowsEventCompleteRowsFlag, "COMPLETE_ROWS_F"},
+	{RowsEventNoCheckConstraintChecksFlag, "NO_CHECK_CONSTRAINT_CHECKS_F"},
+}
+
+// String returns the names of the set flags like in the server source, separated
+// by "|", e.g. "STMT_END_F|NO_FOREIGN_KEY_CHECKS_F". Unknown flags are printed in hex
+// and "0" is returned if no flag is set. NO_CHECK_CONSTRAINT_CHECKS_F is only set
+// by MariaDB.
+func (f RowsEventFlags) String() string {
+	if f == 0 {
+		return "0"
+	}
+	var names []string
+	for _, n := range rowsEventFlagNames {
+		if f&n.flag != 0 {
+			names = append(names, n.name)
+			f &^= n.flag
+		}
+	}
+	if f != 0 {
+		names = append(names, fmt.Sprintf("0x%x", uint16(f)))
+	}
+	return strings.Join(names, "|")
+}
+
+// IsStatementEnd returns true if STMT_END_F is set.
+func (f RowsEventFlags) IsStatementEnd() bool {
+	return f&RowsEventStmtEndFlag != 0
+}
+
+// NoForeignKeyChecks returns true if NO_FOREIGN_KEY_CHECKS_F is set.
+func (f RowsEventFlags) NoForeignKeyChecks() bool {
+	return f&RowsEventNoForeignKeyChecksFlag != 0
+}
+
+// RelaxedUniqueChecks returns true if RELAXED_UNIQUE_CHECKS_F is set.
+func (f RowsEventFlags) RelaxedUniqueChecks() bool {
+	return f&RowsEventRelaxedUniqueChecksFlag != 0
+}
+
+// CompleteRows returns true if COMPLETE_ROWS_F is set.
+func (f RowsEventFlags) CompleteRows() bool {
+	return f&RowsEventCompleteRowsFlag != 0
+}
+
+// NoCheckConstraintChecks returns true if the MariaDB NO_CHECK_CONSTRAINT_CHECKS_F
+// is set. Unlike RowsEvent.NoCheckConstraintChecks it doesn't check the flavor.
+func (f RowsEventFlags) NoCheckConstraintChecks() bool {
+	return f&RowsEventNoCheckConstraintChecksFlag != 0
+}
+
 // RowsEvent represents a MySQL rows event like DELETE_ROWS_EVENT,
 // UPDATE_ROWS_EVENT, etc.
 // RowsEvent.Rows saves the rows data, and the MySQL type to golang type mapping
@@ -873,14 +1785,14 @@ const RowsEventStmtEndFlag = 0x01
 // - MYSQL_TYPE_NEWDECIMAL: string / "github.com/shopspring/decimal".Decimal
 // - MYSQL_TYPE_FLOAT: float32
 // - MYSQL_TYPE_DOUBLE: float64
-// - MYSQL_TYPE_BIT: int64
-// - MYSQL_TYPE_TIMESTAMP: string / time.Time
-// - MYSQL_TYPE_TIMESTAMP2: string / time.Time
-// - MYSQL_TYPE_DATETIME: string / time.Time
-// - MYSQL_TYPE_DATETIME2: string / time.Time
-// - MYSQL_TYPE_TIME: string
-// - MYSQL_TYPE_TIME2: string
-// - MYSQL_TYPE_DATE: string
+// - MYSQL_TYPE_BIT: int64 / []byte
+// - MYSQL_TYPE_TIMESTAMP: string / time.Time / TimeWithDec
+// - MYSQL_TYPE_TIMESTAMP2: string / time.Time / TimeWithDec
+// - MYSQL_TYPE_DATETIME: string / time.Time / TimeWithDec / PartialDate
+// - MYSQL_TYPE_DATETIME2: string / time.Time / TimeWithDec / PartialDate
+// - MYSQL_TYPE_TIME: string / MySQLTime
+// - MYSQL_TYPE_TIME2: string / MySQLTime
+// - MYSQL_TYPE_DATE: string / PartialDate
 // - MYSQL_TYPE_YEAR: int
 // - MYSQL_TYPE_ENUM: int64
 // - MYSQL_TYPE_SET: int64
@@ -888,7 +1800,7 @@ const RowsEventStmtEndFlag = 0x01
 // - MYSQL_TYPE_VARCHAR: string
 // - MYSQL_TYPE_VAR_STRING: string
 // - MYSQL_TYPE_STRING: string
-// - MYSQL_TYPE_JSON: []byte / *replication.JsonDiff
+// - MYSQL_TYPE_JSON: []byte / string / *replication.JsonDiff / the Go value with JSONAsNative
 // - MYSQL_TYPE_GEOMETRY: []byte
 type RowsEvent struct {
 	// 0, 1, 2
@@ -896,7 +1808,9 @@ type RowsEvent struct {
 
 	tableIDSize int
 	tables      map[uint64]*TableMapEvent
-	needBitmap2 bool
+	// tableResolver replaces tables if set
+	tableResolver func(tableID uint64) (*TableMapEvent, bool)
+	needBitmap2   bool
 
 	// for mariadb *_COMPRESSED_EVENT_V1
 	compressed bool
@@ -914,9 +1828,36 @@ type RowsEvent struct {
 	NdbFormat byte
 	NdbData   []byte
 
+	// Decoded from NdbData if NdbFormat is NDB_ERIF_FORMAT_V0.
+	// NdbTransactionID is set if NdbFlags has NDB_ERIF_TRANSID,
+	// NdbConflictFlags is set if NdbFlags has NDB_ERIF_CFT_FLAGS.
+	// The originating server id and epoch are not carried in the row info,
+	// they are logged in mysql.ndb_apply_status by NDB instead.
+	NdbFlags         uint16
+	NdbTransactionID uint64
+	NdbConflictFlags uint16
+
 	PartitionId       uint16
 	SourcePartitionId uint16
 
+	// OriginatingQuery is the SQL statement which produced the rows, taken from the
+	// RowsQueryEvent (binlog_rows_query_log_events=ON) or MariadbAnnotateRowsEvent
+	// (binlog_annotate_row_events=ON) preceding the rows events of the statement.
+	// It's only set by BinlogParser and is nil if no such event was logged.
+	OriginatingQuery []byte
+
+	// TransactionID identifies the transaction the rows belong to. It isn't logged in
+	// the rows event, BinlogParser sets it to the value given by SetTransactionID,
+	// e.g. a sequence number the caller increments on every GTID or
+	// TransactionContextEvent of group replication. It's 0 if not set.
+	TransactionID uint64
+
+	// ChecksumLength is the number of trailing bytes of the data passed to Decode or
+	// DecodeData which are the event checksum and not row data, e.g. BinlogChecksumLength
+	// if the data still ends with a CRC32 checksum. BinlogParser strips the checksum
+	// before decoding, so it's 0 by default.
+	ChecksumLength int
+
 	// lenenc_int
 	ColumnCount uint64
 
@@ -934,16 +1875,91 @@ type RowsEvent struct {
 
 	// if UPDATE_ROWS_EVENTv1 or v2, or PARTIAL_UPDATE_ROWS_EVENT
 	// len = (ColumnCount + 7) / 8
+	// UPDATE_ROWS_EVENTv0 logs a single bitmap for both images, it is ColumnBitmap1.
 	ColumnBitmap2 []byte
 
 	// rows: all return types from RowsEvent.decodeValue()
 	Rows           [][]interface{}
 	SkippedColumns [][]int
 
+	// RowOffsets is the byte offset of each row image of Rows in the data passed to
+	// DecodeData, or in the decompressed data of a compressed event. It can be used
+	// to decode a single row again by DecodeRowAt.
+	RowOffsets []int
+	// RowErrors is only set when the event is decoded with
+	// BinlogParser.SetContinueOnRowError and has an entry per row image of Rows.
+	// It holds the error of the row images that failed to decode, whose rows in
+	// Rows and SkippedColumns are nil, and nil for the others.
+	RowErrors []error
+	// rowsData keeps the data of the last DecodeData for DecodeRowAt
+	rowsData []byte
+
 	parseTime               bool
 	timestampStringLocation *time.Location
+	temporalStringLocation  *time.Location
 	useDecimal              bool
+	bitAsBytes              bool
 	ignoreJSONDecodeErr     bool
+	jsonStoredKeyOrder      bool
+	jsonAsNative            bool
+	usePartialDate          bool
+	useMySQLTime            bool
+	useTimeWithDec          bool
+	transcodeToUTF8         bool
+	defaultCharset          string
+	enumSetWithLabels       bool
+	trimEnumSetLabels       bool
+	binaryAsBytes           bool
+	binaryAsHex             bool
+	binaryAsUpperHex        bool
+	// maxRows limits the number of decoded row images, 0 means unlimited
+	maxRows int
+	// continueOnRowError records the decode errors of row images in RowErrors
+	// instead of failing the event
+	continueOnRowError bool
+
+	// parallelDecodeMinRows enables decodeRowsParallel for events with at least
+	// this many row images, 0 disables it
+	parallelDecodeMinRows int
+
+	// decimalScratch is reused by decodeDecimalScratch
+	decimalScratch []byte
+
+	stats RowsEventDecodeStats
+
+	unknownTypeDecodeFunc func(tp byte, meta uint16, data []byte) (interface{}, int, error)
+
+	legacyDecimalLengthFunc func(schema, table string, column int) int
+
+	blobDecodeFunc func(col int, r io.Reader) (interface{}, error)
+
+	// panicHandler is called with the recovered value of a panic while decoding the rows
+	panicHandler func(recovered interface{}, partialEvent *RowsEvent)
+}
+
+// RowsEventDecodeStats are the counters accumulated while decoding the rows of a RowsEvent.
+type RowsEventDecodeStats struct {
+	// Rows is the number of decoded row images, the same as len(RowsEvent.Rows)
+	// unless some failed with RowsEvent.RowErrors
+	Rows int
+	// SkippedColumns is the number of columns not present in the row images
+	SkippedColumns int
+	// Nulls is the number of NULL values
+	Nulls int
+	// JSONPartialDiffs is the number of partial JSON updates decoded as *JsonDiff
+	JSONPartialDiffs int
+	// Warnings is the number of ignored decode errors, e.g. invalid JSON ignored by
+	// BinlogParser.SetIgnoreJSONDecodeError
+	Warnings int
+}
+
+// add adds the counters of o to s.
+func (s *RowsEventDecodeStats) add(o RowsEventDecodeStats) {
+	s.Rows += o.Rows
+	s.SkippedColumns += o.SkippedColumns
+	s.Nulls += o.Nulls
+	s.JSONPartialDiffs += o.JSONPartialDiffs
+	s.Warnings += o.Warnings
 }
 
 // EnumRowImageType is allowed types for every row in mysql binlog.
@@ -981,9 +1997,16 @@ const (
 	EnumBinlogRowValueOptionsPartialJsonUpdates = EnumBinlogRowValueOptions(iota + 1)
 )
 
+// DecodeHeader decodes the rows event header and looks up its table map event.
+// The table map events are cached across events, so the cache should be flushed
+// when reconnecting, otherwise ErrTableIDSizeMismatch may be returned.
 func (e *RowsEvent) DecodeHeader(data []byte) (int, error) {
+	if err := checkTableIDSize(e.tableIDSize, len(data)); err != nil {
+		return 0, err
+	}
+
 	pos := 0
-	e.TableID = FixedLengthInt(data[0:e.tableIDSize])
+	e.TableID = PeekTableID(data, e.tableIDSize)
 	pos += e.tableIDSize
 
 	e.Flags = binary.LittleEndian.Uint16(data[pos:])
@@ -992,6 +2015,9 @@ func (e *RowsEvent) DecodeHeader(data []byte) (int, error) {
 	if e.Version == 2 {
 		dataLen := binary.LittleEndian.Uint16(data[pos:])
 		pos += 2
+		if dataLen < 2 {
+			return 0, errors.Errorf("invalid extra row data len %d, must >= 2, table id size %d may be wrong", dataLen, e.tableIDSize)
+		}
 		if dataLen > 2 {
 			err := e.decodeExtraData(data[pos:])
 			if err != nil {
@@ -1005,24 +2031,45 @@ func (e *RowsEvent) DecodeHeader(data []byte) (int, error) {
 	e.ColumnCount, _, n = LengthEncodedInt(data[pos:])
 	pos += n
 
+	// a truncated event or a corrupted column count
+	if pos > len(data) || e.ColumnCount > uint64(len(data)-pos)*8 {
+		return 0, errors.Annotatef(io.ErrUnexpectedEOF, "column bitmap of %d columns is truncated", e.ColumnCount)
+	}
 	bitCount := bitmapByteSize(int(e.ColumnCount))
+	// the v0 events of the MySQL 5.1 pre-GA releases have no bitmap of the after image
+	twoBitmaps := e.needBitmap2 && e.Version != 0
+	if twoBitmaps && 2*bitCount > len(data)-pos {
+		return 0, errors.Annotatef(io.ErrUnexpectedEOF, "column bitmaps of %d columns need %d bytes but got %d",
+			e.ColumnCount, 2*bitCount, len(data)-pos)
+	}
+
 	e.ColumnBitmap1 = data[pos : pos+bitCount]
 	pos += bitCount
 
-	if e.needBitmap2 {
+	if twoBitmaps {
 		e.ColumnBitmap2 = data[pos : pos+bitCount]
 		pos += bitCount
+	} else if e.needBitmap2 {
+		e.ColumnBitmap2 = e.ColumnBitmap1
 	}
 
 	var ok bool
-	e.Table, ok = e.tables[e.TableID]
-	if !ok {
+	if e.tableResolver != nil {
+		if e.Table, ok = e.tableResolver(e.TableID); !ok || e.Table == nil {
+			return 0, errors.Annotatef(errMissingTableMapEvent, "table id %d", e.TableID)
+		}
+	} else if e.Table, ok = e.tables[e.TableID]; !ok {
 		if len(e.tables) > 0 {
 			return 0, errors.Errorf("invalid table id %d, no corresponding table map event", e.TableID)
 		} else {
 			return 0, errors.Annotatef(errMissingTableMapEvent, "table id %d", e.TableID)
 		}
 	}
+	// table map events built by hand may not have tableIDSize set
+	if e.Table.tableIDSize != 0 && e.Table.tableIDSize != e.tableIDSize {
+		return 0, errors.Annotatef(ErrTableIDSizeMismatch, "table id %d, rows event %d, table map event %d",
+			e.TableID, e.tableIDSize, e.Table.tableIDSize)
+	}
 	return pos, nil
 }
 
@@ -1034,9 +2081,15 @@ func (e *RowsEvent) decodeExtraData(data []byte) (err2 error) {
 	case ENUM_EXTRA_ROW_INFO_TYPECODE_NDB:
 		var ndbLength int = int(data[pos])
 		pos += 1
+		if ndbLength < 2 || pos+ndbLength-1 > len(data) {
+			return errors.Errorf("invalid ndb extra row info length %d", ndbLength)
+		}
 		e.NdbFormat = data[pos]
 		pos += 1
 		e.NdbData = data[pos : pos+ndbLength-2]
+		if e.NdbFormat == NDB_ERIF_FORMAT_V0 {
+			return e.decodeNdbInfo(e.NdbData)
+		}
 	case ENUM_EXTRA_ROW_INFO_TYPECODE_PARTITION:
 		if e.eventType == UPDATE_ROWS_EVENTv1 || e.eventType == UPDATE_ROWS_EVENTv2 || e.eventType == PARTIAL_UPDATE_ROWS_EVENT {
 			e.PartitionId = binary.LittleEndian.Uint16(data[pos:])
@@ -1049,13 +2102,40 @@ func (e *RowsEvent) decodeExtraData(data []byte) (err2 error) {
 	return nil
 }
 
-func (e *RowsEvent) DecodeData(pos int, data []byte) (err2 error) {
-	if e.compressed {
-		data, err2 = DecompressMariadbData(data[pos:])
-		if err2 != nil {
-			//nolint:nakedret
-			return
+func (e *RowsEvent) decodeNdbInfo(data []byte) error {
+	if len(data) < 2 {
+		return errors.Errorf("invalid ndb extra row info, need 2 bytes for flags but got %d", len(data))
+	}
+	pos := 0
+	e.NdbFlags = binary.LittleEndian.Uint16(data[pos:])
+	pos += 2
+	if e.NdbFlags&NDB_ERIF_TRANSID != 0 {
+		if len(data) < pos+8 {
+			return errors.Errorf("invalid ndb extra row info, need %d bytes for transaction id but got %d", pos+8, len(data))
+		}
+		e.NdbTransactionID = binary.LittleEndian.Uint64(data[pos:])
+		pos += 8
+	}
+	if e.NdbFlags&NDB_ERIF_CFT_FLAGS != 0 {
+		if len(data) < pos+2 {
+			return errors.Errorf("invalid ndb extra row info, need %d bytes for conflict flags but got %d", pos+2, len(data))
 		}
+		e.NdbConflictFlags = binary.LittleEndian.Uint16(data[pos:])
+	}
+	return nil
+}
+
+// NdbInfoVersion returns the format version of the NDB extra row info.
+// false is returned if the event has no NDB extra row info.
+// Only NDB_ERIF_FORMAT_V0 is decoded into NdbFlags, NdbTransactionID and NdbConflictFlags,
+// other versions are kept in NdbData.
+func (e *RowsEvent) NdbInfoVersion() (byte, bool) {
+	return e.NdbFormat, e.NdbData != nil
+}
+
+func (e *RowsEvent) DecodeData(pos int, data []byte) (err2 error) {
+	if data, err2 = e.rowsEventData(pos, data); err2 != nil {
+		return err2
 	}
 
 	// Rows_log_event::print_verbose()
@@ -1067,7 +2147,10 @@ func (e *RowsEvent) DecodeData(pos int, data []byte) (err2 error) {
 	// ... repeat rows until event-end
 	defer func() {
 		if r := recover(); r != nil {
-			err2 = errors.Errorf("parse rows event panic %v, data %q, parsed rows %#v, table map %#v", r, data, e, e.Table)
+			if e.panicHandler != nil {
+				e.panicHandler(r, e)
+			}
+			err2 = rowsEventPanicError(r, errors.Errorf("parse rows event panic %v, data %q, parsed rows %#v, table map %#v", r, data, e, e.Table))
 		}
 	}()
 
@@ -1078,42 +2161,1267 @@ func (e *RowsEvent) DecodeData(pos int, data []byte) (err2 error) {
 	}
 	e.SkippedColumns = make([][]int, 0, rowsLen)
 	e.Rows = make([][]interface{}, 0, rowsLen)
+	e.RowOffsets = make([]int, 0, rowsLen)
+	e.RowErrors = nil
+	e.rowsData = data
+	e.stats = RowsEventDecodeStats{}
+
+	if err = e.checkColumnCount(); err != nil {
+		return err
+	}
+
+	if e.parallelDecodeMinRows > 0 && e.decodeRowsParallel(pos, data) {
+		if e.continueOnRowError {
+			e.RowErrors = make([]error, len(e.Rows))
+		}
+		return nil
+	}
+	if e.continueOnRowError {
+		e.RowErrors = make([]error, 0, rowsLen)
+	}
+
+	rowImageType := e.firstRowImageType()
+
+	for pos < len(data) {
+		if err = e.checkMaxRows(pos, data); err != nil {
+			return err
+		}
+
+		// Parse the first image
+		e.RowOffsets = append(e.RowOffsets, pos)
+		if n, err = e.decodeImageOrSkip(data[pos:], e.ColumnBitmap1, rowImageType); err != nil {
+			return errors.Trace(err)
+		}
+		pos += n
+
+		// Parse the second image (for UPDATE only)
+		if e.needBitmap2 {
+			if err = e.checkMaxRows(pos, data); err != nil {
+				return err
+			}
+			e.RowOffsets = append(e.RowOffsets, pos)
+			if n, err = e.decodeImageOrSkip(data[pos:], e.ColumnBitmap2, EnumRowImageTypeUpdateAI); err != nil {
+				return errors.Trace(err)
+			}
+			pos += n
+		}
+	}
+
+	return e.checkRowImagesEnd(pos, data)
+}
+
+// checkMaxRows checks that one more row image can be decoded from data at pos,
+// counting the before and after images of an update separately like the
+// parallel decoding does.
+func (e *RowsEvent) checkMaxRows(pos int, data []byte) error {
+	if e.maxRows > 0 && len(e.Rows) >= e.maxRows {
+		return errors.Annotatef(ErrTooManyRows, "max rows %d, %d bytes left", e.maxRows, len(data)-pos)
+	}
+	return nil
+}
+
+// DecodeDataFunc decodes the rows like DecodeData, but calls f with every row image
+// instead of adding it to Rows, so Rows, SkippedColumns, RowOffsets and RowErrors are
+// left nil. The images of an update event are passed in order, the before image then
+// the after image.
+//
+// row and skippedColumns are reused for the next images: they are only valid until
+// f is called with the next image of the same kind, so the before image of an update
+// is still valid when f gets its after image. f must copy them to keep them longer.
+// It saves the allocation of both slices per image, which matters for wide tables.
+// The values themselves are not reused. Decoding stops at the first error of f,
+// which is returned as is. MaxRows, ContinueOnRowError and parallel decoding don't
+// apply.
+func (e *RowsEvent) DecodeDataFunc(pos int, data []byte, f func(row []interface{}, skippedColumns []int) error) (err2 error) {
+	if data, err2 = e.rowsEventData(pos, data); err2 != nil {
+		return err2
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			if e.panicHandler != nil {
+				e.panicHandler(r, e)
+			}
+			err2 = rowsEventPanicError(r, errors.Errorf("parse rows event panic %v, data %q, table map %#v", r, data, e.Table))
+		}
+	}()
+
+	e.Rows = nil
+	e.SkippedColumns = nil
+	e.RowOffsets = nil
+	e.RowErrors = nil
+	e.rowsData = nil
+	e.stats = RowsEventDecodeStats{}
+
+	if err := e.checkColumnCount(); err != nil {
+		return err
+	}
+
+	// one buffer for the before (or the only) image and one for the after image
+	var rows [2][]interface{}
+	var skips [2][]int
+	rowImageType := e.firstRowImageType()
+	for image := 0; pos < len(data); image++ {
+		bitmap, imageType, k := e.ColumnBitmap1, rowImageType, 0
+		if e.needBitmap2 && image%2 == 1 {
+			bitmap, imageType, k = e.ColumnBitmap2, EnumRowImageTypeUpdateAI, 1
+		}
+		if rows[k] == nil {
+			rows[k] = make([]interface{}, e.ColumnCount)
+		}
+
+		n, err := e.decodeImageTo(data[pos:], bitmap, imageType, rows[k], &skips[k])
+		if err != nil {
+			return errors.Trace(err)
+		}
+		pos += n
+		if err = f(rows[k], skips[k]); err != nil {
+			return err
+		}
+	}
+	return e.checkRowImagesEnd(pos, data)
+}
+
+// rowsEventData returns the row data of the rows event data starting at pos,
+// without the checksum and decompressed.
+func (e *RowsEvent) rowsEventData(pos int, data []byte) ([]byte, error) {
+	if e.ChecksumLength > 0 {
+		if e.ChecksumLength > len(data)-pos {
+			return nil, errors.Annotatef(io.ErrUnexpectedEOF, "checksum length %d, %d bytes left", e.ChecksumLength, len(data)-pos)
+		}
+		data = data[:len(data)-e.ChecksumLength]
+	}
+
+	if e.compressed {
+		return DecompressMariadbData(data[pos:])
+	}
+	return data, nil
+}
+
+// checkColumnCount returns ErrRowImageMismatch if the table map event has fewer
+// columns than the rows event.
+func (e *RowsEvent) checkColumnCount() error {
+	if e.Table == nil {
+		return nil
+	}
+	if len(e.Table.ColumnType) < int(e.ColumnCount) || len(e.Table.ColumnMeta) < int(e.ColumnCount) {
+		return errors.Annotatef(ErrRowImageMismatch, "rows event has %d columns, table map event has %d",
+			e.ColumnCount, len(e.Table.ColumnType))
+	}
+	return nil
+}
+
+// checkRowImagesEnd returns ErrRowImageMismatch if the decoded row images, ending at pos,
+// don't end exactly at the end of data.
+func (e *RowsEvent) checkRowImagesEnd(pos int, data []byte) error {
+	if pos != len(data) {
+		return errors.Annotatef(ErrRowImageMismatch, "row images end at offset %d, data has %d bytes", pos, len(data))
+	}
+	return nil
+}
+
+// rowsEventPanicError returns err for the recovered panic r of decoding the rows,
+// annotating ErrRowImageMismatch if r is an index or slice out of range, which is how
+// row images that don't line up with the table map event usually fail.
+func rowsEventPanicError(r interface{}, err error) error {
+	if re, ok := r.(runtime.Error); ok && strings.Contains(re.Error(), "out of range") {
+		return errors.Annotate(ErrRowImageMismatch, err.Error())
+	}
+	return err
+}
+
+// decodeImageOrSkip decodes a row image like decodeImage. With continueOnRowError,
+// an image that fails to decode is skipped if its length can be found by scanImage,
+// adding a nil row and its error to RowErrors.
+func (e *RowsEvent) decodeImageOrSkip(data []byte, bitmap []byte, rowImageType EnumRowImageType) (int, error) {
+	n, err := e.decodeImage(data, bitmap, rowImageType)
+	if !e.continueOnRowError {
+		return n, err
+	}
+	if err == nil {
+		e.RowErrors = append(e.RowErrors, nil)
+		return n, nil
+	}
+	// the after image of a partial update has a different format
+	if e.eventType == PARTIAL_UPDATE_ROWS_EVENT && rowImageType == EnumRowImageTypeUpdateAI {
+		return 0, err
+	}
+	n, ok := e.scanImage(data, bitmap)
+	if !ok {
+		return 0, err
+	}
+	e.Rows = append(e.Rows, nil)
+	e.SkippedColumns = append(e.SkippedColumns, nil)
+	e.RowErrors = append(e.RowErrors, errors.Annotatef(err, "row image %d", len(e.RowErrors)))
+	return n, nil
+}
+
+// firstRowImageType returns the type of the first (or the only) image of each row.
+func (e *RowsEvent) firstRowImageType() EnumRowImageType {
+	switch e.eventType {
+	case WRITE_ROWS_EVENTv0, WRITE_ROWS_EVENTv1, WRITE_ROWS_EVENTv2, MARIADB_WRITE_ROWS_COMPRESSED_EVENT_V1:
+		return EnumRowImageTypeWriteAI
+	case DELETE_ROWS_EVENTv0, DELETE_ROWS_EVENTv1, DELETE_ROWS_EVENTv2, MARIADB_DELETE_ROWS_COMPRESSED_EVENT_V1:
+		return EnumRowImageTypeDeleteBI
+	default:
+		return EnumRowImageTypeUpdateBI
+	}
+}
+
+// DecodeRowAt decodes again the single row image starting at offset, which must be
+// one of RowOffsets, without changing Rows, SkippedColumns or DecodeStats.
+// DecodeData must have been called before. The row is decoded on a copy of e,
+// so it can be called while other goroutines read the event.
+func (e *RowsEvent) DecodeRowAt(offset int) (row []interface{}, err error) {
+	idx := sort.SearchInts(e.RowOffsets, offset)
+	if idx == len(e.RowOffsets) || e.RowOffsets[idx] != offset || offset >= len(e.rowsData) {
+		return nil, errors.Errorf("offset %d is not the start of a decoded row image", offset)
+	}
+	if e.Table == nil {
+		return nil, errors.Trace(errMissingTableMapEvent)
+	}
+
+	bitmap, rowImageType := e.ColumnBitmap1, e.firstRowImageType()
+	if e.needBitmap2 && idx%2 == 1 {
+		bitmap, rowImageType = e.ColumnBitmap2, EnumRowImageTypeUpdateAI
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			row, err = nil, errors.Errorf("parse row at offset %d panic %v", offset, r)
+		}
+	}()
+
+	// decodeImageTo updates the stats and the decimal scratch buffer, and reads
+	// the caches of the table map event
+	e.Table.fillCaches()
+	d := *e
+	d.stats = RowsEventDecodeStats{}
+	d.decimalScratch = nil
+	row = make([]interface{}, e.ColumnCount)
+	var skips []int
+	if _, err = d.decodeImageTo(e.rowsData[offset:], bitmap, rowImageType, row, &skips); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return row, nil
+}
+
+func (e *RowsEvent) Decode(data []byte) error {
+	pos, err := e.DecodeHeader(data)
+	if err != nil {
+		return err
+	}
+	return e.DecodeData(pos, data)
+}
+
+// DecodeFrom reads exactly size bytes of the event body from r and decodes them.
+func (e *RowsEvent) DecodeFrom(r io.Reader, size int) error {
+	data, err := readEventBody(r, size)
+	if err != nil {
+		return err
+	}
+	return e.Decode(data)
+}
+
+// readEventBody reads exactly size bytes from r into a new buffer. The decoded
+// events keep references to the buffer, so it can't be reused.
+func readEventBody(r io.Reader, size int) ([]byte, error) {
+	if size < 0 {
+		return nil, errors.Errorf("invalid event body size %d", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, errors.Annotatef(err, "read event body of %d bytes", size)
+	}
+	return data, nil
+}
+
+// DecodeHeaderOnly decodes the rows event header and resolves e.Table, but
+// skips the row data. e.Rows and e.SkippedColumns will be nil in this mode.
+// It can be used as BinlogParser's rows event decode function for tools that
+// only need the table id of each rows event.
+func (e *RowsEvent) DecodeHeaderOnly(data []byte) error {
+	pos, err := e.DecodeHeader(data)
+	if err != nil {
+		return err
+	}
+	if pos > len(data) {
+		return errors.Annotatef(io.ErrUnexpectedEOF, "rows event header needs %d bytes but got %d", pos, len(data))
+	}
+	e.Rows = nil
+	e.SkippedColumns = nil
+	e.RowOffsets = nil
+	e.RowErrors = nil
+	e.rowsData = nil
+	return nil
+}
+
+// IsStatementEnd returns true if the event is the last rows event of the statement.
+func (e *RowsEvent) IsStatementEnd() bool {
+	return e.FlagSet().IsStatementEnd()
+}
+
+// NoForeignKeyChecks returns true if foreign_key_checks was disabled.
+func (e *RowsEvent) NoForeignKeyChecks() bool {
+	return e.FlagSet().NoForeignKeyChecks()
+}
+
+// RelaxedUniqueChecks returns true if unique_checks was disabled.
+func (e *RowsEvent) RelaxedUniqueChecks() bool {
+	return e.FlagSet().RelaxedUniqueChecks()
+}
+
+// CompleteRows returns true if the rows contain all the columns.
+func (e *RowsEvent) CompleteRows() bool {
+	return e.FlagSet().CompleteRows()
+}
+
+// NoCheckConstraintChecks returns true if check_constraint_checks was disabled.
+// The flag is only defined by MariaDB, so false is returned for other flavors,
+// which is decided by the flavor of the table map event.
+func (e *RowsEvent) NoCheckConstraintChecks() bool {
+	if e.Table == nil || e.Table.flavor != MariaDBFlavor {
+		return false
+	}
+	return e.FlagSet().NoCheckConstraintChecks()
+}
+
+// FlagSet returns Flags as RowsEventFlags.
+func (e *RowsEvent) FlagSet() RowsEventFlags {
+	return RowsEventFlags(e.Flags)
+}
+
+// SetTableResolver sets the function DecodeHeader looks up the table map event of
+// the table id with, instead of the table map events cached by BinlogParser. It
+// allows keeping the table map events elsewhere, e.g. in a cache shared between
+// parsers.
+func (e *RowsEvent) SetTableResolver(resolver func(tableID uint64) (*TableMapEvent, bool)) {
+	e.tableResolver = resolver
+}
+
+// RawEventType returns the type of the event as logged, e.g. UPDATE_ROWS_EVENTv1,
+// UPDATE_ROWS_EVENTv2 or PARTIAL_UPDATE_ROWS_EVENT.
+func (e *RowsEvent) RawEventType() EventType {
+	return e.eventType
+}
+
+// IsCompressed returns true if the event is a MariaDB *_COMPRESSED_EVENT_V1,
+// whose rows data is decompressed before decoding.
+func (e *RowsEvent) IsCompressed() bool {
+	return e.compressed
+}
+
+// DriverValues returns the k-th row of Rows coerced into values accepted by
+// database/sql, so that the row can be inserted into another database directly.
+//   - integers are converted to int64, or to string if an uint64 overflows int64,
+//     the values of UNSIGNED columns as unsigned, see TableMapEvent.UnsignedMap
+//   - float32 is converted to float64
+//   - decimal.Decimal is converted to string
+//   - time values are converted to time.Time, PartialDate to string
+//   - JSON values decoded by JSONAsNative are converted back to the JSON text
+//
+// Partial JSON (*JsonDiff) can't be represented and an error is returned.
+func (e *RowsEvent) DriverValues(k int) ([]driver.Value, error) {
+	if k < 0 || k >= len(e.Rows) {
+		return nil, errors.Errorf("row index %d out of range, rows count %d", k, len(e.Rows))
+	}
+
+	var unsignedMap map[int]bool
+	if e.Table != nil {
+		unsignedMap = e.Table.UnsignedMap()
+	}
+	row := e.Rows[k]
+	values := make([]driver.Value, len(row))
+	for i, v := range row {
+		if unsignedMap[i] {
+			v = e.Table.toUnsigned(i, v)
+		}
+		v, err := e.nativeJSONText(i, v)
+		if err != nil {
+			return nil, errors.Annotatef(err, "column %d", i)
+		}
+		dv, err := toDriverValue(v)
+		if err != nil {
+			return nil, errors.Annotatef(err, "column %d", i)
+		}
+		values[i] = dv
+	}
+	return values, nil
+}
+
+// DeleteKeys returns the values identifying the k-th row of Rows keyed by column name,
+// which can be used to build the WHERE clause of a DELETE statement.
+// The primary key columns are returned if the table map event has them, otherwise
+// all the columns present in the row image are returned.
+// Column names are required, see TableMapEvent.ColumnNameString.
+func (e *RowsEvent) DeleteKeys(k int) (map[string]interface{}, error) {
+	if k < 0 || k >= len(e.Rows) {
+		return nil, errors.Errorf("row index %d out of range, rows count %d", k, len(e.Rows))
+	}
+	if e.Table == nil {
+		return nil, errors.Trace(errMissingTableMapEvent)
+	}
+
+	names := e.Table.ColumnNameString()
+	if len(names) == 0 {
+		return nil, errors.Errorf("column names of table %s.%s are not available", e.Table.Schema, e.Table.Table)
+	}
+
+	row := e.Rows[k]
+	keys := make(map[string]interface{})
+	if len(e.Table.PrimaryKey) > 0 {
+		for _, idx := range e.Table.PrimaryKey {
+			if int(idx) >= len(row) || int(idx) >= len(names) {
+				return nil, errors.Errorf("invalid primary key column index %d, column count %d", idx, len(row))
+			}
+			keys[names[idx]] = row[idx]
+		}
+		return keys, nil
+	}
+
+	var skips []int
+	if k < len(e.SkippedColumns) {
+		skips = e.SkippedColumns[k]
+	}
+	p := 0
+	for i, v := range row {
+		if p < len(skips) && skips[p] == i {
+			p++
+			continue
+		}
+		if i >= len(names) {
+			return nil, errors.Errorf("no name for column %d, column names count %d", i, len(names))
+		}
+		keys[names[i]] = v
+	}
+	return keys, nil
+}
+
+// SchemaValue is a column value of a row with the name and the type of the column.
+type SchemaValue struct {
+	// Name is the column name, or col_N with the column index N if the names
+	// are not logged
+	Name string
+	// Type is the column definition, see TableMapEvent.ColumnDescriptor
+	Type  string
+	Value interface{}
+}
+
+// SchemaRow is a row image with the name and type of each value, see RowsEvent.RowsWithSchema.
+type SchemaRow []SchemaValue
+
+// RowsWithSchema returns the rows of Rows with the name and the type of the
+// columns present in each row image, so each row describes itself.
+// The rows that failed to decode, see RowErrors, are nil.
+func (e *RowsEvent) RowsWithSchema() ([]SchemaRow, error) {
+	if e.Table == nil {
+		return nil, errors.Trace(errMissingTableMapEvent)
+	}
+
+	names := e.Table.ColumnNameString()
+	columns := make([]SchemaValue, e.ColumnCount)
+	for i := range columns {
+		if i >= len(e.Table.ColumnType) || i >= len(e.Table.ColumnMeta) {
+			return nil, errors.Errorf("no type for column %d, column count %d", i, len(e.Table.ColumnType))
+		}
+		columns[i].Type = e.Table.ColumnDescriptor(i)
+		if i < len(names) {
+			columns[i].Name = names[i]
+		} else {
+			columns[i].Name = "col_" + strconv.Itoa(i)
+		}
+	}
+
+	rows := make([]SchemaRow, len(e.Rows))
+	for k, row := range e.Rows {
+		if row == nil {
+			continue
+		}
+		if len(row) > len(columns) {
+			return nil, errors.Errorf("row %d has %d values, column count %d", k, len(row), len(columns))
+		}
+		var skips []int
+		if k < len(e.SkippedColumns) {
+			skips = e.SkippedColumns[k]
+		}
+		schemaRow := make(SchemaRow, 0, len(row))
+		p := 0
+		for i, v := range row {
+			if p < len(skips) && skips[p] == i {
+				p++
+				continue
+			}
+			column := columns[i]
+			column.Value = v
+			schemaRow = append(schemaRow, column)
+		}
+		rows[k] = schemaRow
+	}
+	return rows, nil
+}
+
+// VisibleRow returns the k-th row without the invisible columns (MySQL 8.0.23+),
+// so it matches the columns of SELECT *. It returns the whole row if the
+// visibility of the columns is not logged, see TableMapEvent.VisibilityMap.
+func (e *RowsEvent) VisibleRow(k int) ([]interface{}, error) {
+	if k < 0 || k >= len(e.Rows) {
+		return nil, errors.Errorf("row index %d out of range, rows count %d", k, len(e.Rows))
+	}
+	if e.Table == nil {
+		return nil, errors.Trace(errMissingTableMapEvent)
+	}
+
+	row := e.Rows[k]
+	visibility := e.Table.VisibilityMap()
+	if visibility == nil {
+		return row, nil
+	}
+
+	ret := make([]interface{}, 0, len(row))
+	for i, v := range row {
+		if visible, ok := visibility[i]; ok && !visible {
+			continue
+		}
+		ret = append(ret, v)
+	}
+	return ret, nil
+}
+
+// ColumnPresent reports whether the i-th column is present in the k-th row image of
+// Rows according to the column bitmap of the image, that is ColumnBitmap2 for the
+// after images of update events and ColumnBitmap1 otherwise. Unlike SkippedColumns
+// it distinguishes a column missing from a MINIMAL row image from one logged as
+// NULL, which is present with a nil value.
+func (e *RowsEvent) ColumnPresent(k, i int) bool {
+	if k < 0 || k >= len(e.Rows) || i < 0 || uint64(i) >= e.ColumnCount {
+		return false
+	}
+	bitmap := e.ColumnBitmap1
+	if e.needBitmap2 && k%2 == 1 {
+		bitmap = e.ColumnBitmap2
+	}
+	return isBitmapSet(bitmap, i)
+}
+
+// MergedUpdate is an update of a row with the new values and the changed columns,
+// see RowsEvent.MergedUpdates.
+type MergedUpdate struct {
+	// Row has the values of the after image. The columns missing from the after
+	// image have the value of the before image, nil if they are in neither.
+	Row []interface{}
+	// Changed are the indexes of the changed columns in ascending order: the columns
+	// with a different value in the after image, or only in the after image.
+	Changed []int
+	// Before has the values of the changed columns in the before image, a column
+	// only in the after image has no entry as its old value is not known.
+	Before map[int]interface{}
+	// Missing are the indexes of the columns in neither image, with
+	// binlog_row_image=MINIMAL for example.
+	Missing []int
+}
+
+// MergedUpdates merges the before and after images of each updated row of an
+// update event into a MergedUpdate. The values are compared by ValuesEqual, and a
+// partial JSON update (*JsonDiff) is always a change.
+func (e *RowsEvent) MergedUpdates() ([]MergedUpdate, error) {
+	if e.Table == nil {
+		return nil, errors.Trace(errMissingTableMapEvent)
+	}
+	if !e.needBitmap2 {
+		return nil, errors.Errorf("%s is not an update event", e.eventType)
+	}
+	if len(e.Rows)%2 != 0 {
+		return nil, errors.Errorf("update event has an odd number %d of row images", len(e.Rows))
+	}
+
+	updates := make([]MergedUpdate, 0, len(e.Rows)/2)
+	for k := 0; k < len(e.Rows); k += 2 {
+		before, after, err := e.updateImages(k)
+		if err != nil {
+			return nil, err
+		}
+		u := MergedUpdate{Row: make([]interface{}, len(after))}
+		for i := range after {
+			inBefore, inAfter := e.ColumnPresent(k, i), e.ColumnPresent(k+1, i)
+			switch {
+			case inAfter:
+				u.Row[i] = after[i]
+				if inBefore && ValuesEqual(before[i], after[i], e.Table.realType(i)) {
+					continue
+				}
+				u.Changed = append(u.Changed, i)
+				if inBefore {
+					if u.Before == nil {
+						u.Before = make(map[int]interface{})
+					}
+					u.Before[i] = before[i]
+				}
+			case inBefore:
+				u.Row[i] = before[i]
+			default:
+				u.Missing = append(u.Missing, i)
+			}
+		}
+		updates = append(updates, u)
+	}
+	return updates, nil
+}
+
+// updateImages returns the before and after images of the update starting at
+// the k-th row image.
+func (e *RowsEvent) updateImages(k int) (before, after []interface{}, err error) {
+	for _, j := range []int{k, k + 1} {
+		if j < len(e.RowErrors) && e.RowErrors[j] != nil {
+			return nil, nil, errors.Trace(e.RowErrors[j])
+		}
+		if len(e.Rows[j]) != int(e.ColumnCount) {
+			return nil, nil, errors.Errorf("row image %d has %d values, column count %d", j, len(e.Rows[j]), e.ColumnCount)
+		}
+	}
+	return e.Rows[k], e.Rows[k+1], nil
+}
+
+// InferredRowImage guesses the binlog_row_image the event was logged with from
+// its column bitmaps, since the event doesn't record it:
+//   - "full": all columns are present in all images
+//   - "partial": only BLOB/TEXT/JSON/GEOMETRY columns are missing, like NOBLOB,
+//     or the column types are unknown
+//   - "minimal": other columns are missing
+//
+// It's a heuristic: a MINIMAL image that happens to contain every column is
+// reported as "full", and one that only misses blob columns as "partial".
+func (e *RowsEvent) InferredRowImage() string {
+	var missing []int
+	for i := 0; i < int(e.ColumnCount); i++ {
+		if !isBitmapSet(e.ColumnBitmap1, i) || (e.needBitmap2 && !isBitmapSet(e.ColumnBitmap2, i)) {
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) == 0 {
+		return "full"
+	}
+	if e.Table == nil {
+		return "partial"
+	}
+	for _, i := range missing {
+		if !isBlobType(e.Table.realType(i)) {
+			return "minimal"
+		}
+	}
+	return "partial"
+}
+
+// BlobOmitted reports whether the i-th column is a BLOB/TEXT/JSON/GEOMETRY column
+// missing from the k-th row image of Rows, as logged with binlog_row_image=NOBLOB
+// when the column is not changed or not needed to identify the row. Its value in
+// Rows is nil but it's not set to NULL or an empty value by the statement.
+func (e *RowsEvent) BlobOmitted(k, i int) bool {
+	if e.Table == nil || i < 0 || i >= len(e.Table.ColumnType) {
+		return false
+	}
+	if k < 0 || k >= len(e.Rows) || e.ColumnPresent(k, i) {
+		return false
+	}
+	return isBlobType(e.Table.realType(i))
+}
+
+// isBlobType reports whether tp is a type omitted from the row images by
+// binlog_row_image=NOBLOB.
+func isBlobType(tp byte) bool {
+	switch tp {
+	case MYSQL_TYPE_BLOB, MYSQL_TYPE_JSON, MYSQL_TYPE_GEOMETRY:
+		return true
+	default:
+		return false
+	}
+}
+
+// isBitmapSet is like isBitSet but returns true if the bitmap is too short,
+// e.g. not decoded.
+func isBitmapSet(bitmap []byte, i int) bool {
+	if i>>3 >= len(bitmap) {
+		return true
+	}
+	return isBitSet(bitmap, i)
+}
+
+// ApproxByteSize returns the approximate in-memory size of the decoded Rows,
+// for callers that want to limit buffered rows by memory rather than count.
+// It counts 24 bytes for each row slice header and 16 bytes for each interface
+// value, plus the length of strings, []byte and JsonDiff path/value, 8 bytes for
+// numbers and 24 bytes for time.Time and decimal.Decimal. Memory shared with
+// the event data or not owned by the values is not considered.
+func (e *RowsEvent) ApproxByteSize() int {
+	size := 0
+	for _, row := range e.Rows {
+		size += 24 + 16*len(row)
+		for _, v := range row {
+			size += approxValueSize(v)
+		}
+	}
+	return size
+}
+
+// ValueLiterals returns the values of the k-th row of Rows as SQL literals, the
+// way they are written in a statement, e.g. by mysqlbinlog:
+//   - NULL as NULL, numbers unquoted, UNSIGNED columns as unsigned numbers
+//   - strings and temporal values single-quoted and escaped
+//   - values of binary collation columns, BLOB and GEOMETRY values which are not valid
+//     UTF-8 as hex literals like X'00ff', BIT values as bit literals like b'101'
+//   - ENUM/SET values as their quoted labels if available, or as numbers
+//   - JSON values as the quoted JSON text, also with JSONAsNative
+//
+// Columns not present in the row image are returned as empty strings.
+// Partial JSON (*JsonDiff) can't be represented and an error is returned.
+func (e *RowsEvent) ValueLiterals(k int) ([]string, error) {
+	if k < 0 || k >= len(e.Rows) {
+		return nil, errors.Errorf("row index %d out of range, rows count %d", k, len(e.Rows))
+	}
+	if e.Table == nil {
+		return nil, errors.Trace(errMissingTableMapEvent)
+	}
+
+	var skips []int
+	if k < len(e.SkippedColumns) {
+		skips = e.SkippedColumns[k]
+	}
+	unsignedMap := e.Table.UnsignedMap()
+	row := e.Rows[k]
+	literals := make([]string, len(row))
+	p := 0
+	for i, v := range row {
+		if p < len(skips) && skips[p] == i {
+			p++
+			continue
+		}
+		if unsignedMap[i] {
+			v = e.Table.toUnsigned(i, v)
+		}
+		v, err := e.nativeJSONText(i, v)
+		if err != nil {
+			return nil, errors.Annotatef(err, "column %d", i)
+		}
+		literal, err := e.valueLiteral(i, v)
+		if err != nil {
+			return nil, errors.Annotatef(err, "column %d", i)
+		}
+		literals[i] = literal
+	}
+	return literals, nil
+}
+
+func (e *RowsEvent) valueLiteral(i int, v interface{}) (string, error) {
+	if v == nil {
+		return "NULL", nil
+	}
+	if i >= len(e.Table.ColumnType) {
+		return "", errors.Errorf("no type for column %d, column count %d", i, len(e.Table.ColumnType))
+	}
+
+	quote := func(s string) string {
+		return "'" + Escape(s) + "'"
+	}
+	collation, hasCollation := e.Table.columnCollation(i)
+	isBinary := hasCollation && collation == binaryCollationID
+
+	switch v := v.(type) {
+	case int8, int16, int32, int64, int, uint8, uint16, uint32, uint64:
+		switch e.Table.realType(i) {
+		case MYSQL_TYPE_BIT:
+			return "b'" + strconv.FormatUint(uint64(v.(int64)), 2) + "'", nil
+		case MYSQL_TYPE_ENUM, MYSQL_TYPE_SET:
+			labels := e.Table.enumSetLabels(i)
+			if len(labels) == 0 {
+				return fmt.Sprint(v), nil
+			}
+			if e.Table.IsEnumColumn(i) {
+				return quote(newEnumValue(v.(int64), labels).Label), nil
+			}
+			return quote(strings.Join(newSetValue(v.(int64), labels).Labels, ",")), nil
+		}
+		return fmt.Sprint(v), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case decimal.Decimal:
+		return v.String(), nil
+	case EnumValue:
+		if v.Label == "" && v.Index != 0 {
+			return strconv.FormatInt(v.Index, 10), nil
+		}
+		return quote(v.Label), nil
+	case SetValue:
+		if v.Labels == nil {
+			return strconv.FormatInt(v.Mask, 10), nil
+		}
+		return quote(strings.Join(v.Labels, ",")), nil
+	case fracTime:
+		return quote(v.String()), nil
+	case TimeWithDec:
+		return quote(v.String()), nil
+	case time.Time:
+		return quote(v.Format(fracTimeFormat[e.Table.columnFsp(i)])), nil
+	case PartialDate:
+		return quote(v.String()), nil
+	case MySQLTime:
+		return quote(v.String()), nil
+	case string:
+		if e.Table.realType(i) == MYSQL_TYPE_NEWDECIMAL {
+			return v, nil
+		}
+		if isBinary {
+			return "X'" + hex.EncodeToString(hack.Slice(v)) + "'", nil
+		}
+		return quote(v), nil
+	case []byte:
+		if e.Table.realType(i) == MYSQL_TYPE_BIT {
+			var b strings.Builder
+			b.WriteString("b'")
+			for j, c := range v {
+				if j == 0 {
+					b.WriteString(strconv.FormatUint(uint64(c), 2))
+				} else {
+					fmt.Fprintf(&b, "%08b", c)
+				}
+			}
+			b.WriteString("'")
+			return b.String(), nil
+		}
+		if isBinary || e.Table.IsGeometryColumn(i) || (!hasCollation && !utf8.Valid(v)) {
+			return "X'" + hex.EncodeToString(v) + "'", nil
+		}
+		return quote(hack.String(v)), nil
+	default:
+		return "", errors.Errorf("unsupported value type %T", v)
+	}
+}
+
+// AvroValues returns the k-th row of Rows keyed by column name, with the values
+// converted to the Go types of the Avro primitive types:
+//   - TINYINT, SMALLINT, MEDIUMINT, INT, YEAR, and TINYINT, SMALLINT and MEDIUMINT
+//     UNSIGNED: int32 (int)
+//   - INT UNSIGNED, BIGINT, BIT: int64 (long), BIGINT UNSIGNED values above
+//     math.MaxInt64 are an error
+//   - FLOAT: float32 (float), DOUBLE: float64 (double)
+//   - DECIMAL: []byte with the two's complement big-endian unscaled value, as
+//     the Avro decimal logical type, the scale is given by
+//     TableMapEvent.ColumnDecimalSize
+//   - ENUM, SET: string with the labels, int64 if the labels are not logged
+//   - temporal types: string, in the format of MySQL
+//   - JSON, also with JSONAsNative, and character strings: string
+//   - binary strings, BLOB, GEOMETRY: []byte, with the bytes also with BinaryAsHex
+//
+// The columns missing from the row image are not in the map, NULL values are nil.
+// Partial JSON (*JsonDiff) can't be represented and an error is returned.
+// Column names are required, see TableMapEvent.ColumnNameString.
+func (e *RowsEvent) AvroValues(k int) (map[string]interface{}, error) {
+	if k < 0 || k >= len(e.Rows) {
+		return nil, errors.Errorf("row index %d out of range, rows count %d", k, len(e.Rows))
+	}
+	if e.Table == nil {
+		return nil, errors.Trace(errMissingTableMapEvent)
+	}
+
+	names := e.Table.ColumnNameString()
+	if len(names) == 0 {
+		return nil, errors.Errorf("column names of table %s.%s are not available", e.Table.Schema, e.Table.Table)
+	}
+
+	var skips []int
+	if k < len(e.SkippedColumns) {
+		skips = e.SkippedColumns[k]
+	}
+	unsignedMap := e.Table.UnsignedMap()
+	row := e.Rows[k]
+	values := make(map[string]interface{}, len(row))
+	p := 0
+	for i, v := range row {
+		if p < len(skips) && skips[p] == i {
+			p++
+			continue
+		}
+		if i >= len(names) {
+			return nil, errors.Errorf("no name for column %d, column names count %d", i, len(names))
+		}
+		if unsignedMap[i] {
+			v = e.Table.toUnsigned(i, v)
+		}
+		v, err := e.nativeJSONText(i, v)
+		if err != nil {
+			return nil, errors.Annotatef(err, "column %d", i)
+		}
+		av, err := e.avroValue(i, v)
+		if err != nil {
+			return nil, errors.Annotatef(err, "column %d", i)
+		}
+		values[names[i]] = av
+	}
+	return values, nil
+}
+
+func (e *RowsEvent) avroValue(i int, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if i >= len(e.Table.ColumnType) {
+		return nil, errors.Errorf("no type for column %d, column count %d", i, len(e.Table.ColumnType))
+	}
+
+	switch v := v.(type) {
+	case int8:
+		return int32(v), nil
+	case int16:
+		return int32(v), nil
+	case int32:
+		return v, nil
+	case int:
+		return int32(v), nil
+	case uint8:
+		return int32(v), nil
+	case uint16:
+		return int32(v), nil
+	case uint32:
+		if e.Table.realType(i) == MYSQL_TYPE_INT24 {
+			return int32(v), nil
+		}
+		return int64(v), nil
+	case uint64:
+		if v > math.MaxInt64 {
+			return nil, errors.Errorf("value %d overflows long", v)
+		}
+		return int64(v), nil
+	case int64:
+		switch e.Table.realType(i) {
+		case MYSQL_TYPE_ENUM, MYSQL_TYPE_SET:
+			labels := e.Table.enumSetLabels(i)
+			if len(labels) == 0 {
+				return v, nil
+			}
+			if e.Table.IsEnumColumn(i) {
+				return newEnumValue(v, labels).Label, nil
+			}
+			return strings.Join(newSetValue(v, labels).Labels, ","), nil
+		}
+		return v, nil
+	case float32, float64:
+		return v, nil
+	case decimal.Decimal:
+		return e.avroDecimal(i, v)
+	case EnumValue:
+		if v.Label == "" && v.Index != 0 {
+			return v.Index, nil
+		}
+		return v.Label, nil
+	case SetValue:
+		if v.Labels == nil {
+			return v.Mask, nil
+		}
+		return strings.Join(v.Labels, ","), nil
+	case fracTime:
+		return v.String(), nil
+	case TimeWithDec:
+		return v.String(), nil
+	case time.Time:
+		return v.Format(fracTimeFormat[e.Table.columnFsp(i)]), nil
+	case PartialDate:
+		return v.String(), nil
+	case MySQLTime:
+		return v.String(), nil
+	case string:
+		if e.Table.realType(i) == MYSQL_TYPE_NEWDECIMAL {
+			d, err := decimal.NewFromString(v)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			return e.avroDecimal(i, d)
+		}
+		if e.binaryAsHex && e.Table.isBinaryStringColumn(i) {
+			// the bytes field holds the bytes, not the hex digits of BinaryAsHex
+			b, err := hex.DecodeString(v)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			return b, nil
+		}
+		if collation, ok := e.Table.columnCollation(i); ok && collation == binaryCollationID {
+			return []byte(v), nil
+		}
+		return v, nil
+	case []byte:
+		if e.Table.realType(i) == MYSQL_TYPE_JSON {
+			return string(v), nil
+		}
+		if collation, ok := e.Table.columnCollation(i); ok && collation != binaryCollationID && !e.Table.IsGeometryColumn(i) {
+			return string(v), nil
+		}
+		return v, nil
+	default:
+		return nil, errors.Errorf("unsupported value type %T", v)
+	}
+}
+
+// avroDecimal encodes d as the two's complement big-endian unscaled value
+// in the scale of the i-th column.
+func (e *RowsEvent) avroDecimal(i int, d decimal.Decimal) ([]byte, error) {
+	size, ok := e.Table.ColumnDecimalSize(i)
+	if !ok {
+		return nil, errors.Errorf("column %d is not a decimal column", i)
+	}
+	unscaled := d.Shift(int32(size.Scale))
+	if !unscaled.Equal(unscaled.Truncate(0)) {
+		return nil, errors.Errorf("value %s has more than %d decimal digits", d, size.Scale)
+	}
+	return twosComplementBytes(unscaled.BigInt()), nil
+}
+
+// twosComplementBytes returns the shortest two's complement big-endian encoding of x.
+func twosComplementBytes(x *big.Int) []byte {
+	if x.Sign() >= 0 {
+		b := x.Bytes()
+		if len(b) == 0 || b[0]&0x80 != 0 {
+			b = append([]byte{0}, b...)
+		}
+		return b
+	}
+	// -x-1 has the bits of x inverted
+	b := new(big.Int).Not(x).Bytes()
+	for j := range b {
+		b[j] = ^b[j]
+	}
+	if len(b) == 0 || b[0]&0x80 == 0 {
+		b = append([]byte{0xff}, b...)
+	}
+	return b
+}
+
+func approxValueSize(v interface{}) int {
+	switch v := v.(type) {
+	case nil:
+		return 0
+	case string:
+		return len(v)
+	case []byte:
+		return len(v)
+	case int8, int16, int32, int64, int, uint8, uint16, uint32, uint64, float32, float64:
+		return 8
+	case time.Time, decimal.Decimal, fracTime:
+		return 24
+	case TimeWithDec:
+		return 32
+	case *JsonDiff:
+		return 8 + len(v.Path) + len(v.Value)
+	default:
+		return 8
+	}
+}
+
+// nativeJSONText returns the JSON text of v if it is the value of the i-th column,
+// a JSON column, decoded by JSONAsNative, so that it is converted like the JSON
+// text decoded by default. Other values are returned as they are.
+func (e *RowsEvent) nativeJSONText(i int, v interface{}) (interface{}, error) {
+	if !e.jsonAsNative || v == nil || e.Table == nil || i >= len(e.Table.ColumnType) || e.Table.realType(i) != MYSQL_TYPE_JSON {
+		return v, nil
+	}
+	switch v.(type) {
+	case []byte, *JsonDiff:
+		// an empty value, or a partial update which has no JSON text
+		return v, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return hack.String(b), nil
+}
+
+// toUnsigned returns the integer v of the i-th column, which is UNSIGNED, as the
+// unsigned integer of the same width, since the integers are decoded as signed.
+func (e *TableMapEvent) toUnsigned(i int, v interface{}) interface{} {
+	switch v := v.(type) {
+	case int8:
+		return uint8(v)
+	case int16:
+		return uint16(v)
+	case int32:
+		if e.realType(i) == MYSQL_TYPE_INT24 {
+			return uint32(v) & 0xffffff
+		}
+		return uint32(v)
+	case int64:
+		if e.realType(i) == MYSQL_TYPE_LONGLONG {
+			return uint64(v)
+		}
+	}
+	return v
+}
+
+func toDriverValue(v interface{}) (driver.Value, error) {
+	switch v := v.(type) {
+	case nil, int64, float64, bool, []byte, string, time.Time:
+		return v, nil
+	case TimeWithDec:
+		return v.Time, nil
+	case int:
+		return int64(v), nil
+	case int8:
+		return int64(v), nil
+	case int16:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case uint8:
+		return int64(v), nil
+	case uint16:
+		return int64(v), nil
+	case uint32:
+		return int64(v), nil
+	case uint64:
+		if v > math.MaxInt64 {
+			return strconv.FormatUint(v, 10), nil
+		}
+		return int64(v), nil
+	case float32:
+		return float64(v), nil
+	case decimal.Decimal:
+		return v.String(), nil
+	case fracTime:
+		return v.Time, nil
+	case PartialDate:
+		return v.String(), nil
+	case MySQLTime:
+		return v.String(), nil
+	case EnumValue:
+		return v.Index, nil
+	case SetValue:
+		return v.Mask, nil
+	default:
+		return nil, errors.Errorf("unsupported driver value type %T", v)
+	}
+}
+
+// ValuesEqual reports whether two decoded values of a column of type tp are
+// equal, regardless of how they are represented:
+//   - DECIMAL values are compared numerically, so a decimal.Decimal equals its string
+//   - temporal values are compared as instants, so the precision doesn't matter
+//   - FLOAT/DOUBLE values are compared as float64
+//   - other values are compared bytewise, so a []byte equals its string
+//
+// tp should be the real type of the column, i.e. the Type of its ColumnInfo
+// from TableMapEvent.Columns. RowsEvent.MergedUpdates uses it to detect the
+// changed columns.
+func ValuesEqual(a, b interface{}, tp byte) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	// a partial JSON update is a change whatever the value before
+	if _, ok := a.(*JsonDiff); ok {
+		return false
+	}
+	if _, ok := b.(*JsonDiff); ok {
+		return false
+	}
+
+	switch tp {
+	case MYSQL_TYPE_NEWDECIMAL, MYSQL_TYPE_DECIMAL:
+		da, okA := toDecimal(a)
+		db, okB := toDecimal(b)
+		if okA && okB {
+			return da.Equal(db)
+		}
+	case MYSQL_TYPE_DATE, MYSQL_TYPE_NEWDATE,
+		MYSQL_TYPE_DATETIME, MYSQL_TYPE_DATETIME2,
+		MYSQL_TYPE_TIMESTAMP, MYSQL_TYPE_TIMESTAMP2:
+		ta, okA := toTime(a)
+		tb, okB := toTime(b)
+		if okA && okB {
+			return ta.Equal(tb)
+		}
+	case MYSQL_TYPE_FLOAT, MYSQL_TYPE_DOUBLE:
+		fa, okA := toFloat64(a)
+		fb, okB := toFloat64(b)
+		if okA && okB {
+			return fa == fb
+		}
+	}
 
-	var rowImageType EnumRowImageType
-	switch e.eventType {
-	case WRITE_ROWS_EVENTv0, WRITE_ROWS_EVENTv1, WRITE_ROWS_EVENTv2, MARIADB_WRITE_ROWS_COMPRESSED_EVENT_V1:
-		rowImageType = EnumRowImageTypeWriteAI
-	case DELETE_ROWS_EVENTv0, DELETE_ROWS_EVENTv1, DELETE_ROWS_EVENTv2, MARIADB_DELETE_ROWS_COMPRESSED_EVENT_V1:
-		rowImageType = EnumRowImageTypeDeleteBI
-	default:
-		rowImageType = EnumRowImageTypeUpdateBI
+	va, errA := toDriverValue(a)
+	vb, errB := toDriverValue(b)
+	if errA != nil || errB != nil {
+		return fmt.Sprint(a) == fmt.Sprint(b)
+	}
+	ba, okA := toBytes(va)
+	bb, okB := toBytes(vb)
+	if okA || okB {
+		return okA && okB && bytes.Equal(ba, bb)
 	}
+	if ta, ok := va.(time.Time); ok {
+		tb, ok := vb.(time.Time)
+		return ok && ta.Equal(tb)
+	}
+	return va == vb
+}
 
-	for pos < len(data) {
-		// Parse the first image
-		if n, err = e.decodeImage(data[pos:], e.ColumnBitmap1, rowImageType); err != nil {
-			return errors.Trace(err)
-		}
-		pos += n
+func toDecimal(v interface{}) (decimal.Decimal, bool) {
+	switch v := v.(type) {
+	case decimal.Decimal:
+		return v, true
+	case string:
+		d, err := decimal.NewFromString(v)
+		return d, err == nil
+	case []byte:
+		d, err := decimal.NewFromString(string(v))
+		return d, err == nil
+	case float64:
+		return decimal.NewFromFloat(v), true
+	}
+	dv, err := toDriverValue(v)
+	if i, ok := dv.(int64); err == nil && ok {
+		return decimal.NewFromInt(i), true
+	}
+	return decimal.Decimal{}, false
+}
 
-		// Parse the second image (for UPDATE only)
-		if e.needBitmap2 {
-			if n, err = e.decodeImage(data[pos:], e.ColumnBitmap2, EnumRowImageTypeUpdateAI); err != nil {
-				return errors.Trace(err)
-			}
-			pos += n
+func toTime(v interface{}) (time.Time, bool) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, true
+	case fracTime:
+		return v.Time, true
+	case TimeWithDec:
+		return v.Time, true
+	case string:
+		// zero and partial dates can't be parsed and are compared as strings
+		t, err := time.ParseInLocation("2006-01-02 15:04:05.999999", v, time.UTC)
+		if err != nil {
+			t, err = time.ParseInLocation("2006-01-02", v, time.UTC)
 		}
+		return t, err == nil
 	}
+	return time.Time{}, false
+}
 
-	return nil
+func toFloat64(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
 }
 
-func (e *RowsEvent) Decode(data []byte) error {
-	pos, err := e.DecodeHeader(data)
-	if err != nil {
-		return err
+func toBytes(v driver.Value) ([]byte, bool) {
+	switch v := v.(type) {
+	case []byte:
+		return v, true
+	case string:
+		return hack.Slice(v), true
 	}
-	return e.DecodeData(pos, data)
+	return nil, false
 }
 
 func isBitSet(bitmap []byte, i int) bool {
@@ -1126,7 +3434,80 @@ func isBitSetIncr(bitmap []byte, i *int) bool {
 	return v
 }
 
+// EnumValue is the value of an enum column decoded with
+// BinlogSyncerConfig.EnumSetWithLabels.
+type EnumValue struct {
+	// Index is 1-based, 0 means the empty string stored for an invalid value.
+	Index int64
+	// Label is empty if the enum values are not available, see TableMapEvent.EnumStrValue.
+	Label string
+}
+
+// newEnumValue resolves the 1-based enum index like MySQL: index 1 is labels[0],
+// the first member of the enum (EnumStrValue[col][0]). Index 0 is the empty string
+// MySQL stores for an invalid value and is resolved to an empty Label, as are
+// indexes beyond the labels.
+func newEnumValue(index int64, labels []string) EnumValue {
+	v := EnumValue{Index: index}
+	if index > 0 && int(index) <= len(labels) {
+		v.Label = labels[index-1]
+	}
+	return v
+}
+
+// enumSetLabel returns the label of the i-th column converted to UTF-8 if transcodeToUTF8
+// is set and without the trailing spaces if trimEnumSetLabels is set, like SELECT returns
+// it. The labels of TableMapEvent are not changed.
+func (e *RowsEvent) enumSetLabel(i int, label string) string {
+	if e.transcodeToUTF8 {
+		collation, hasCollation := e.Table.enumSetCollation(i)
+		label = toUTF8(label, collation, hasCollation, e.defaultCharset)
+	}
+	if e.trimEnumSetLabels {
+		label = strings.TrimRight(label, " ")
+	}
+	return label
+}
+
+// SetValue is the value of a set column decoded with
+// BinlogSyncerConfig.EnumSetWithLabels.
+type SetValue struct {
+	// Mask has the i-th bit set if the i-th value is in the set.
+	Mask int64
+	// Labels is nil if the set values are not available, see TableMapEvent.SetStrValue.
+	Labels []string
+}
+
+func newSetValue(mask int64, labels []string) SetValue {
+	v := SetValue{Mask: mask}
+	if len(labels) == 0 {
+		return v
+	}
+	v.Labels = []string{}
+	for i := 0; i < len(labels) && i < 64; i++ {
+		if uint64(mask)&(1<<uint(i)) != 0 {
+			v.Labels = append(v.Labels, labels[i])
+		}
+	}
+	return v
+}
+
 func (e *RowsEvent) decodeImage(data []byte, bitmap []byte, rowImageType EnumRowImageType) (int, error) {
+	row := make([]interface{}, e.ColumnCount)
+	skips := make([]int, 0)
+	n, err := e.decodeImageTo(data, bitmap, rowImageType, row, &skips)
+	if err != nil {
+		return 0, err
+	}
+	e.Rows = append(e.Rows, row)
+	e.SkippedColumns = append(e.SkippedColumns, skips)
+	return n, nil
+}
+
+// decodeImageTo decodes a row image into row, which has ColumnCount values, and the
+// indexes of the skipped columns into *skips, which is truncated first so that both
+// can be reused.
+func (e *RowsEvent) decodeImageTo(data []byte, bitmap []byte, rowImageType EnumRowImageType, row []interface{}, skips *[]int) (int, error) {
 	// Rows_log_event::print_verbose_one_row()
 
 	pos := 0
@@ -1145,17 +3526,10 @@ func (e *RowsEvent) decodeImage(data []byte, bitmap []byte, rowImageType EnumRow
 		}
 	}
 
-	row := make([]interface{}, e.ColumnCount)
-	skips := make([]int, 0)
+	*skips = (*skips)[:0]
 
 	// refer: https://github.com/alibaba/canal/blob/c3e38e50e269adafdd38a48c63a1740cde304c67/dbsync/src/main/java/com/taobao/tddl/dbsync/binlog/event/RowsLogBuffer.java#L63
-	count := 0
-	for i := 0; i < int(e.ColumnCount); i++ {
-		if isBitSet(bitmap, i) {
-			count++
-		}
-	}
-	count = bitmapByteSize(count)
+	count := NullBitmapSize(bitmap, int(e.ColumnCount))
 
 	nullBitmap := data[pos : pos+count]
 	pos += count
@@ -1175,30 +3549,92 @@ func (e *RowsEvent) decodeImage(data []byte, bitmap []byte, rowImageType EnumRow
 			isBitSetIncr(partialBitmap, &partialBitmapIndex)
 
 		if !isBitSet(bitmap, i) {
-			skips = append(skips, i)
+			row[i] = nil
+			*skips = append(*skips, i)
+			e.stats.SkippedColumns++
 			continue
 		}
 
 		if isBitSetIncr(nullBitmap, &nullBitmapIndex) {
 			row[i] = nil
+			e.stats.Nulls++
 			continue
 		}
 
 		var n int
 		var err error
-		row[i], n, err = e.decodeValue(data[pos:], e.Table.ColumnType[i], e.Table.ColumnMeta[i], isPartial)
+		switch {
+		case e.legacyDecimalLengthFunc != nil && e.Table.ColumnType[i] == MYSQL_TYPE_DECIMAL:
+			row[i], n, err = e.decodeLegacyDecimal(data[pos:], i)
+		case e.blobDecodeFunc != nil && e.Table.ColumnType[i] == MYSQL_TYPE_BLOB:
+			row[i], n, err = e.decodeBlobWithFunc(data[pos:], i)
+		default:
+			row[i], n, err = e.decodeValue(data[pos:], e.Table.ColumnType[i], e.Table.ColumnMeta[i], isPartial)
+		}
 
 		if err != nil {
 			return 0, err
 		}
 		pos += n
+
+		if e.binaryAsBytes || e.transcodeToUTF8 {
+			if s, ok := row[i].(string); ok && e.Table.IsCharacterColumn(i) {
+				collation, hasCollation := e.Table.columnCollation(i)
+				if e.binaryAsBytes && hasCollation && collation == binaryCollationID {
+					row[i] = []byte(s)
+				} else if e.transcodeToUTF8 {
+					row[i] = toUTF8(s, collation, hasCollation, e.defaultCharset)
+				}
+			}
+		}
+
+		if e.binaryAsHex && e.Table.isBinaryStringColumn(i) {
+			switch v := row[i].(type) {
+			case []byte:
+				row[i] = hexString(v, e.binaryAsUpperHex)
+			case string:
+				row[i] = hexString(hack.Slice(v), e.binaryAsUpperHex)
+			}
+		}
+
+		if e.enumSetWithLabels {
+			if v, ok := row[i].(int64); ok {
+				if e.Table.IsEnumColumn(i) {
+					ev := newEnumValue(v, e.Table.enumSetLabels(i))
+					ev.Label = e.enumSetLabel(i, ev.Label)
+					row[i] = ev
+				} else if e.Table.IsSetColumn(i) {
+					sv := newSetValue(v, e.Table.enumSetLabels(i))
+					for j, label := range sv.Labels {
+						sv.Labels[j] = e.enumSetLabel(i, label)
+					}
+					row[i] = sv
+				}
+			}
+		}
 	}
 
-	e.Rows = append(e.Rows, row)
-	e.SkippedColumns = append(e.SkippedColumns, skips)
+	e.stats.Rows++
 	return pos, nil
 }
 
+// DecodeStats returns the counters of the last DecodeData.
+func (e *RowsEvent) DecodeStats() RowsEventDecodeStats {
+	return e.stats
+}
+
+// timestampLocation returns the location the TIMESTAMP values are formatted in
+// when they are decoded as strings, see parseFracTime.
+func (e *RowsEvent) timestampLocation() *time.Location {
+	if e.temporalStringLocation != nil {
+		return e.temporalStringLocation
+	}
+	if e.timestampStringLocation != nil {
+		return e.timestampStringLocation
+	}
+	return time.Local
+}
+
 func (e *RowsEvent) parseFracTime(t interface{}) interface{} {
 	v, ok := t.(fracTime)
 	if !ok {
@@ -1206,10 +3642,17 @@ func (e *RowsEvent) parseFracTime(t interface{}) interface{} {
 	}
 
 	if !e.parseTime {
+		if e.temporalStringLocation != nil {
+			v.timestampStringLocation = e.temporalStringLocation
+		}
 		// Don't parse time, return string directly
 		return v.String()
 	}
 
+	if e.useTimeWithDec {
+		return TimeWithDec{Time: v.Time, Dec: v.Dec}
+	}
+
 	// return Golang time directly
 	return v.Time
 }
@@ -1218,21 +3661,12 @@ func (e *RowsEvent) parseFracTime(t interface{}) interface{} {
 func (e *RowsEvent) decodeValue(data []byte, tp byte, meta uint16, isPartial bool) (v interface{}, n int, err error) {
 	var length = 0
 
+	// ENUM and SET columns are logged as MYSQL_TYPE_STRING with the real type in the
+	// high byte of meta, like CHAR columns longer than 255 bytes have bits of the
+	// length there. Recover it like TableMapEvent.realType, so that they are decoded
+	// by the ENUM and SET cases below.
 	if tp == MYSQL_TYPE_STRING {
-		if meta >= 256 {
-			b0 := uint8(meta >> 8)
-			b1 := uint8(meta & 0xFF)
-
-			if b0&0x30 != 0x30 {
-				length = int(uint16(b1) | (uint16((b0&0x30)^0x30) << 4))
-				tp = b0 | 0x30
-			} else {
-				length = int(meta & 0xFF)
-				tp = b0
-			}
-		} else {
-			length = int(meta)
-		}
+		tp, length = realStringType(meta)
 	}
 
 	switch tp {
@@ -1256,7 +3690,7 @@ func (e *RowsEvent) decodeValue(data []byte, tp byte, meta uint16, isPartial boo
 	case MYSQL_TYPE_NEWDECIMAL:
 		prec := uint8(meta >> 8)
 		scale := uint8(meta & 0xFF)
-		v, n, err = decodeDecimal(data, int(prec), int(scale), e.useDecimal)
+		v, n, err = decodeDecimalScratch(data, int(prec), int(scale), e.useDecimal, &e.decimalScratch)
 	case MYSQL_TYPE_FLOAT:
 		n = 4
 		v = ParseBinaryFloat32(data)
@@ -1264,11 +3698,19 @@ func (e *RowsEvent) decodeValue(data []byte, tp byte, meta uint16, isPartial boo
 		n = 8
 		v = ParseBinaryFloat64(data)
 	case MYSQL_TYPE_BIT:
+		// the high byte of meta is the number of whole bytes, the low byte the bits in the last partial byte
 		nbits := ((meta >> 8) * 8) + (meta & 0xFF)
 		n = int(nbits+7) / 8
 
-		// use int64 for bit
-		v, err = decodeBit(data, int(nbits), n)
+		if e.bitAsBytes {
+			if len(data) < n {
+				return nil, 0, errors.Errorf("bit(%d) needs %d bytes but got %d", nbits, n, len(data))
+			}
+			v = data[0:n]
+		} else {
+			// use int64 for bit
+			v, err = decodeBit(data, int(nbits), n)
+		}
 	case MYSQL_TYPE_TIMESTAMP:
 		n = 4
 		t := binary.LittleEndian.Uint32(data)
@@ -1287,11 +3729,19 @@ func (e *RowsEvent) decodeValue(data []byte, tp byte, meta uint16, isPartial boo
 	case MYSQL_TYPE_DATETIME:
 		n = 8
 		i64 := binary.LittleEndian.Uint64(data)
-		if i64 == 0 {
+		d := i64 / 1000000
+		t := i64 % 1000000
+		if e.usePartialDate && ((d%10000)/100 == 0 || d%100 == 0) {
+			v = PartialDate{
+				Year: int(d / 10000), Month: int((d % 10000) / 100), Day: int(d % 100),
+				Hour: int(t / 10000), Minute: int((t % 10000) / 100), Second: int(t % 100),
+			}
+		} else if i64 == 0 {
 			v = formatZeroTime(0, 0)
+		} else if isTimeOfDayOutOfRange(int(t/10000), int((t%10000)/100), int(t%100)) {
+			v = formatBeforeUnixZeroTime(int(d/10000), int((d%10000)/100), int(d%100),
+				int(t/10000), int((t%10000)/100), int(t%100), 0, 0)
 		} else {
-			d := i64 / 1000000
-			t := i64 % 1000000
 			v = e.parseFracTime(fracTime{
 				Time: time.Date(
 					int(d/10000),
@@ -1307,22 +3757,43 @@ func (e *RowsEvent) decodeValue(data []byte, tp byte, meta uint16, isPartial boo
 			})
 		}
 	case MYSQL_TYPE_DATETIME2:
-		v, n, err = decodeDatetime2(data, meta)
+		v, n, err = decodeDatetime2(data, meta, e.usePartialDate)
 		v = e.parseFracTime(v)
 	case MYSQL_TYPE_TIME:
 		n = 3
 		i32 := uint32(FixedLengthInt(data[0:3]))
-		if i32 == 0 {
+		if e.useMySQLTime {
+			// the value is signed, HHMMSS for positive values
+			hms := int32(i32<<8) >> 8
+			t := MySQLTime{Negative: hms < 0}
+			if hms < 0 {
+				hms = -hms
+			}
+			t.Hours, t.Minutes, t.Seconds = int(hms/10000), int(hms%10000/100), int(hms%100)
+			v = t
+		} else if i32 == 0 {
 			v = "00:00:00"
 		} else {
 			v = fmt.Sprintf("%02d:%02d:%02d", i32/10000, (i32%10000)/100, i32%100)
 		}
 	case MYSQL_TYPE_TIME2:
-		v, n, err = decodeTime2(data, meta)
-	case MYSQL_TYPE_DATE:
+		var t MySQLTime
+		t, n, err = decodeTime2Value(data, meta)
+		if e.useMySQLTime {
+			v = t
+		} else {
+			v = t.String()
+		}
+	case MYSQL_TYPE_DATE, MYSQL_TYPE_NEWDATE:
+		// MySQL logs DATE columns as MYSQL_TYPE_DATE, but the value is always
+		// in the 3 bytes packed format of NEWDATE (Field_newdate), i.e.
+		// year*16*32 + month*32 + day, so both types are decoded the same way.
+		// The old 4 bytes format (YYYYMMDD) of DATE is never logged.
 		n = 3
 		i32 := uint32(FixedLengthInt(data[0:3]))
-		if i32 == 0 {
+		if e.usePartialDate && (i32/32%16 == 0 || i32%32 == 0) {
+			v = PartialDate{Year: int(i32 / (16 * 32)), Month: int(i32 / 32 % 16), Day: int(i32 % 32), DateOnly: true}
+		} else if i32 == 0 {
 			v = "0000-00-00"
 		} else {
 			v = fmt.Sprintf("%04d-%02d-%02d", i32/(16*32), i32/32%16, i32%32)
@@ -1358,12 +3829,14 @@ func (e *RowsEvent) decodeValue(data []byte, tp byte, meta uint16, isPartial boo
 	case MYSQL_TYPE_VARCHAR,
 		MYSQL_TYPE_VAR_STRING:
 		length = int(meta)
-		v, n = decodeString(data, length)
+		v, n, err = decodeString(data, length)
 	case MYSQL_TYPE_STRING:
-		v, n = decodeString(data, length)
+		v, n, err = decodeString(data, length)
 	case MYSQL_TYPE_JSON:
 		// Refer: https://github.com/shyiko/mysql-binlog-connector-java/blob/master/src/main/java/com/github/shyiko/mysql/binlog/event/deserialization/AbstractRowsEventDataDeserializer.java#L404
-		length = int(FixedLengthInt(data[0:meta]))
+		if length, err = readLength(data, int(meta)); err != nil {
+			return nil, 0, errors.Annotate(err, "json")
+		}
 		n = length + int(meta)
 
 		/*
@@ -1386,9 +3859,12 @@ func (e *RowsEvent) decodeValue(data []byte, tp byte, meta uint16, isPartial boo
 				diff, err = e.decodeJsonPartialBinary(data[meta:n])
 				if err == nil {
 					v = diff
+					e.stats.JSONPartialDiffs++
 				} else {
 					fmt.Printf("decodeJsonPartialBinary(%q) fail: %s\n", data[meta:n], err)
 				}
+			} else if e.jsonAsNative {
+				v, err = e.decodeJsonBinaryValue(data[meta:n], true)
 			} else {
 				var d []byte
 				d, err = e.decodeJsonBinary(data[meta:n])
@@ -1404,27 +3880,78 @@ func (e *RowsEvent) decodeValue(data []byte, tp byte, meta uint16, isPartial boo
 		// Refer https://dev.mysql.com/doc/refman/5.7/en/gis-wkb-functions.html
 		// I also find some go libs to handle WKB if possible
 		// see https://github.com/twpayne/go-geom or https://github.com/paulmach/go.geo
+		// MariaDB logs it the same way, although IsCharacterColumn is true there,
+		// so the value is []byte for both flavors. GeometryToGeoJSON converts it.
 		v, n, err = decodeBlob(data, meta)
+	case MYSQL_TYPE_DECIMAL:
+		// The DECIMAL of MySQL before 5.0.3, still found in tables created by old
+		// MySQL or MariaDB servers, is the ASCII string of the value padded to the
+		// field length. The field length is not logged (meta is 0), so the value is
+		// only decoded with legacyDecimalLengthFunc, see decodeLegacyDecimal, or by
+		// unknownTypeDecodeFunc.
+		if e.unknownTypeDecodeFunc != nil {
+			return e.decodeUnknownType(tp, meta, data)
+		}
+		err = errors.Errorf("legacy decimal type %d has no field length in binlog, set LegacyDecimalLengthFunc to decode it", tp)
 	default:
+		if e.unknownTypeDecodeFunc != nil {
+			return e.decodeUnknownType(tp, meta, data)
+		}
 		err = fmt.Errorf("unsupport type %d in binlog and don't know how to handle", tp)
 	}
 
 	return v, n, err
 }
 
-func decodeString(data []byte, length int) (v string, n int) {
-	if length < 256 {
-		length = int(data[0])
+// realStringType returns the real type, e.g. MYSQL_TYPE_ENUM, and the max length
+// of a MYSQL_TYPE_STRING column from its meta.
+func realStringType(meta uint16) (tp byte, length int) {
+	if meta < 256 {
+		return MYSQL_TYPE_STRING, int(meta)
+	}
+
+	b0 := uint8(meta >> 8)
+	b1 := uint8(meta & 0xFF)
+	if b0&0x30 != 0x30 {
+		return b0 | 0x30, int(uint16(b1) | (uint16((b0&0x30)^0x30) << 4))
+	}
+	return b0, int(meta & 0xFF)
+}
 
-		n = length + 1
-		v = hack.String(data[1:n])
+func decodeString(data []byte, length int) (v string, n int, err error) {
+	prefix := 1
+	if length >= 256 {
+		prefix = 2
+	}
+	if len(data) < prefix {
+		return "", 0, errors.Annotatef(ErrTruncatedString, "length needs %d bytes but got %d", prefix, len(data))
+	}
+	if prefix == 1 {
+		length = int(data[0])
 	} else {
 		length = int(binary.LittleEndian.Uint16(data[0:]))
-		n = length + 2
-		v = hack.String(data[2:n])
 	}
 
-	return
+	n = length + prefix
+	if n > len(data) {
+		return "", 0, errors.Annotatef(ErrTruncatedString, "string of %d bytes but got %d", length, len(data)-prefix)
+	}
+	v = hack.String(data[prefix:n])
+	return v, n, nil
+}
+
+// hexString returns the hex encoding of b, in uppercase like HEX() if upper is true.
+func hexString(b []byte, upper bool) string {
+	buf := make([]byte, hex.EncodedLen(len(b)))
+	hex.Encode(buf, b)
+	if upper {
+		for i, c := range buf {
+			if c >= 'a' {
+				buf[i] = c - 'a' + 'A'
+			}
+		}
+	}
+	return hack.String(buf)
 }
 
 // ref: https://github.com/mysql/mysql-server/blob/a9b0c712de3509d8d08d3ba385d41a4df6348775/strings/decimal.c#L137
@@ -1450,7 +3977,105 @@ func decodeDecimalDecompressValue(compIndx int, data []byte, mask uint8) (size i
 
 var zeros = [digitsPerInteger]byte{48, 48, 48, 48, 48, 48, 48, 48, 48}
 
+// the max precision and scale of DECIMAL in MySQL
+const (
+	decimalMaxPrecision = 65
+	decimalMaxScale     = 30
+)
+
+// checkDecimalMeta returns ErrInvalidDecimalMeta if DECIMAL(precision, decimals) is not
+// a valid column definition.
+func checkDecimalMeta(precision int, decimals int) error {
+	if precision < 1 || precision > decimalMaxPrecision || decimals < 0 || decimals > decimalMaxScale || decimals > precision {
+		return errors.Annotatef(ErrInvalidDecimalMeta, "invalid decimal(%d,%d), precision must be in [1, %d] and scale in [0, min(precision, %d)]",
+			precision, decimals, decimalMaxPrecision, decimalMaxScale)
+	}
+	return nil
+}
+
+// DecodeLegacyDecimal decodes the value of a legacy DECIMAL (MYSQL_TYPE_DECIMAL) column,
+// data being the whole field, e.g. "  -12.50" for a DECIMAL(6,2), which is padded with
+// spaces, or zeros for ZEROFILL, to the field length. It returns the value without the
+// padding, e.g. "-12.50". The field length is the display width of the column plus the
+// point and sign, it must be known from the schema as it's not logged, see
+// BinlogSyncerConfig.LegacyDecimalLengthFunc.
+func DecodeLegacyDecimal(data []byte) (string, error) {
+	s := strings.TrimLeft(string(data), " ")
+	sign := ""
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		if s[0] == '-' {
+			sign = "-"
+		}
+		s = s[1:]
+	}
+	intPart, fracPart, hasPoint := strings.Cut(s, ".")
+	if intPart == "" && fracPart == "" {
+		return "", errors.Errorf("invalid legacy decimal %q", data)
+	}
+	for _, part := range []string{intPart, fracPart} {
+		for i := 0; i < len(part); i++ {
+			if part[i] < '0' || part[i] > '9' {
+				return "", errors.Errorf("invalid legacy decimal %q", data)
+			}
+		}
+	}
+	intPart = strings.TrimLeft(intPart, "0")
+	if intPart == "" {
+		intPart = "0"
+	}
+	if hasPoint && fracPart != "" {
+		return sign + intPart + "." + fracPart, nil
+	}
+	return sign + intPart, nil
+}
+
+// decodeUnknownType decodes a value with unknownTypeDecodeFunc, checking the number
+// of bytes it consumed, so that a wrong one fails the row image instead of moving
+// the position out of data.
+func (e *RowsEvent) decodeUnknownType(tp byte, meta uint16, data []byte) (interface{}, int, error) {
+	v, n, err := e.unknownTypeDecodeFunc(tp, meta, data)
+	if err != nil {
+		return nil, 0, err
+	}
+	if n < 0 || n > len(data) {
+		return nil, 0, errors.Errorf("unknown type %d decode function consumed %d bytes of %d", tp, n, len(data))
+	}
+	return v, n, nil
+}
+
+// decodeLegacyDecimal decodes the legacy DECIMAL value of the i-th column with the
+// field length returned by legacyDecimalLengthFunc.
+func (e *RowsEvent) decodeLegacyDecimal(data []byte, i int) (interface{}, int, error) {
+	length := e.legacyDecimalLengthFunc(string(e.Table.Schema), string(e.Table.Table), i)
+	if length <= 0 {
+		return nil, 0, errors.Errorf("invalid field length %d of legacy decimal column %d", length, i)
+	}
+	if len(data) < length {
+		return nil, 0, errors.Annotatef(io.ErrUnexpectedEOF, "legacy decimal column %d needs %d bytes but got %d", i, length, len(data))
+	}
+	s, err := DecodeLegacyDecimal(data[:length])
+	if err != nil {
+		return nil, 0, err
+	}
+	if e.useDecimal {
+		d, err := decimal.NewFromString(s)
+		return d, length, errors.Trace(err)
+	}
+	return s, length, nil
+}
+
 func decodeDecimal(data []byte, precision int, decimals int, useDecimal bool) (interface{}, int, error) {
+	return decodeDecimalScratch(data, precision, decimals, useDecimal, nil)
+}
+
+// decodeDecimalScratch is decodeDecimal using *scratch, if not nil, for the copy of
+// the binary value and the building of the string, so that decoding many values
+// doesn't allocate for them. The grown buffer is stored back to *scratch.
+func decodeDecimalScratch(data []byte, precision int, decimals int, useDecimal bool, scratch *[]byte) (interface{}, int, error) {
+	if err := checkDecimalMeta(precision, decimals); err != nil {
+		return nil, 0, err
+	}
+
 	// see python mysql replication and https://github.com/jeremycole/mysql_binlog
 	integral := precision - decimals
 	uncompIntegral := integral / digitsPerInteger
@@ -1458,25 +4083,37 @@ func decodeDecimal(data []byte, precision int, decimals int, useDecimal bool) (i
 	compIntegral := integral - (uncompIntegral * digitsPerInteger)
 	compFractional := decimals - (uncompFractional * digitsPerInteger)
 
-	binSize := uncompIntegral*4 + compressedBytes[compIntegral] +
-		uncompFractional*4 + compressedBytes[compFractional]
+	binSize := decimalBinSize(precision, decimals)
+
+	if len(data) < binSize {
+		return nil, 0, errors.Annotatef(ErrTruncatedDecimal, "decimal(%d,%d) needs %d bytes but got %d",
+			precision, decimals, binSize, len(data))
+	}
 
-	buf := make([]byte, binSize)
-	copy(buf, data[:binSize])
+	// the binary value is followed by the string, at most sign, point and the digits
+	var buf []byte
+	if scratch != nil {
+		buf = *scratch
+	}
+	if need := binSize + precision + 3; cap(buf) < need {
+		buf = make([]byte, 0, need)
+	}
+	if scratch != nil {
+		*scratch = buf
+	}
 
 	// must copy the data for later change
-	data = buf
+	data = append(buf[:0], data[:binSize]...)
+	res := data[binSize:binSize]
 
 	// Support negative
 	// The sign is encoded in the high bit of the the byte
 	// But this bit can also be used in the value
 	value := uint32(data[0])
-	var res strings.Builder
-	res.Grow(precision + 2)
 	var mask uint32 = 0
 	if value&0x80 == 0 {
 		mask = uint32((1 << 32) - 1)
-		res.WriteString("-")
+		res = append(res, '-')
 	}
 
 	// clear sign
@@ -1487,7 +4124,7 @@ func decodeDecimal(data []byte, precision int, decimals int, useDecimal bool) (i
 	pos, value := decodeDecimalDecompressValue(compIntegral, data, uint8(mask))
 	if value != 0 {
 		zeroLeading = false
-		res.WriteString(strconv.FormatUint(uint64(value), 10))
+		res = strconv.AppendUint(res, uint64(value), 10)
 	}
 
 	for i := 0; i < uncompIntegral; i++ {
@@ -1496,47 +4133,144 @@ func decodeDecimal(data []byte, precision int, decimals int, useDecimal bool) (i
 		if zeroLeading {
 			if value != 0 {
 				zeroLeading = false
-				res.WriteString(strconv.FormatUint(uint64(value), 10))
+				res = strconv.AppendUint(res, uint64(value), 10)
 			}
 		} else {
-			toWrite := strconv.FormatUint(uint64(value), 10)
-			res.Write(zeros[:digitsPerInteger-len(toWrite)])
-			res.WriteString(toWrite)
+			res = appendZeroPadded(res, value, digitsPerInteger)
 		}
 	}
 
 	if zeroLeading {
-		res.WriteString("0")
+		res = append(res, '0')
 	}
 
 	if pos < len(data) {
-		res.WriteString(".")
+		res = append(res, '.')
 
 		for i := 0; i < uncompFractional; i++ {
 			value = binary.BigEndian.Uint32(data[pos:]) ^ mask
 			pos += 4
-			toWrite := strconv.FormatUint(uint64(value), 10)
-			res.Write(zeros[:digitsPerInteger-len(toWrite)])
-			res.WriteString(toWrite)
+			res = appendZeroPadded(res, value, digitsPerInteger)
 		}
 
 		if size, value := decodeDecimalDecompressValue(compFractional, data[pos:], uint8(mask)); size > 0 {
-			toWrite := strconv.FormatUint(uint64(value), 10)
-			padding := compFractional - len(toWrite)
-			if padding > 0 {
-				res.Write(zeros[:padding])
-			}
-			res.WriteString(toWrite)
+			res = appendZeroPadded(res, value, compFractional)
 			pos += size
 		}
 	}
 
 	if useDecimal {
-		f, err := decimal.NewFromString(res.String())
+		// NewFromString doesn't keep the string
+		f, err := decimal.NewFromString(hack.String(res))
 		return f, pos, err
 	}
 
-	return res.String(), pos, nil
+	return string(res), pos, nil
+}
+
+// decimalBinSize returns the length of the binary DECIMAL(precision, decimals) format.
+func decimalBinSize(precision int, decimals int) int {
+	integral := precision - decimals
+	uncompIntegral := integral / digitsPerInteger
+	uncompFractional := decimals / digitsPerInteger
+	compIntegral := integral - (uncompIntegral * digitsPerInteger)
+	compFractional := decimals - (uncompFractional * digitsPerInteger)
+
+	return uncompIntegral*4 + compressedBytes[compIntegral] +
+		uncompFractional*4 + compressedBytes[compFractional]
+}
+
+// appendZeroPadded appends the decimal digits of v left padded with zeros to width.
+func appendZeroPadded(b []byte, v uint32, width int) []byte {
+	digits := 1
+	for x := v; x >= 10; x /= 10 {
+		digits++
+	}
+	if digits < width {
+		b = append(b, zeros[:width-digits]...)
+	}
+	return strconv.AppendUint(b, uint64(v), 10)
+}
+
+// encodeDecimal is the inverse of decodeDecimal, it encodes a decimal string like
+// "-123.45" to the binary DECIMAL(precision, scale) format. Missing fractional digits
+// are padded with zeros, an error is returned if the value doesn't fit.
+func encodeDecimal(value string, precision int, decimals int) ([]byte, error) {
+	if err := checkDecimalMeta(precision, decimals); err != nil {
+		return nil, err
+	}
+
+	s := strings.TrimSpace(value)
+	negative := false
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		negative = s[0] == '-'
+		s = s[1:]
+	}
+	intPart, fracPart := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, fracPart = s[:i], s[i+1:]
+	}
+	if intPart == "" && fracPart == "" || !isDigits(intPart) || !isDigits(fracPart) {
+		return nil, errors.Errorf("invalid decimal value %q", value)
+	}
+
+	integral := precision - decimals
+	intPart = strings.TrimLeft(intPart, "0")
+	fracPart = strings.TrimRight(fracPart, "0")
+	if len(intPart) > integral || len(fracPart) > decimals {
+		return nil, errors.Errorf("decimal value %q is out of range of decimal(%d,%d)", value, precision, decimals)
+	}
+	if intPart == "" && fracPart == "" {
+		// there is no negative zero
+		negative = false
+	}
+	digits := strings.Repeat("0", integral-len(intPart)) + intPart +
+		fracPart + strings.Repeat("0", decimals-len(fracPart))
+
+	uncompIntegral := integral / digitsPerInteger
+	uncompFractional := decimals / digitsPerInteger
+	compIntegral := integral - (uncompIntegral * digitsPerInteger)
+	compFractional := decimals - (uncompFractional * digitsPerInteger)
+
+	// the digits are stored in groups of 9 in 4 bytes, the leading integral
+	// digits and the trailing fractional digits are stored in fewer bytes
+	groups := make([]int, 0, uncompIntegral+uncompFractional+2)
+	groups = append(groups, compIntegral)
+	for i := 0; i < uncompIntegral+uncompFractional; i++ {
+		groups = append(groups, digitsPerInteger)
+	}
+	groups = append(groups, compFractional)
+
+	data := make([]byte, 0, uncompIntegral*4+compressedBytes[compIntegral]+uncompFractional*4+compressedBytes[compFractional])
+	for _, n := range groups {
+		if n == 0 {
+			continue
+		}
+		v, _ := strconv.ParseUint(digits[:n], 10, 32)
+		digits = digits[n:]
+
+		var buf [4]byte
+		binary.BigEndian.PutUint32(buf[:], uint32(v))
+		data = append(data, buf[4-compressedBytes[n]:]...)
+	}
+
+	if negative {
+		for i := range data {
+			data[i] = ^data[i]
+		}
+	}
+	data[0] ^= 0x80
+
+	return data, nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
 }
 
 func decodeBit(data []byte, nbits int, length int) (value int64, err error) {
@@ -1604,6 +4338,10 @@ func littleDecodeBit(data []byte, nbits int, length int) (value int64, err error
 }
 
 func decodeTimestamp2(data []byte, dec uint16, timestampStringLocation *time.Location) (interface{}, int, error) {
+	if err := checkTemporal2Fsp("TIMESTAMP2", dec); err != nil {
+		return nil, 0, err
+	}
+
 	// get timestamp binary length
 	n := int(4 + (dec+1)/2)
 	sec := int64(binary.BigEndian.Uint32(data[0:4]))
@@ -1616,6 +4354,11 @@ func decodeTimestamp2(data []byte, dec uint16, timestampStringLocation *time.Loc
 	case 5, 6:
 		usec = int64(BFixedLengthInt(data[4:7]))
 	}
+	// an odd dec is stored with one more digit, drop it so that the time
+	// doesn't have more precision than the declared fsp
+	if dec < 6 {
+		usec -= usec % int64(math.Pow10(6-int(dec)))
+	}
 
 	if sec == 0 {
 		return formatZeroTime(int(usec), int(dec)), n, nil
@@ -1630,7 +4373,21 @@ func decodeTimestamp2(data []byte, dec uint16, timestampStringLocation *time.Loc
 
 const DATETIMEF_INT_OFS int64 = 0x8000000000
 
-func decodeDatetime2(data []byte, dec uint16) (interface{}, int, error) {
+// checkTemporal2Fsp checks the fsp of a TIMESTAMP2, DATETIME2 or TIME2 column, which is
+// its meta. The fraction takes (fsp+1)/2 bytes: none for fsp 0, 1 byte for 1 and 2,
+// 2 bytes for 3 and 4 and 3 bytes for 5 and 6, in units of 10^(6-2*bytes) microseconds.
+func checkTemporal2Fsp(tp string, dec uint16) error {
+	if dec > 6 {
+		return errors.Errorf("invalid %s fsp %d, must be in [0, 6]", tp, dec)
+	}
+	return nil
+}
+
+func decodeDatetime2(data []byte, dec uint16, usePartialDate bool) (interface{}, int, error) {
+	if err := checkTemporal2Fsp("DATETIME2", dec); err != nil {
+		return nil, 0, err
+	}
+
 	// get datetime binary length
 	n := int(5 + (dec+1)/2)
 
@@ -1646,15 +4403,19 @@ func decodeDatetime2(data []byte, dec uint16) (interface{}, int, error) {
 		frac = int64(BFixedLengthInt(data[5:8]))
 	}
 
-	if intPart == 0 {
+	// MySQL stores DATETIME2 as a signed value, but a negative value is never
+	// written since DATETIME can't be negative (see TIME_to_longlong_datetime_packed),
+	// so it can only come from a corrupted event. Negating it like TIME2 would
+	// decode a nonsense date, so report an error instead.
+	if intPart < 0 {
+		return nil, 0, errors.Errorf("invalid negative DATETIME2 value %d", intPart)
+	}
+
+	if intPart == 0 && !usePartialDate {
 		return formatZeroTime(int(frac), int(dec)), n, nil
 	}
 
 	tmp := intPart<<24 + frac
-	// handle sign???
-	if tmp < 0 {
-		tmp = -tmp
-	}
 
 	// var secPart int64 = tmp % (1 << 24)
 	ymdhms := tmp >> 24
@@ -1671,6 +4432,14 @@ func decodeDatetime2(data []byte, dec uint16) (interface{}, int, error) {
 	minute := int((hms >> 6) % (1 << 6))
 	hour := int(hms >> 12)
 
+	if usePartialDate && (month == 0 || day == 0) {
+		return PartialDate{
+			Year: year, Month: month, Day: day,
+			Hour: hour, Minute: minute, Second: second,
+			Microsecond: int(frac), Dec: int(dec),
+		}, n, nil
+	}
+
 	// DATETIME encoding for nonfractional part after MySQL 5.6.4
 	// https://dev.mysql.com/doc/internals/en/date-and-time-data-type-representation.html
 	// integer value for 1970-01-01 00:00:00 is
@@ -1680,7 +4449,8 @@ func decodeDatetime2(data []byte, dec uint16) (interface{}, int, error) {
 	// minute = 0 = 0b000000
 	// second = 0 = 0b000000
 	// integer value = 0b1100100000010110000100000000000000000 = 107420450816
-	if intPart < 107420450816 {
+	// the value is kept as a string without normalizing it when time.Time can't represent it
+	if intPart < 107420450816 || isTimeOfDayOutOfRange(hour, minute, second) {
 		return formatBeforeUnixZeroTime(year, month, day, hour, minute, second, int(frac), int(dec)), n, nil
 	}
 
@@ -1694,6 +4464,18 @@ const TIMEF_OFS int64 = 0x800000000000
 const TIMEF_INT_OFS int64 = 0x800000
 
 func decodeTime2(data []byte, dec uint16) (string, int, error) {
+	t, n, err := decodeTime2Value(data, dec)
+	if err != nil {
+		return "", n, err
+	}
+	return t.String(), n, nil
+}
+
+func decodeTime2Value(data []byte, dec uint16) (MySQLTime, int, error) {
+	if err := checkTemporal2Fsp("TIME2", dec); err != nil {
+		return MySQLTime{}, 0, err
+	}
+
 	// time  binary length
 	n := int(3 + (dec+1)/2)
 
@@ -1748,18 +4530,18 @@ func decodeTime2(data []byte, dec uint16) (string, int, error) {
 	}
 
 	if intPart == 0 && frac == 0 {
-		return "00:00:00", n, nil
+		return MySQLTime{Dec: int(dec)}, n, nil
 	}
 
 	return timeFormat(tmp, dec, n)
 }
 
-func timeFormat(tmp int64, dec uint16, n int) (string, int, error) {
+func timeFormat(tmp int64, dec uint16, n int) (MySQLTime, int, error) {
 	hms := int64(0)
-	sign := ""
+	negative := false
 	if tmp < 0 {
 		tmp = -tmp
-		sign = "-"
+		negative = true
 	}
 
 	hms = tmp >> 24
@@ -1769,45 +4551,80 @@ func timeFormat(tmp int64, dec uint16, n int) (string, int, error) {
 	second := hms % (1 << 6)        /* 6 bits starting at 0th   */
 	secPart := tmp % (1 << 24)
 
-	if secPart != 0 {
-		s := fmt.Sprintf("%s%02d:%02d:%02d.%06d", sign, hour, minute, second, secPart)
-		return s[0 : len(s)-(6-int(dec))], n, nil
+	return MySQLTime{
+		Negative:     negative,
+		Hours:        int(hour),
+		Minutes:      int(minute),
+		Seconds:      int(second),
+		Microseconds: int(secPart),
+		Dec:          int(dec),
+	}, n, nil
+}
+
+func decodeBlob(data []byte, meta uint16) (v []byte, n int, err error) {
+	size, n, err := blobBounds(data, meta)
+	if err != nil {
+		return nil, 0, err
 	}
+	return data[size:n], n, nil
+}
 
-	return fmt.Sprintf("%s%02d:%02d:%02d", sign, hour, minute, second), n, nil
+// blobBounds returns the size of the length of a blob value at the start of data,
+// where the value bytes start, and the size of the whole field.
+func blobBounds(data []byte, meta uint16) (size int, n int, err error) {
+	if meta < 1 || meta > 4 {
+		return 0, 0, fmt.Errorf("invalid blob packlen = %d", meta)
+	}
+	size = int(meta)
+	length, err := readLength(data, size)
+	if err != nil {
+		return 0, 0, errors.Annotate(err, "blob")
+	}
+	return size, size + length, nil
 }
 
-func decodeBlob(data []byte, meta uint16) (v []byte, n int, err error) {
-	var length int
-	switch meta {
-	case 1:
-		length = int(data[0])
-		v = data[1 : 1+length]
-		n = length + 1
-	case 2:
-		length = int(binary.LittleEndian.Uint16(data))
-		v = data[2 : 2+length]
-		n = length + 2
-	case 3:
-		length = int(FixedLengthInt(data[0:3]))
-		v = data[3 : 3+length]
-		n = length + 3
-	case 4:
-		length = int(binary.LittleEndian.Uint32(data))
-		v = data[4 : 4+length]
-		n = length + 4
-	default:
-		err = fmt.Errorf("invalid blob packlen = %d", meta)
+// decodeBlobWithFunc hands the value bytes of the i-th column, a BLOB, to
+// blobDecodeFunc as a reader over the event data, without decoding a value first.
+func (e *RowsEvent) decodeBlobWithFunc(data []byte, i int) (interface{}, int, error) {
+	size, n, err := blobBounds(data, e.Table.ColumnMeta[i])
+	if err != nil {
+		return nil, 0, err
+	}
+	v, err := e.blobDecodeFunc(i, bytes.NewReader(data[size:n]))
+	if err != nil {
+		return nil, 0, errors.Annotatef(err, "decode blob column %d", i)
 	}
+	return v, n, nil
+}
 
-	return
+// readLength reads the little-endian length of size bytes at the start of data
+// and checks that as many bytes follow it. The check is done before converting
+// the length to int, so a corrupted length can't overflow int on 32-bit platforms.
+func readLength(data []byte, size int) (int, error) {
+	if len(data) < size {
+		return 0, errors.Annotatef(io.ErrUnexpectedEOF, "length needs %d bytes but got %d", size, len(data))
+	}
+	length := FixedLengthInt(data[:size])
+	if length > uint64(len(data)-size) {
+		return 0, errors.Annotatef(io.ErrUnexpectedEOF, "value of %d bytes but %d bytes left", length, len(data)-size)
+	}
+	return int(length), nil
 }
 
 func (e *RowsEvent) Dump(w io.Writer) {
 	fmt.Fprintf(w, "TableID: %d\n", e.TableID)
-	fmt.Fprintf(w, "Flags: %d\n", e.Flags)
+	fmt.Fprintf(w, "Flags: %d (%s)\n", e.Flags, e.FlagSet())
 	fmt.Fprintf(w, "Column count: %d\n", e.ColumnCount)
 	fmt.Fprintf(w, "NDB data: %s\n", e.NdbData)
+	if e.NdbFlags != 0 {
+		fmt.Fprintf(w, "NDB flags: %d, transaction id: %d, conflict flags: %d\n", e.NdbFlags, e.NdbTransactionID, e.NdbConflictFlags)
+	}
+	if len(e.OriginatingQuery) > 0 {
+		fmt.Fprintf(w, "Originating query: %s\n", e.OriginatingQuery)
+	}
+	if e.TransactionID != 0 {
+		fmt.Fprintf(w, "Transaction ID: %d\n", e.TransactionID)
+	}
 
 	fmt.Fprintf(w, "Values:\n")
 	for _, rows := range e.Rows {
@@ -0,0 +1,140 @@
+package replication
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+func TestRowsEventBuilder(t *testing.T) {
+	table := &TableMapEvent{
+		TableID:     42,
+		ColumnCount: 14,
+		ColumnType: []byte{
+			mysql.MYSQL_TYPE_TINY, mysql.MYSQL_TYPE_SHORT, mysql.MYSQL_TYPE_INT24, mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_LONGLONG,
+			mysql.MYSQL_TYPE_DOUBLE, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_BLOB, mysql.MYSQL_TYPE_DATE,
+			mysql.MYSQL_TYPE_DATETIME2, mysql.MYSQL_TYPE_TIMESTAMP2, mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_BIT,
+		},
+		ColumnMeta: []uint16{
+			0, 0, 0, 0, 0,
+			8, 1020, uint16(mysql.MYSQL_TYPE_STRING)<<8 | 40, 2, 0,
+			3, 6, uint16(mysql.MYSQL_TYPE_ENUM)<<8 | 1, 2 << 8,
+		},
+	}
+	ts := time.Date(2024, 6, 1, 12, 30, 45, 123456000, time.UTC)
+	row := []interface{}{
+		int8(-1), int16(300), int32(-70000), int32(1 << 30), int64(-1 << 40),
+		float64(1.5), "varchar", "char", []byte("blob\x00"), "2024-06-01",
+		"2024-06-01 12:30:45.123", ts, int64(2), int64(0x1ff),
+	}
+
+	e, err := NewRowsEventBuilder(table, WRITE_ROWS_EVENTv2).
+		SetFlags(RowsEventStmtEndFlag).
+		AddRow(row...).
+		AddRow(make([]interface{}, 14)...).
+		Build()
+	require.NoError(t, err)
+	require.Equal(t, uint64(42), e.TableID)
+	require.True(t, e.IsStatementEnd())
+	require.Len(t, e.Rows, 2)
+	require.Equal(t, make([]interface{}, 14), e.Rows[1])
+
+	decoded := e.Rows[0]
+	require.Equal(t, row[:10], decoded[:10])
+	require.Equal(t, "2024-06-01 12:30:45.123", decoded[10])
+	require.Equal(t, ts.Local().Format("2006-01-02 15:04:05.000000"), decoded[11])
+	require.Equal(t, row[12:], decoded[12:])
+
+	// the event can be encoded again
+	data, err := e.Encode()
+	require.NoError(t, err)
+	e2 := &RowsEvent{Version: 2, tableIDSize: 6, tables: map[uint64]*TableMapEvent{42: table}, eventType: WRITE_ROWS_EVENTv2}
+	require.NoError(t, e2.Decode(data))
+	require.Equal(t, e.Rows, e2.Rows)
+}
+
+func TestRowsEventBuilderTimestampLocation(t *testing.T) {
+	table := &TableMapEvent{
+		TableID:     42,
+		ColumnCount: 2,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_TIMESTAMP2, mysql.MYSQL_TYPE_TIMESTAMP},
+		ColumnMeta:  []uint16{0, 0},
+	}
+	ts := time.Date(2024, 6, 1, 12, 30, 45, 0, time.UTC)
+	built, err := NewRowsEventBuilder(table, WRITE_ROWS_EVENTv2).AddRow(ts, ts).Build()
+	require.NoError(t, err)
+	data, err := built.Encode()
+	require.NoError(t, err)
+
+	// the strings decoded with TemporalStringLocation are encoded back to the same instant
+	newEvent := func() *RowsEvent {
+		return &RowsEvent{
+			Version:                 2,
+			tableIDSize:             6,
+			tables:                  map[uint64]*TableMapEvent{42: table},
+			eventType:               WRITE_ROWS_EVENTv2,
+			timestampStringLocation: time.UTC,
+			temporalStringLocation:  time.FixedZone("UTC+5", 5*3600),
+		}
+	}
+	e := newEvent()
+	require.NoError(t, e.Decode(data))
+	require.Equal(t, []interface{}{"2024-06-01 17:30:45", "2024-06-01 17:30:45"}, e.Rows[0])
+	encoded, err := e.Encode()
+	require.NoError(t, err)
+	require.Equal(t, data, encoded)
+
+	e2 := newEvent()
+	require.NoError(t, e2.Decode(encoded))
+	require.Equal(t, e.Rows, e2.Rows)
+}
+
+func TestRowsEventBuilderUpdate(t *testing.T) {
+	table := &TableMapEvent{
+		TableID:     1,
+		ColumnCount: 3,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_LONG},
+		ColumnMeta:  []uint16{0, 20, 0},
+	}
+
+	// minimal row image: the before image has the primary key, the after image the changed column
+	e, err := NewRowsEventBuilder(table, UPDATE_ROWS_EVENTv1).
+		SetColumnBitmaps(BuildColumnBitmap([]int{0}, 3), BuildColumnBitmap([]int{1}, 3)).
+		AddRow(int32(1), "ignored", int32(3)).
+		AddRow(nil, "new", nil).
+		Build()
+	require.NoError(t, err)
+	require.Equal(t, [][]interface{}{{int32(1), nil, nil}, {nil, "new", nil}}, e.Rows)
+	require.Equal(t, [][]int{{1, 2}, {0, 2}}, e.SkippedColumns)
+
+	_, err = NewRowsEventBuilder(table, UPDATE_ROWS_EVENTv2).AddRow(int32(1), "a", int32(3)).Build()
+	require.Error(t, err)
+	_, err = NewRowsEventBuilder(table, WRITE_ROWS_EVENTv2).AddRow(int32(1), "a").Build()
+	require.Error(t, err)
+	_, err = NewRowsEventBuilder(table, WRITE_ROWS_EVENTv2).AddRow("a", "a", int32(3)).Build()
+	require.Error(t, err)
+	_, err = NewRowsEventBuilder(table, PARTIAL_UPDATE_ROWS_EVENT).Build()
+	require.Error(t, err)
+	_, err = NewRowsEventBuilder(nil, WRITE_ROWS_EVENTv2).Build()
+	require.Error(t, err)
+}
+
+func TestBuildColumnBitmap(t *testing.T) {
+	for _, columnCount := range []int{0, 1, 7, 8, 9, 17, 64} {
+		var included []int
+		for i := 0; i < columnCount; i += 3 {
+			included = append(included, i)
+		}
+		bitmap := BuildColumnBitmap(included, columnCount)
+		require.Len(t, bitmap, bitmapByteSize(columnCount))
+		for i := 0; i < columnCount; i++ {
+			require.Equal(t, i%3 == 0, isBitSet(bitmap, i), "column %d of %d", i, columnCount)
+		}
+	}
+
+	require.Equal(t, fullBitmap(10), BuildColumnBitmap([]int{9, 8, 7, 6, 5, 4, 3, 2, 1, 0}, 10))
+	require.Equal(t, []byte{0x05, 0x00}, BuildColumnBitmap([]int{0, 2, 2, -1, 10}, 10))
+}
@@ -0,0 +1,227 @@
+package replication
+
+import (
+	"encoding/binary"
+	"runtime"
+	"sync"
+
+	. "github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// decodeRowsParallel decodes the row images of data starting at pos concurrently.
+// It first scans the boundaries of the row images with valueLength, then decodes
+// contiguous ranges of them in goroutines. It returns false without changing the
+// rows if the event should be decoded sequentially instead: it has fewer than
+// parallelDecodeMinRows row images, the boundaries can't be determined, e.g. for a
+// column type decoded by unknownTypeDecodeFunc, or decoding fails, so that the error
+// and the rows decoded before it are the same as for the sequential decoding.
+func (e *RowsEvent) decodeRowsParallel(pos int, data []byte) bool {
+	// every row image has at least one byte of NULL bitmap
+	if e.Table == nil || len(data)-pos < e.parallelDecodeMinRows {
+		return false
+	}
+	// partial JSON updates have a different image format, and the blob
+	// function may not be safe for concurrent use
+	if e.eventType == PARTIAL_UPDATE_ROWS_EVENT || e.blobDecodeFunc != nil {
+		return false
+	}
+
+	var offsets []int
+	for pos < len(data) {
+		n, ok := e.scanImage(data[pos:], e.imageBitmap(len(offsets)))
+		if !ok {
+			return false
+		}
+		offsets = append(offsets, pos)
+		pos += n
+	}
+	if len(offsets) < e.parallelDecodeMinRows ||
+		(e.needBitmap2 && len(offsets)%2 == 1) ||
+		(e.maxRows > 0 && len(offsets) > e.maxRows) {
+		return false
+	}
+
+	// fill the lazy caches of the table map event before they are read concurrently
+	e.Table.fillCaches()
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(offsets) {
+		workers = len(offsets)
+	}
+	rows := make([][]interface{}, len(offsets))
+	skippedColumns := make([][]int, len(offsets))
+	stats := make([]RowsEventDecodeStats, workers)
+	failed := make([]bool, workers)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					failed[w] = true
+				}
+			}()
+
+			start, end := w*len(offsets)/workers, (w+1)*len(offsets)/workers
+			d := *e
+			d.Rows = make([][]interface{}, 0, end-start)
+			d.SkippedColumns = make([][]int, 0, end-start)
+			d.stats = RowsEventDecodeStats{}
+			d.decimalScratch = nil
+			for j := start; j < end; j++ {
+				rowImageType := e.firstRowImageType()
+				if e.needBitmap2 && j%2 == 1 {
+					rowImageType = EnumRowImageTypeUpdateAI
+				}
+				imageEnd := len(data)
+				if j+1 < len(offsets) {
+					imageEnd = offsets[j+1]
+				}
+				n, err := d.decodeImage(data[offsets[j]:], e.imageBitmap(j), rowImageType)
+				if err != nil || n != imageEnd-offsets[j] {
+					failed[w] = true
+					return
+				}
+			}
+			copy(rows[start:end], d.Rows)
+			copy(skippedColumns[start:end], d.SkippedColumns)
+			stats[w] = d.stats
+		}(w)
+	}
+	wg.Wait()
+
+	for _, f := range failed {
+		if f {
+			return false
+		}
+	}
+
+	e.Rows = rows
+	e.SkippedColumns = skippedColumns
+	e.RowOffsets = offsets
+	for _, s := range stats {
+		e.stats.add(s)
+	}
+	return true
+}
+
+// imageBitmap returns the column bitmap of the k-th row image.
+func (e *RowsEvent) imageBitmap(k int) []byte {
+	if e.needBitmap2 && k%2 == 1 {
+		return e.ColumnBitmap2
+	}
+	return e.ColumnBitmap1
+}
+
+// scanImage returns the length of the row image at the start of data like
+// decodeImage, without decoding the values.
+func (e *RowsEvent) scanImage(data []byte, bitmap []byte) (int, bool) {
+	if len(e.Table.ColumnType) < int(e.ColumnCount) || len(e.Table.ColumnMeta) < int(e.ColumnCount) {
+		return 0, false
+	}
+
+	pos := NullBitmapSize(bitmap, int(e.ColumnCount))
+	if pos > len(data) {
+		return 0, false
+	}
+	nullBitmap := data[:pos]
+	nullBitmapIndex := 0
+
+	for i := 0; i < int(e.ColumnCount); i++ {
+		if !isBitSet(bitmap, i) {
+			continue
+		}
+		if isBitSetIncr(nullBitmap, &nullBitmapIndex) {
+			continue
+		}
+		n, ok := valueLength(data[pos:], e.Table.ColumnType[i], e.Table.ColumnMeta[i])
+		if !ok || n > len(data)-pos {
+			return 0, false
+		}
+		pos += n
+	}
+	return pos, true
+}
+
+// valueLength returns the length of a non-NULL value of type tp at the start of
+// data, the n returned by decodeValue, without decoding it. ok is false for an
+// unknown type or if the length can't be read from data.
+func valueLength(data []byte, tp byte, meta uint16) (n int, ok bool) {
+	length := 0
+	if tp == MYSQL_TYPE_STRING {
+		tp, length = realStringType(meta)
+	}
+
+	switch tp {
+	case MYSQL_TYPE_NULL:
+		return 0, true
+	case MYSQL_TYPE_TINY, MYSQL_TYPE_YEAR:
+		return 1, true
+	case MYSQL_TYPE_SHORT:
+		return 2, true
+	case MYSQL_TYPE_INT24, MYSQL_TYPE_TIME, MYSQL_TYPE_DATE, MYSQL_TYPE_NEWDATE:
+		return 3, true
+	case MYSQL_TYPE_LONG, MYSQL_TYPE_FLOAT, MYSQL_TYPE_TIMESTAMP:
+		return 4, true
+	case MYSQL_TYPE_LONGLONG, MYSQL_TYPE_DOUBLE, MYSQL_TYPE_DATETIME:
+		return 8, true
+	case MYSQL_TYPE_NEWDECIMAL:
+		precision, decimals := int(meta>>8), int(meta&0xFF)
+		if checkDecimalMeta(precision, decimals) != nil {
+			return 0, false
+		}
+		return decimalBinSize(precision, decimals), true
+	case MYSQL_TYPE_BIT:
+		nbits := int(meta>>8)*8 + int(meta&0xFF)
+		return (nbits + 7) / 8, true
+	case MYSQL_TYPE_TIMESTAMP2, MYSQL_TYPE_DATETIME2, MYSQL_TYPE_TIME2:
+		if meta > 6 {
+			return 0, false
+		}
+		frac := int(meta+1) / 2
+		switch tp {
+		case MYSQL_TYPE_TIMESTAMP2:
+			return 4 + frac, true
+		case MYSQL_TYPE_DATETIME2:
+			return 5 + frac, true
+		default:
+			return 3 + frac, true
+		}
+	case MYSQL_TYPE_ENUM:
+		if l := int(meta & 0xFF); l == 1 || l == 2 {
+			return l, true
+		}
+		return 0, false
+	case MYSQL_TYPE_SET:
+		return int(meta & 0xFF), true
+	case MYSQL_TYPE_BLOB, MYSQL_TYPE_GEOMETRY, MYSQL_TYPE_JSON:
+		size := int(meta)
+		if size < 1 || size > 4 || len(data) < size {
+			return 0, false
+		}
+		length, err := readLength(data, size)
+		if err != nil {
+			return 0, false
+		}
+		return size + length, true
+	case MYSQL_TYPE_VARCHAR, MYSQL_TYPE_VAR_STRING:
+		length = int(meta)
+	case MYSQL_TYPE_STRING:
+	default:
+		return 0, false
+	}
+
+	// strings
+	if length < 256 {
+		if len(data) < 1 {
+			return 0, false
+		}
+		return 1 + int(data[0]), true
+	}
+	if len(data) < 2 {
+		return 0, false
+	}
+	return 2 + int(binary.LittleEndian.Uint16(data)), true
+}
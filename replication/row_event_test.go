@@ -1,7 +1,24 @@
 package replication
 
 import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/require"
@@ -323,6 +340,33 @@ func TestLastNull(t *testing.T) {
 	}
 }
 
+func TestDecodeHeaderOnly(t *testing.T) {
+	tableMapEventData := []byte("\xd3\x01\x00\x00\x00\x00\x01\x00\x04test\x00\nfunnytable\x00\x01\x01\x00\x01")
+
+	tableMapEvent := new(TableMapEvent)
+	tableMapEvent.tableIDSize = 6
+	err := tableMapEvent.Decode(tableMapEventData)
+	require.NoError(t, err)
+
+	rows := new(RowsEvent)
+	rows.tableIDSize = 6
+	rows.tables = make(map[uint64]*TableMapEvent)
+	rows.tables[tableMapEvent.TableID] = tableMapEvent
+	rows.Version = 2
+
+	data := []byte("\xd3\x01\x00\x00\x00\x00\x01\x00\x02\x00\x01\xff\xfe\x01\xff\xfe\x02")
+	err = rows.DecodeHeaderOnly(data)
+	require.NoError(t, err)
+	require.Equal(t, tableMapEvent, rows.Table)
+	require.Equal(t, uint64(1), rows.ColumnCount)
+	require.Nil(t, rows.Rows)
+	require.Nil(t, rows.SkippedColumns)
+
+	// the column bitmap is cut off
+	err = rows.DecodeHeaderOnly(data[:11])
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
 func TestParseRowPanic(t *testing.T) {
 	tableMapEvent := new(TableMapEvent)
 	tableMapEvent.tableIDSize = 6
@@ -556,6 +600,83 @@ func TestSet(t *testing.T) {
 	require.Equal(t, int64(5), rows.Rows[0][1])
 }
 
+func TestJsonKeyOrder(t *testing.T) {
+	// {"bb": 1, "a": 2, "c": 3}, MySQL stores the keys as "a", "c", "bb"
+	data := []byte{
+		JSONB_SMALL_OBJECT,
+		0x03, 0x00, 0x1d, 0x00, // count, size
+		0x19, 0x00, 0x01, 0x00, // key "a"
+		0x1a, 0x00, 0x01, 0x00, // key "c"
+		0x1b, 0x00, 0x02, 0x00, // key "bb"
+		JSONB_INT16, 0x02, 0x00,
+		JSONB_INT16, 0x03, 0x00,
+		JSONB_INT16, 0x01, 0x00,
+		'a', 'c', 'b', 'b',
+	}
+
+	e := &RowsEvent{}
+	d, err := e.decodeJsonBinary(data)
+	require.NoError(t, err)
+	require.Equal(t, `{"a":2,"bb":1,"c":3}`, string(d))
+
+	e.jsonStoredKeyOrder = true
+	d, err = e.decodeJsonBinary(data)
+	require.NoError(t, err)
+	require.Equal(t, `{"a":2,"c":3,"bb":1}`, string(d))
+
+	// nested objects keep the stored order too
+	nested := []byte{
+		JSONB_SMALL_ARRAY,
+		0x01, 0x00, 0x24, 0x00, // count, size
+		JSONB_SMALL_OBJECT, 0x07, 0x00,
+	}
+	nested = append(nested, data[1:]...)
+	d, err = e.decodeJsonBinary(nested)
+	require.NoError(t, err)
+	require.Equal(t, `[{"a":2,"c":3,"bb":1}]`, string(d))
+}
+
+func TestJsonAsNative(t *testing.T) {
+	// {"a": -5, "big": 9007199254740993}, 2^53 + 1 can't be held by a float64
+	data := []byte{
+		JSONB_SMALL_OBJECT,
+		0x02, 0x00, 0x1e, 0x00, // count, size
+		0x12, 0x00, 0x01, 0x00, // key "a"
+		0x13, 0x00, 0x03, 0x00, // key "big"
+		JSONB_INT16, 0xfb, 0xff,
+		JSONB_UINT64, 0x16, 0x00,
+		'a', 'b', 'i', 'g',
+		0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x20, 0x00,
+	}
+
+	e := &RowsEvent{}
+	d, err := e.decodeJsonBinary(data)
+	require.NoError(t, err)
+	require.Equal(t, `{"a":-5,"big":9007199254740993}`, string(d))
+
+	v, err := e.decodeJsonBinaryValue(data, true)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"a": int64(-5), "big": uint64(9007199254740993)}, v)
+
+	// [1.5, 70000, true, "x"] in a JSON column
+	arr := []byte{
+		JSONB_LARGE_ARRAY,
+		0x04, 0x00, 0x00, 0x00, 0x26, 0x00, 0x00, 0x00, // count, size
+		JSONB_DOUBLE, 0x1c, 0x00, 0x00, 0x00,
+		JSONB_UINT32, 0x70, 0x11, 0x01, 0x00,
+		JSONB_LITERAL, JSONB_TRUE_LITERAL, 0x00, 0x00, 0x00,
+		JSONB_STRING, 0x24, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf8, 0x3f,
+		0x01, 'x',
+	}
+	col := append([]byte{byte(len(arr))}, arr...)
+	e.jsonAsNative = true
+	v, n, err := e.decodeValue(col, mysql.MYSQL_TYPE_JSON, 1, false)
+	require.NoError(t, err)
+	require.Equal(t, len(col), n)
+	require.Equal(t, []interface{}{1.5, uint64(70000), true, "x"}, v)
+}
+
 func TestJsonNull(t *testing.T) {
 	// Table:
 	// desc hj_order_preview
@@ -655,6 +776,7 @@ func TestJsonCompatibility(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "null", rows.Rows[1][2])
 	require.Equal(t, "{\"a\":1234}", rows.Rows[2][2])
+	require.Equal(t, RowsEventDecodeStats{Rows: 3, Nulls: 6, Warnings: 1}, rows.DecodeStats())
 
 	rows.ignoreJSONDecodeErr = false
 	data = []byte("l\x00\x00\x00\x00\x00\x01\x00\x02\x00\x04\xff\xff\xf8\x01\x00\x00\x00\n{\"a\":1234}\r\x00\x00\x00\x00\x00\x00\x04\x00\x00\x00\x01\x00\x05\xd2\x04a\xf8\x01\x00\x00\x00\x02{}\x05\x00\x00\x00\x00\x00\x00\x04\x00")
@@ -666,6 +788,222 @@ func TestJsonCompatibility(t *testing.T) {
 	require.Equal(t, "{}", rows.Rows[2][2])
 }
 
+func TestDecodeBitAsBytes(t *testing.T) {
+	// BIT(64) with all bits set
+	data := []byte("\xff\xff\xff\xff\xff\xff\xff\xff")
+	meta := uint16(8 << 8)
+
+	e := &RowsEvent{}
+	v, n, err := e.decodeValue(data, mysql.MYSQL_TYPE_BIT, meta, false)
+	require.NoError(t, err)
+	require.Equal(t, 8, n)
+	require.Equal(t, int64(-1), v)
+
+	e.bitAsBytes = true
+	v, n, err = e.decodeValue(data, mysql.MYSQL_TYPE_BIT, meta, false)
+	require.NoError(t, err)
+	require.Equal(t, 8, n)
+	require.Equal(t, data, v)
+
+	// BIT(10) is stored in 2 bytes
+	v, n, err = e.decodeValue([]byte("\x02\x01\xaa"), mysql.MYSQL_TYPE_BIT, uint16(1<<8|2), false)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Equal(t, []byte{0x02, 0x01}, v)
+
+	_, _, err = e.decodeValue([]byte("\xff"), mysql.MYSQL_TYPE_BIT, meta, false)
+	require.Error(t, err)
+}
+
+func TestNullBitmapSize(t *testing.T) {
+	testcases := []struct {
+		columnBitmap []byte
+		columnCount  int
+		expected     int
+	}{
+		{nil, 0, 0},
+		{[]byte{0x00}, 3, 0},
+		{[]byte{0x07}, 3, 1},
+		{[]byte{0xff}, 8, 1},
+		// the bits beyond the column count are ignored
+		{[]byte{0xff}, 3, 1},
+		{[]byte{0xff, 0xff}, 9, 2},
+		{[]byte{0xff, 0xfe}, 9, 1},
+		{[]byte{0x55, 0x55, 0x01}, 17, 2},
+		// the NULL bitmap only covers the present columns
+		{[]byte{0x01, 0x00, 0x80}, 24, 1},
+		// a short column bitmap
+		{[]byte{0xff}, 16, 1},
+	}
+	for _, tc := range testcases {
+		require.Equal(t, tc.expected, NullBitmapSize(tc.columnBitmap, tc.columnCount), "%x %d", tc.columnBitmap, tc.columnCount)
+	}
+
+	// the same as counting the bits one by one
+	columnBitmap := []byte{0x00, 0x01, 0x80, 0xff, 0x5a, 0xa5, 0x3c}
+	for columnCount := 0; columnCount <= len(columnBitmap)*8; columnCount++ {
+		count := 0
+		for i := 0; i < columnCount; i++ {
+			if isBitSet(columnBitmap, i) {
+				count++
+			}
+		}
+		require.Equal(t, bitmapByteSize(count), NullBitmapSize(columnBitmap, columnCount), "%d columns", columnCount)
+	}
+}
+
+func TestDecodeEnumSetInString(t *testing.T) {
+	testcases := []struct {
+		meta     uint16
+		data     []byte
+		n        int
+		expected interface{}
+	}{
+		// ENUM with 1 byte index
+		{uint16(mysql.MYSQL_TYPE_ENUM)<<8 | 1, []byte("\x02"), 1, int64(2)},
+		// ENUM with more than 255 members has 2 bytes index
+		{uint16(mysql.MYSQL_TYPE_ENUM)<<8 | 2, []byte("\x2c\x01"), 2, int64(300)},
+		// SET with 1 byte mask
+		{uint16(mysql.MYSQL_TYPE_SET)<<8 | 1, []byte("\x05"), 1, int64(5)},
+		// CHAR(10) for comparison
+		{uint16(mysql.MYSQL_TYPE_STRING)<<8 | 10, []byte("\x02ab"), 3, "ab"},
+	}
+	for _, tc := range testcases {
+		// the next value must not be consumed
+		data := append(append([]byte{}, tc.data...), 0xaa)
+		e := &RowsEvent{}
+		v, n, err := e.decodeValue(data, mysql.MYSQL_TYPE_STRING, tc.meta, false)
+		require.NoError(t, err)
+		require.Equal(t, tc.n, n)
+		require.Equal(t, tc.expected, v)
+	}
+
+	// CREATE TABLE t (e ENUM('a', 'b'), s SET('x', 'y', 'z'))
+	table := &TableMapEvent{
+		ColumnCount: 2,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_STRING},
+		ColumnMeta:  []uint16{uint16(mysql.MYSQL_TYPE_ENUM)<<8 | 1, uint16(mysql.MYSQL_TYPE_SET)<<8 | 1},
+		enumLabels:  map[int][]string{0: {"a", "b"}},
+		setLabels:   map[int][]string{1: {"x", "y", "z"}},
+	}
+	e := &RowsEvent{Table: table, ColumnCount: 2, enumSetWithLabels: true}
+	_, err := e.decodeImage([]byte("\x00\x02\x05"), []byte{0x03}, EnumRowImageTypeWriteAI)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{
+		EnumValue{Index: 2, Label: "b"},
+		SetValue{Mask: 5, Labels: []string{"x", "z"}},
+	}, e.Rows[0])
+}
+
+func TestDecodeBitByteBoundary(t *testing.T) {
+	testcases := []struct {
+		bits     int
+		data     []byte
+		n        int
+		expected int64
+	}{
+		{9, []byte("\x01\x01"), 2, 0x101},
+		{12, []byte("\x0f\xff"), 2, 0xfff},
+		{12, []byte("\x08\x01"), 2, 0x801},
+		{16, []byte("\xff\xfe"), 2, 0xfffe},
+		{17, []byte("\x01\x00\x02"), 3, 0x10002},
+	}
+	for _, tc := range testcases {
+		// the table map event logs the bits in the last byte then the whole bytes
+		meta := binary.LittleEndian.Uint16([]byte{byte(tc.bits % 8), byte(tc.bits / 8)})
+		table := &TableMapEvent{ColumnType: []byte{mysql.MYSQL_TYPE_BIT}, ColumnMeta: []uint16{meta}}
+		length, ok := table.ColumnLength(0)
+		require.True(t, ok)
+		require.Equal(t, tc.bits, length)
+
+		// the next value must not be consumed
+		data := append(append([]byte{}, tc.data...), 0xaa)
+		e := &RowsEvent{}
+		v, n, err := e.decodeValue(data, mysql.MYSQL_TYPE_BIT, meta, false)
+		require.NoError(t, err, "BIT(%d)", tc.bits)
+		require.Equal(t, tc.n, n, "BIT(%d)", tc.bits)
+		require.Equal(t, tc.expected, v, "BIT(%d)", tc.bits)
+
+		e.bitAsBytes = true
+		v, n, err = e.decodeValue(data, mysql.MYSQL_TYPE_BIT, meta, false)
+		require.NoError(t, err, "BIT(%d)", tc.bits)
+		require.Equal(t, tc.n, n, "BIT(%d)", tc.bits)
+		require.Equal(t, tc.data, v, "BIT(%d)", tc.bits)
+	}
+}
+
+func TestDecodeLengthOverflow(t *testing.T) {
+	// lengths beyond the data, 0xffffffff would wrap to -1 in a 32-bit int
+	testcases := []struct {
+		tp   byte
+		meta uint16
+		data []byte
+	}{
+		{mysql.MYSQL_TYPE_BLOB, 4, []byte("\xff\xff\xff\xffabc")},
+		{mysql.MYSQL_TYPE_BLOB, 4, []byte("\x00\x00\x00\x80abc")},
+		{mysql.MYSQL_TYPE_BLOB, 3, []byte("\x04\x00\x00abc")},
+		{mysql.MYSQL_TYPE_BLOB, 2, []byte("\x00")},
+		{mysql.MYSQL_TYPE_BLOB, 1, []byte{}},
+		{mysql.MYSQL_TYPE_GEOMETRY, 4, []byte("\xff\xff\xff\xff")},
+		{mysql.MYSQL_TYPE_JSON, 4, []byte("\xff\xff\xff\xff\x04\x01")},
+		{mysql.MYSQL_TYPE_JSON, 4, []byte("\x01\x00")},
+	}
+	for _, tc := range testcases {
+		e := &RowsEvent{}
+		_, _, err := e.decodeValue(tc.data, tc.tp, tc.meta, false)
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF, "type %d data %q", tc.tp, tc.data)
+
+		_, ok := valueLength(tc.data, tc.tp, tc.meta)
+		require.False(t, ok, "type %d data %q", tc.tp, tc.data)
+	}
+
+	v, n, err := decodeBlob([]byte("\x03\x00\x00abcd"), 3)
+	require.NoError(t, err)
+	require.Equal(t, 6, n)
+	require.Equal(t, []byte("abc"), v)
+
+	_, _, err = decodeBlob([]byte("abc"), 5)
+	require.ErrorContains(t, err, "invalid blob packlen = 5")
+}
+
+func TestDecodeUnknownType(t *testing.T) {
+	const unknownType = byte(0xf0)
+	data := []byte("\x03abcrest")
+
+	e := &RowsEvent{}
+	_, _, err := e.decodeValue(data, unknownType, 0, false)
+	require.Error(t, err)
+
+	e.unknownTypeDecodeFunc = func(tp byte, meta uint16, data []byte) (interface{}, int, error) {
+		require.Equal(t, unknownType, tp)
+		require.Equal(t, uint16(1), meta)
+		l := int(data[0])
+		return string(data[1 : 1+l]), 1 + l, nil
+	}
+	v, n, err := e.decodeValue(data, unknownType, 1, false)
+	require.NoError(t, err)
+	require.Equal(t, 4, n)
+	require.Equal(t, "abc", v)
+
+	// the number of bytes consumed must be within data
+	for _, consumed := range []int{-1, len(data) + 1} {
+		e.unknownTypeDecodeFunc = func(tp byte, meta uint16, data []byte) (interface{}, int, error) {
+			return "abc", consumed, nil
+		}
+		_, _, err = e.decodeValue(data, unknownType, 1, false)
+		require.ErrorContains(t, err, fmt.Sprintf("consumed %d bytes of %d", consumed, len(data)))
+	}
+
+	// a negative count doesn't make DecodeData loop over the same image
+	table := &TableMapEvent{ColumnCount: 1, ColumnType: []byte{unknownType}, ColumnMeta: []uint16{0}}
+	rows := &RowsEvent{Table: table, ColumnCount: 1, ColumnBitmap1: []byte{0x01}, unknownTypeDecodeFunc: func(tp byte, meta uint16, data []byte) (interface{}, int, error) {
+		return nil, -1, nil
+	}}
+	err = rows.DecodeData(0, []byte{0x00, 0x01})
+	require.ErrorContains(t, err, "consumed -1 bytes of 1")
+	require.Empty(t, rows.Rows)
+}
+
 func TestDecodeDatetime2(t *testing.T) {
 	testcases := []struct {
 		data        []byte
@@ -684,7 +1022,7 @@ func TestDecodeDatetime2(t *testing.T) {
 		{[]byte("\x80\x03\x82\x00\x00\x01\xe2\x40"), uint16(6), false, "0001-01-01 00:00:00.123456"},
 	}
 	for _, tc := range testcases {
-		value, _, err := decodeDatetime2(tc.data, tc.dec)
+		value, _, err := decodeDatetime2(tc.data, tc.dec, false)
 		require.NoError(t, err)
 		switch v := value.(type) {
 		case fracTime:
@@ -697,6 +1035,66 @@ func TestDecodeDatetime2(t *testing.T) {
 			require.FailNow(t, "invalid value type: %T", value)
 		}
 	}
+
+	// a negative value can't be written by MySQL
+	for _, data := range [][]byte{
+		[]byte("\x7f\xff\xff\xff\xff"),
+		[]byte("\x7f\xff\xff\xff\xff\x0c"),
+		[]byte("\x00\x00\x00\x00\x00"),
+	} {
+		_, _, err := decodeDatetime2(data, uint16(len(data)-5)*2, false)
+		require.Error(t, err)
+		_, _, err = decodeDatetime2(data, uint16(len(data)-5)*2, true)
+		require.Error(t, err)
+	}
+}
+
+func TestTemporalStringLocation(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*3600)
+	e := &RowsEvent{
+		timestampStringLocation: time.UTC,
+		temporalStringLocation:  loc,
+	}
+
+	// 2016-10-28 15:30:42 UTC
+	v, _, err := e.decodeValue([]byte("\x99\x9a\xb8\xf7\xaa"), mysql.MYSQL_TYPE_DATETIME2, 0, false)
+	require.NoError(t, err)
+	require.Equal(t, "2016-10-28 23:30:42", v)
+
+	v, _, err = e.decodeValue([]byte("\xd2\xde\xeb\x1a\x56\x12\x00\x00"), mysql.MYSQL_TYPE_DATETIME, 0, false)
+	require.NoError(t, err)
+	require.Equal(t, "2016-10-28 23:30:42", v)
+
+	// 1477668642 is 2016-10-28 15:30:42 UTC, the location overrides timestampStringLocation
+	v, _, err = e.decodeValue([]byte("\x22\x6f\x13\x58"), mysql.MYSQL_TYPE_TIMESTAMP, 0, false)
+	require.NoError(t, err)
+	require.Equal(t, "2016-10-28 23:30:42", v)
+
+	v, _, err = e.decodeValue([]byte("\x58\x13\x6f\x22"), mysql.MYSQL_TYPE_TIMESTAMP2, 0, false)
+	require.NoError(t, err)
+	require.Equal(t, "2016-10-28 23:30:42", v)
+
+	// zero dates are not converted
+	v, _, err = e.decodeValue([]byte("\x80\x00\x00\x00\x00"), mysql.MYSQL_TYPE_DATETIME2, 0, false)
+	require.NoError(t, err)
+	require.Equal(t, "0000-00-00 00:00:00", v)
+
+	// without the option, DATETIME keeps its stored value
+	e.temporalStringLocation = nil
+	v, _, err = e.decodeValue([]byte("\x99\x9a\xb8\xf7\xaa"), mysql.MYSQL_TYPE_DATETIME2, 0, false)
+	require.NoError(t, err)
+	require.Equal(t, "2016-10-28 15:30:42", v)
+
+	v, _, err = e.decodeValue([]byte("\x22\x6f\x13\x58"), mysql.MYSQL_TYPE_TIMESTAMP, 0, false)
+	require.NoError(t, err)
+	require.Equal(t, "2016-10-28 15:30:42", v)
+
+	// the option is ignored when parseTime is set
+	e.temporalStringLocation = loc
+	e.parseTime = true
+	v, _, err = e.decodeValue([]byte("\x99\x9a\xb8\xf7\xaa"), mysql.MYSQL_TYPE_DATETIME2, 0, false)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2016, 10, 28, 15, 30, 42, 0, time.UTC), v)
 }
 
 func TestTableMapNullable(t *testing.T) {
@@ -1066,6 +1464,8 @@ func TestRowsDataExtraData(t *testing.T) {
 		expectSourcePartitionId uint16
 		expectNdbFormat         byte
 		expectNdbData           []byte
+		expectNdbFlags          uint16
+		expectNdbTransactionID  uint64
 	}{
 		/*
 			mysql-cluster 8.0.32
@@ -1093,6 +1493,8 @@ func TestRowsDataExtraData(t *testing.T) {
 			expectSourcePartitionId: 0x0,
 			expectNdbFormat:         0x0,
 			expectNdbData:           []byte("\x01\x00\x00\x04\x80\x00\x04\x00\x00\x00"),
+			expectNdbFlags:          NDB_ERIF_TRANSID,
+			expectNdbTransactionID:  0x400800400,
 		},
 		/*
 				mysql 8.0.16
@@ -1173,6 +1575,11 @@ func TestRowsDataExtraData(t *testing.T) {
 		require.Equal(t, tc.expectSourcePartitionId, rowsEvent.SourcePartitionId)
 		require.Equal(t, tc.expectNdbFormat, rowsEvent.NdbFormat)
 		require.Equal(t, tc.expectNdbData, rowsEvent.NdbData)
+		require.Equal(t, tc.expectNdbFlags, rowsEvent.NdbFlags)
+		require.Equal(t, tc.expectNdbTransactionID, rowsEvent.NdbTransactionID)
+		version, ok := rowsEvent.NdbInfoVersion()
+		require.Equal(t, tc.expectNdbData != nil, ok)
+		require.Equal(t, tc.expectNdbFormat, version)
 	}
 }
 
@@ -1350,9 +1757,100 @@ func TestTableMapHelperMaps(t *testing.T) {
 		require.Equal(t, tc.enumStrValueMap, tableMapEvent.EnumStrValueMap())
 		require.Equal(t, tc.setStrValueMap, tableMapEvent.SetStrValueMap())
 		require.Equal(t, tc.geometryTypeMap, tableMapEvent.GeometryTypeMap())
+		// SRID is not logged by the server
+		require.Nil(t, tableMapEvent.GeometrySRIDMap())
+
+		// the slices have the values of the maps in column order
+		unsigned := tableMapEvent.UnsignedSlice()
+		collations := tableMapEvent.CollationSlice()
+		enumSetCollations := tableMapEvent.EnumSetCollationSlice()
+		require.Equal(t, tc.unsignedMap == nil, unsigned == nil)
+		require.Equal(t, tc.collationMap == nil, collations == nil)
+		require.Equal(t, tc.enumSetCollationMap == nil, enumSetCollations == nil)
+		for i := 0; i < int(tableMapEvent.ColumnCount); i++ {
+			if unsigned != nil {
+				require.Equal(t, tc.unsignedMap[i], unsigned[i])
+			}
+			if collations != nil {
+				require.Equal(t, tc.collationMap[i], collations[i])
+			}
+			if enumSetCollations != nil {
+				require.Equal(t, tc.enumSetCollationMap[i], enumSetCollations[i])
+			}
+		}
+
+		for i, values := range tc.enumStrValueMap {
+			require.Equal(t, values, tableMapEvent.EnumStrValueString()[tableMapEvent.TypeOrdinal(i)])
+		}
+		for i, values := range tc.setStrValueMap {
+			require.Equal(t, values, tableMapEvent.SetStrValueString()[tableMapEvent.TypeOrdinal(i)])
+		}
+		if tc.flavor == "mysql" {
+			for i, geometryType := range tc.geometryTypeMap {
+				require.Equal(t, geometryType, tableMapEvent.GeometryType[tableMapEvent.TypeOrdinal(i)])
+			}
+			require.Equal(t, 0, tableMapEvent.TypeOrdinal(40)) // g_geometry
+			require.Equal(t, 1, tableMapEvent.TypeOrdinal(44)) // g_geometrycollection
+		} else {
+			// MariaDB counts geometry and JSON columns as character columns, the
+			// 12 c_* columns, g_geometry and j_json are before g_geometrycollection
+			require.Equal(t, 12, tableMapEvent.TypeOrdinal(40)) // g_geometry
+			require.Equal(t, 14, tableMapEvent.TypeOrdinal(44)) // g_geometrycollection
+		}
+		require.Equal(t, 0, tableMapEvent.TypeOrdinal(1))   // n_boolean
+		require.Equal(t, 10, tableMapEvent.TypeOrdinal(11)) // nu_smallint
+		require.Equal(t, 0, tableMapEvent.TypeOrdinal(26))  // c_char
+		require.Equal(t, 1, tableMapEvent.TypeOrdinal(43))  // e_enum2
+		require.Equal(t, -1, tableMapEvent.TypeOrdinal(-1))
+		require.Equal(t, -1, tableMapEvent.TypeOrdinal(int(tableMapEvent.ColumnCount)))
+		if tc.flavor == "mysql" {
+			require.Equal(t, -1, tableMapEvent.TypeOrdinal(18)) // t_year
+		}
+
+		_, ok := tableMapEvent.EnumSetCharsetName(0)
+		require.False(t, ok)
+		for i, expected := range map[int]string{38: "utf8mb4", 42: "gbk"} {
+			name, ok := tableMapEvent.EnumSetCharsetName(i)
+			if tc.enumSetCollationMap == nil {
+				require.False(t, ok)
+				continue
+			}
+			require.True(t, ok)
+			require.Equal(t, expected, name)
+		}
+
+		for i, expected := range map[int]string{40: "GEOMETRY", 44: "GEOMETRYCOLLECTION", 47: "MULTIPOINT", 50: "POINT"} {
+			name, ok := tableMapEvent.GeometryTypeName(i)
+			require.Equal(t, tc.geometryTypeMap != nil, ok)
+			if ok {
+				require.Equal(t, expected, name)
+			}
+		}
+		_, ok = tableMapEvent.GeometryTypeName(0)
+		require.False(t, ok)
 	}
 }
 
+func TestTableMapGeometrySRID(t *testing.T) {
+	tableMapEvent := &TableMapEvent{
+		ColumnCount: 3,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_GEOMETRY, mysql.MYSQL_TYPE_GEOMETRY},
+		ColumnMeta:  []uint16{0, 4, 4},
+	}
+	require.Nil(t, tableMapEvent.GeometrySRIDMap())
+
+	tableMapEvent.GeometrySRID = []uint64{4326, 0}
+	require.Equal(t, map[int]uint64{1: 4326, 2: 0}, tableMapEvent.GeometrySRIDMap())
+
+	// an unknown geometry type
+	tableMapEvent.GeometryType = []uint64{1, 100}
+	name, ok := tableMapEvent.GeometryTypeName(1)
+	require.True(t, ok)
+	require.Equal(t, "POINT", name)
+	_, ok = tableMapEvent.GeometryTypeName(2)
+	require.False(t, ok)
+}
+
 func TestInvalidEvent(t *testing.T) {
 	data := "@\x01\x00\x00\x00\x00\x01\x00\x02\xff\xfc\x01\x00\x00\x00\x00B\x14U\x16\x8ew"
 	table := &TableMapEvent{
@@ -1406,6 +1904,44 @@ func TestDecodeTime2(t *testing.T) {
 	}
 }
 
+func TestUseMySQLTime(t *testing.T) {
+	testcases := []struct {
+		data     []byte
+		tp       byte
+		dec      uint16
+		expected MySQLTime
+		str      string
+	}{
+		{[]byte("\xb4\x6e\xfb"), mysql.MYSQL_TYPE_TIME2, 0, MySQLTime{Hours: 838, Minutes: 59, Seconds: 59}, "838:59:59"},
+		{[]byte("\x80\x00\x00"), mysql.MYSQL_TYPE_TIME2, 0, MySQLTime{}, "00:00:00"},
+		{[]byte("\x4b\x91\x05"), mysql.MYSQL_TYPE_TIME2, 0, MySQLTime{Negative: true, Hours: 838, Minutes: 59, Seconds: 59}, "-838:59:59"},
+		{[]byte("\x7f\xff\xff\xff"), mysql.MYSQL_TYPE_TIME2, 2, MySQLTime{Negative: true, Microseconds: 10000, Dec: 2}, "-00:00:00.01"},
+		{[]byte("\x4b\x91\x05\xf4"), mysql.MYSQL_TYPE_TIME2, 2, MySQLTime{Negative: true, Hours: 838, Minutes: 59, Seconds: 58, Microseconds: 120000, Dec: 2}, "-838:59:58.12"},
+		{[]byte("\x80\x00\x00\x00\x00\x00"), mysql.MYSQL_TYPE_TIME2, 6, MySQLTime{Dec: 6}, "00:00:00"},
+		{[]byte("\x7f\x0e\xfa\xfe\x1d\xc0"), mysql.MYSQL_TYPE_TIME2, 6, MySQLTime{Negative: true, Hours: 15, Minutes: 4, Seconds: 5, Microseconds: 123456, Dec: 6}, "-15:04:05.123456"},
+		{[]byte("\x41\x42\x0f"), mysql.MYSQL_TYPE_TIME, 0, MySQLTime{Hours: 100, Seconds: 1}, "100:00:01"},
+		{[]byte("\x59\x0a\x80"), mysql.MYSQL_TYPE_TIME, 0, MySQLTime{Negative: true, Hours: 838, Minutes: 59, Seconds: 59}, "-838:59:59"},
+	}
+	for _, tc := range testcases {
+		e := &RowsEvent{useMySQLTime: true}
+		v, n, err := e.decodeValue(tc.data, tc.tp, tc.dec, false)
+		require.NoError(t, err)
+		require.Len(t, tc.data, n)
+		require.Equal(t, tc.expected, v)
+		require.Equal(t, tc.str, v.(MySQLTime).String())
+
+		if tc.tp == mysql.MYSQL_TYPE_TIME2 {
+			e = &RowsEvent{}
+			v, _, err = e.decodeValue(tc.data, tc.tp, tc.dec, false)
+			require.NoError(t, err)
+			require.Equal(t, tc.str, v)
+		}
+	}
+
+	require.Equal(t, -(838*time.Hour + 59*time.Minute + 58*time.Second + 120*time.Millisecond),
+		MySQLTime{Negative: true, Hours: 838, Minutes: 59, Seconds: 58, Microseconds: 120000, Dec: 2}.Duration())
+}
+
 type decimalTest struct {
 	num      string
 	dumpData []byte
@@ -1498,6 +2034,28 @@ func BenchmarkNotUseDecimal(b *testing.B) {
 	}
 }
 
+func BenchmarkDecodeDecimalRow(b *testing.B) {
+	// a table of DECIMAL(40,16), DECIMAL(60,0) and DECIMAL(30,30) columns
+	values := []decimalTest{decimalData[0], decimalData[len(decimalData)-3], decimalData[len(decimalData)-1]}
+	table := &TableMapEvent{ColumnCount: uint64(len(values))}
+	data := []byte{0x00}
+	for _, v := range values {
+		table.ColumnType = append(table.ColumnType, mysql.MYSQL_TYPE_NEWDECIMAL)
+		table.ColumnMeta = append(table.ColumnMeta, v.meta)
+		data = append(data, v.dumpData...)
+	}
+	e := &RowsEvent{Table: table, ColumnCount: table.ColumnCount}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e.Rows, e.SkippedColumns = e.Rows[:0], e.SkippedColumns[:0]
+		if _, err := e.decodeImage(data, []byte{0x07}, EnumRowImageTypeWriteAI); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func TestDecimal(t *testing.T) {
 	e := &RowsEvent{useDecimal: true}
 	e2 := &RowsEvent{useDecimal: false}
@@ -1539,3 +2097,3198 @@ func BenchmarkInt(b *testing.B) {
 		}
 	}
 }
+
+func TestRowsEventDecodeImagePartialJSONSkippedColumn(t *testing.T) {
+	// id INT, j1 JSON, j2 JSON. j1 is excluded from the after image, but the
+	// partial bitmap still has one bit for every JSON column.
+	table := TableMapEvent{
+		ColumnType: []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_JSON, mysql.MYSQL_TYPE_JSON},
+		ColumnMeta: []uint16{0, 4, 4},
+	}
+	bitmap := []byte{0x05}
+
+	testcases := []struct {
+		data     []byte
+		expected interface{}
+		diffs    int
+	}{
+		// partial bitmap: j1 partial, j2 full. j2 = 3
+		{
+			[]byte("\x01\x01\x00\x01\x00\x00\x00\x03\x00\x00\x00\x05\x03\x00"),
+			"3",
+			0,
+		},
+		// partial bitmap: j1 full, j2 partial. JSON_REPLACE(j2, '$.a', 3)
+		{
+			[]byte("\x01\x02\x00\x01\x00\x00\x00\x09\x00\x00\x00\x00\x03$.a\x03\x05\x03\x00"),
+			&JsonDiff{Op: JsonDiffOperationReplace, Path: "$.a", Value: "3"},
+			1,
+		},
+	}
+
+	for _, tc := range testcases {
+		e := RowsEvent{
+			eventType:   PARTIAL_UPDATE_ROWS_EVENT,
+			Table:       &table,
+			ColumnCount: uint64(len(table.ColumnType)),
+		}
+		n, err := e.decodeImage(tc.data, bitmap, EnumRowImageTypeUpdateAI)
+		require.NoError(t, err)
+		require.Len(t, tc.data, n)
+
+		require.Equal(t, []int{1}, e.SkippedColumns[0])
+		row := e.Rows[0]
+		require.Equal(t, int32(1), row[0])
+		require.Nil(t, row[1])
+		require.Equal(t, tc.expected, row[2])
+		require.Equal(t, RowsEventDecodeStats{Rows: 1, SkippedColumns: 1, JSONPartialDiffs: tc.diffs}, e.DecodeStats())
+	}
+}
+
+func TestRowsEventDecodeImagePartialJSONColumns(t *testing.T) {
+	// id INT, j1 JSON, j2 JSON, j3 JSON, the partial bitmap has a bit per JSON column in order
+	table := TableMapEvent{
+		ColumnType: []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_JSON, mysql.MYSQL_TYPE_JSON, mysql.MYSQL_TYPE_JSON},
+		ColumnMeta: []uint16{0, 4, 4, 4},
+	}
+	replace := []byte("\x09\x00\x00\x00\x00\x03$.a\x03\x05\x03\x00") // JSON_REPLACE(j, '$.a', 3)
+	remove := []byte("\x05\x00\x00\x00\x02\x03$.b")                  // JSON_REMOVE(j, '$.b')
+	full := []byte("\x03\x00\x00\x00\x05\x03\x00")                   // 3
+	replaceDiff := &JsonDiff{Op: JsonDiffOperationReplace, Path: "$.a", Value: "3"}
+	removeDiff := &JsonDiff{Op: JsonDiffOperationRemove, Path: "$.b"}
+
+	testcases := []struct {
+		partialBitmap byte
+		values        [][]byte
+		expected      []interface{}
+		diffs         int
+	}{
+		// j1 and j3 partial, j2 full
+		{0x05, [][]byte{replace, full, remove}, []interface{}{replaceDiff, "3", removeDiff}, 2},
+		// j2 partial
+		{0x02, [][]byte{full, remove, full}, []interface{}{"3", removeDiff, "3"}, 1},
+		// j3 partial
+		{0x04, [][]byte{full, full, replace}, []interface{}{"3", "3", replaceDiff}, 1},
+		// all partial
+		{0x07, [][]byte{remove, replace, remove}, []interface{}{removeDiff, replaceDiff, removeDiff}, 3},
+	}
+
+	for _, tc := range testcases {
+		// partial JSON updates, the partial bitmap, the NULL bitmap and id = 1
+		data := []byte{0x01, tc.partialBitmap, 0x00, 0x01, 0x00, 0x00, 0x00}
+		for _, v := range tc.values {
+			data = append(data, v...)
+		}
+		e := RowsEvent{
+			eventType:   PARTIAL_UPDATE_ROWS_EVENT,
+			Table:       &table,
+			ColumnCount: uint64(len(table.ColumnType)),
+		}
+		n, err := e.decodeImage(data, []byte{0x0f}, EnumRowImageTypeUpdateAI)
+		require.NoError(t, err)
+		require.Len(t, data, n)
+		require.Equal(t, append([]interface{}{int32(1)}, tc.expected...), e.Rows[0], "partial bitmap %#x", tc.partialBitmap)
+		require.Equal(t, tc.diffs, e.DecodeStats().JSONPartialDiffs)
+	}
+}
+
+func TestRowsEventDecodePartialUpdateBeforeImage(t *testing.T) {
+	// CREATE TABLE t (id INT, j JSON)
+	table := &TableMapEvent{
+		tableIDSize: 6,
+		TableID:     0x1d3,
+		ColumnCount: 2,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_JSON},
+		ColumnMeta:  []uint16{0, 4},
+	}
+	// UPDATE t SET j = JSON_REPLACE(j, '$.a', 2) WHERE id = 1, with j = {"a": 1}
+	header := []byte("\xd3\x01\x00\x00\x00\x00\x01\x00\x02\x00\x02\x03\x03")
+	// a full image: NULL bitmap, id, and j with a 4 bytes length
+	beforeImage := []byte("\x00\x01\x00\x00\x00\x0d\x00\x00\x00" +
+		"\x00\x01\x00\x0c\x00\x0b\x00\x01\x00\x05\x01\x00a")
+	// binlog_row_value_options with PARTIAL_JSON_UPDATES, the partial bitmap,
+	// NULL bitmap, id, and the diff of j
+	afterImage := []byte("\x01\x01\x00\x01\x00\x00\x00\x09\x00\x00\x00" +
+		"\x00\x03$.a\x03\x05\x02\x00")
+	data := append(append(append([]byte{}, header...), beforeImage...), afterImage...)
+
+	rows := &RowsEvent{
+		tableIDSize: 6,
+		tables:      map[uint64]*TableMapEvent{table.TableID: table},
+		Version:     2,
+		eventType:   PARTIAL_UPDATE_ROWS_EVENT,
+		needBitmap2: true,
+	}
+	require.NoError(t, rows.Decode(data))
+	require.Equal(t, [][]interface{}{
+		{int32(1), `{"a":1}`},
+		{int32(1), &JsonDiff{Op: JsonDiffOperationReplace, Path: "$.a", Value: "2"}},
+	}, rows.Rows)
+	require.Equal(t, []int{len(header), len(header) + len(beforeImage)}, rows.RowOffsets)
+	require.Equal(t, RowsEventDecodeStats{Rows: 2, JSONPartialDiffs: 1}, rows.DecodeStats())
+
+	// the after image isn't partial when binlog_row_value_options is 0,
+	// which doesn't change the before image
+	afterImage = []byte("\x00\x00\x01\x00\x00\x00\x03\x00\x00\x00\x05\x02\x00")
+	data = append(append(append([]byte{}, header...), beforeImage...), afterImage...)
+	require.NoError(t, rows.Decode(data))
+	require.Equal(t, [][]interface{}{{int32(1), `{"a":1}`}, {int32(1), "2"}}, rows.Rows)
+}
+
+func TestTableMapColumns(t *testing.T) {
+	/*
+		CREATE TABLE _columns (
+			id INT UNSIGNED NOT NULL PRIMARY KEY,
+			name VARCHAR(10) COLLATE latin1_swedish_ci,
+			e ENUM('a', 'b') COLLATE utf8mb4_general_ci,
+			x INT INVISIBLE
+		);
+	*/
+	tableMapEvent := &TableMapEvent{
+		ColumnCount:           4,
+		ColumnType:            []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_LONG},
+		ColumnMeta:            []uint16{0, 10, 0xf701, 0},
+		NullBitmap:            []byte{0x0e},
+		ColumnName:            [][]byte{[]byte("id"), []byte("name"), []byte("e"), []byte("x")},
+		SignednessBitmap:      []byte{0x80},
+		DefaultCharset:        []uint64{8},
+		EnumSetDefaultCharset: []uint64{45},
+		EnumStrValue:          [][][]byte{{[]byte("a"), []byte("b")}},
+		PrimaryKey:            []uint64{0},
+		PrimaryKeyPrefix:      []uint64{0},
+		VisibilityBitmap:      []byte{0xe0},
+	}
+
+	require.Equal(t, []ColumnInfo{
+		{Name: "id", Type: mysql.MYSQL_TYPE_LONG, Unsigned: true, PrimaryKey: true},
+		{Name: "name", Type: mysql.MYSQL_TYPE_VARCHAR, Meta: 10, Collation: 8, Nullable: true},
+		{Name: "e", Type: mysql.MYSQL_TYPE_ENUM, Meta: 0xf701, Collation: 45, Nullable: true, EnumValues: []string{"a", "b"}},
+		{Name: "x", Type: mysql.MYSQL_TYPE_LONG, Nullable: true, Invisible: true},
+	}, tableMapEvent.Columns())
+
+	// without optional metadata
+	tableMapEvent = &TableMapEvent{
+		ColumnCount: 2,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR},
+		ColumnMeta:  []uint16{0, 10},
+		NullBitmap:  []byte{0x02},
+	}
+	require.Equal(t, []ColumnInfo{
+		{Type: mysql.MYSQL_TYPE_LONG},
+		{Type: mysql.MYSQL_TYPE_VARCHAR, Meta: 10, Nullable: true},
+	}, tableMapEvent.Columns())
+}
+
+func TestTableMapOptionalMetaTruncated(t *testing.T) {
+	// create table _null (c1 int null, c2 int not null default '2', c3 timestamp default now(), c4 text); mysql 8.0
+	data := []byte("z\x00\x00\x00\x00\x00\x01\x00\x04test\x00\x05_null\x00\x04\x03\x03\x11\xfc\x02\x00\x02\t\x01\x01\x00\x02\x01\xe0\x04\f\x02c1\x02c2\x02c3\x02c4")
+
+	tableMapEvent := new(TableMapEvent)
+	tableMapEvent.tableIDSize = 6
+	require.NoError(t, tableMapEvent.Decode(data))
+
+	testcases := []struct {
+		data   []byte
+		errMsg string
+	}{
+		// column names are truncated
+		{data[:len(data)-1], "optional metadata type 4: value needs 12 bytes but got 11"},
+		// column names length is missing
+		{data[:len(data)-13], "optional metadata type 4: missing length"},
+		// a 3 bytes length with only 1 byte
+		{append(append([]byte{}, data[:len(data)-13]...), 0xfc, 0x01), "optional metadata type 4: length needs 3 bytes but got 2"},
+		// the last column name needs more bytes than its TLV value has
+		{append(append([]byte{}, data[:len(data)-14]...), 0x04, 0x0c, 0x02, 'c', '1', 0x02, 'c', '2', 0x02, 'c', '3', 0x03, 'c', '4'), "column name 3: value needs 3 bytes but got 2"},
+		// a column charset with a truncated 3 bytes integer
+		{append(append([]byte{}, data[:len(data)-14]...), 0x02, 0x02, 0x01, 0xfc), "length needs 3 bytes but got 1"},
+		// a primary key with a prefix but without the prefix length
+		{append(append([]byte{}, data[:len(data)-14]...), 0x09, 0x01, 0x00), "primary key prefix: missing length"},
+		// an enum with 3 values but only one
+		{append(append([]byte{}, data[:len(data)-14]...), 0x06, 0x03, 0x03, 0x01, 'a'), "3 values need at least 3 bytes but got 2"},
+	}
+	for _, tc := range testcases {
+		tableMapEvent := new(TableMapEvent)
+		tableMapEvent.tableIDSize = 6
+		err := tableMapEvent.Decode(tc.data)
+		require.ErrorContains(t, err, tc.errMsg)
+	}
+}
+
+func TestTableMapOptionalMetaDecodeFunc(t *testing.T) {
+	// create table _null (c1 int null, c2 int not null default '2', c3 timestamp default now(), c4 text); mysql 8.0
+	data := []byte("z\x00\x00\x00\x00\x00\x01\x00\x04test\x00\x05_null\x00\x04\x03\x03\x11\xfc\x02\x00\x02\t\x01\x01\x00\x02\x01\xe0\x04\f\x02c1\x02c2\x02c3\x02c4")
+	// a proprietary type 0xf0 with a 2 bytes value
+	data = append(data, 0xf0, 0x02, 'h', 'i')
+
+	var got []byte
+	var custom []byte
+	tableMapEvent := new(TableMapEvent)
+	tableMapEvent.tableIDSize = 6
+	tableMapEvent.SetOptionalMetaDecodeFunc(func(data []byte) error {
+		got = data
+		if err := tableMapEvent.DecodeOptionalMeta(data); err != nil {
+			return err
+		}
+		custom = data[len(data)-2:]
+		return nil
+	})
+	require.NoError(t, tableMapEvent.Decode(data))
+	require.Equal(t, []byte("\x01\x01\x00\x02\x01\xe0\x04\f\x02c1\x02c2\x02c3\x02c4\xf0\x02hi"), got)
+	require.Equal(t, []byte("hi"), custom)
+	require.Equal(t, [][]byte{[]byte("c1"), []byte("c2"), []byte("c3"), []byte("c4")}, tableMapEvent.ColumnName)
+
+	// the default decoding skips the unknown type
+	tableMapEvent.SetOptionalMetaDecodeFunc(nil)
+	got = nil
+	require.NoError(t, tableMapEvent.Decode(data))
+	require.Nil(t, got)
+	require.Len(t, tableMapEvent.ColumnName, 4)
+
+	errCustom := fmt.Errorf("custom error")
+	tableMapEvent.SetOptionalMetaDecodeFunc(func([]byte) error { return errCustom })
+	require.ErrorIs(t, tableMapEvent.Decode(data), errCustom)
+}
+
+func TestTableIDSizeMismatch(t *testing.T) {
+	// table id is 6 bytes
+	tableMapEventData := []byte("\xd3\x01\x00\x00\x00\x00\x01\x00\x04test\x00\nfunnytable\x00\x01\x01\x00\x01")
+
+	tableMapEvent := new(TableMapEvent)
+	err := tableMapEvent.Decode(tableMapEventData)
+	require.ErrorContains(t, err, "invalid table id size 0")
+
+	tableMapEvent.tableIDSize = 4
+	err = tableMapEvent.Decode(tableMapEventData)
+	require.ErrorContains(t, err, "table id size 4 may be wrong")
+
+	tableMapEvent.tableIDSize = 6
+	err = tableMapEvent.Decode(tableMapEventData)
+	require.NoError(t, err)
+
+	rows := new(RowsEvent)
+	rows.tables = make(map[uint64]*TableMapEvent)
+	rows.tables[tableMapEvent.TableID] = tableMapEvent
+	rows.Version = 2
+
+	data := []byte("\xd3\x01\x00\x00\x00\x00\x01\x00\x02\x00\x01\xff\xfe\x01\xff\xfe\x02")
+	_, err = rows.DecodeHeader(data)
+	require.ErrorContains(t, err, "invalid table id size 0")
+
+	rows.tableIDSize = 4
+	_, err = rows.DecodeHeader(data)
+	require.ErrorContains(t, err, "table id size 4 may be wrong")
+
+	rows.tableIDSize = 6
+	_, err = rows.DecodeHeader(data[:7])
+	require.ErrorContains(t, err, "too short for table id size 6")
+
+	_, err = rows.DecodeHeader(data)
+	require.NoError(t, err)
+}
+
+func TestPeekTableID(t *testing.T) {
+	testcases := []struct {
+		data        []byte
+		tableIDSize int
+		expected    uint64
+	}{
+		{[]byte("\xd3\x01\x00\x00\x00\x00\x01\x00\x02\x00\x01\xff\xfe\x01"), 6, 0x1d3},
+		{[]byte("\x01\x02\x03\x04\x05\x06\x01\x00"), 6, 0x060504030201},
+		{[]byte("\x01\x02\x03\x04\x01\x00"), 4, 0x04030201},
+		{[]byte("\x01\x02\x03"), 4, 0},
+	}
+
+	for _, tc := range testcases {
+		require.Equal(t, tc.expected, PeekTableID(tc.data, tc.tableIDSize))
+
+		if len(tc.data) < tc.tableIDSize+2 {
+			continue
+		}
+		rows := RowsEvent{tableIDSize: tc.tableIDSize, Version: 1}
+		_, _ = rows.DecodeHeader(tc.data)
+		require.Equal(t, rows.TableID, PeekTableID(tc.data, tc.tableIDSize))
+	}
+}
+
+func TestDecodeDecimalTruncated(t *testing.T) {
+	// DECIMAL(10,2) 123.45 takes 5 bytes
+	data := []byte{0x80, 0x00, 0x00, 0x7b, 0x2d}
+	v, n, err := decodeDecimal(data, 10, 2, false)
+	require.NoError(t, err)
+	require.Equal(t, "123.45", v)
+	require.Equal(t, 5, n)
+
+	for i := 0; i < len(data); i++ {
+		_, _, err = decodeDecimal(data[:i], 10, 2, false)
+		require.ErrorIs(t, err, ErrTruncatedDecimal)
+		require.ErrorContains(t, err, "decimal(10,2)")
+	}
+
+	e := &RowsEvent{}
+	_, _, err = e.decodeValue(data[:3], mysql.MYSQL_TYPE_NEWDECIMAL, 10<<8|2, false)
+	require.ErrorIs(t, err, ErrTruncatedDecimal)
+
+	// impossible precision or scale
+	_, _, err = decodeDecimal(data, 66, 2, false)
+	require.ErrorContains(t, err, "invalid decimal(66,2)")
+	_, _, err = decodeDecimal(data, 40, 31, false)
+	require.ErrorContains(t, err, "invalid decimal(40,31)")
+}
+
+func TestDecodeLegacyDecimal(t *testing.T) {
+	testcases := []struct {
+		data     string
+		expected string
+	}{
+		{"  -12.50", "-12.50"},
+		{"   12.50", "12.50"},
+		{"00012.50", "12.50"},
+		{"    0.00", "0.00"},
+		{"    -.50", "-0.50"},
+		{"     123", "123"},
+		{"    123.", "123"},
+	}
+	for _, tc := range testcases {
+		v, err := DecodeLegacyDecimal([]byte(tc.data))
+		require.NoError(t, err, tc.data)
+		require.Equal(t, tc.expected, v)
+	}
+
+	for _, data := range []string{"", "   ", " -", "  .", "1.2.3", " 12a.0", "1 2"} {
+		_, err := DecodeLegacyDecimal([]byte(data))
+		require.Error(t, err, data)
+	}
+
+	// CREATE TABLE t (id INT, d DECIMAL(5,2)) on MySQL 4.1, the field length is 7
+	table := &TableMapEvent{
+		ColumnCount: 2,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_DECIMAL},
+		ColumnMeta:  []uint16{0, 0},
+	}
+	data := []byte("\x00\x01\x00\x00\x00 -12.50")
+
+	e := &RowsEvent{Table: table, ColumnCount: 2}
+	_, err := e.decodeImage(data, []byte{0x03}, EnumRowImageTypeWriteAI)
+	require.ErrorContains(t, err, "legacy decimal")
+
+	e.unknownTypeDecodeFunc = func(tp byte, meta uint16, data []byte) (interface{}, int, error) {
+		require.Equal(t, byte(mysql.MYSQL_TYPE_DECIMAL), tp)
+		if len(data) < 7 {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		v, err := DecodeLegacyDecimal(data[:7])
+		return v, 7, err
+	}
+	n, err := e.decodeImage(data, []byte{0x03}, EnumRowImageTypeWriteAI)
+	require.NoError(t, err)
+	require.Equal(t, len(data), n)
+	require.Equal(t, []interface{}{int32(1), "-12.50"}, e.Rows[0])
+
+	// the built-in decoding with the field length from the schema
+	table.Schema, table.Table = []byte("db"), []byte("t")
+	e = &RowsEvent{Table: table, ColumnCount: 2}
+	e.legacyDecimalLengthFunc = func(schema, table string, column int) int {
+		require.Equal(t, "db", schema)
+		require.Equal(t, "t", table)
+		require.Equal(t, 1, column)
+		return 7
+	}
+	n, err = e.decodeImage(data, []byte{0x03}, EnumRowImageTypeWriteAI)
+	require.NoError(t, err)
+	require.Equal(t, len(data), n)
+	require.Equal(t, []interface{}{int32(1), "-12.50"}, e.Rows[0])
+
+	e.useDecimal = true
+	_, err = e.decodeImage(data, []byte{0x03}, EnumRowImageTypeWriteAI)
+	require.NoError(t, err)
+	require.Equal(t, decimal.RequireFromString("-12.50"), e.Rows[1][1])
+
+	_, err = e.decodeImage(data[:len(data)-1], []byte{0x03}, EnumRowImageTypeWriteAI)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	e.legacyDecimalLengthFunc = func(schema, table string, column int) int { return 0 }
+	_, err = e.decodeImage(data, []byte{0x03}, EnumRowImageTypeWriteAI)
+	require.ErrorContains(t, err, "invalid field length 0 of legacy decimal column 1")
+}
+
+func TestDecodeDecimalInvalidMeta(t *testing.T) {
+	e := &RowsEvent{}
+	for _, meta := range []uint16{0x0000, 0x0002, 5<<8 | 6} {
+		_, _, err := e.decodeValue([]byte{0x80, 0x00, 0x01}, mysql.MYSQL_TYPE_NEWDECIMAL, meta, false)
+		require.ErrorIs(t, err, ErrInvalidDecimalMeta, "meta %#04x", meta)
+	}
+	_, err := encodeDecimal("1", 0, 0)
+	require.ErrorIs(t, err, ErrInvalidDecimalMeta)
+
+	// a malformed table map event with DECIMAL(0,0), the row is 1
+	table := &TableMapEvent{
+		ColumnCount: 1,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_NEWDECIMAL},
+		ColumnMeta:  []uint16{0x0000},
+	}
+	e = &RowsEvent{Table: table, ColumnCount: 1, ColumnBitmap1: []byte{0x01}}
+	err = e.DecodeData(0, []byte{0x00, 0x81})
+	require.ErrorIs(t, err, ErrInvalidDecimalMeta)
+	require.ErrorContains(t, err, "invalid decimal(0,0)")
+}
+
+func TestTableMapColumnLength(t *testing.T) {
+	// CREATE TABLE t (a INT, b VARCHAR(20), c CHAR(10), d CHAR(255) CHARACTER SET utf8mb4,
+	// e ENUM('x'), f BIT(10), g DECIMAL(10,2), h BLOB)
+	tableMapEvent := &TableMapEvent{
+		ColumnCount: 8,
+		ColumnType: []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_STRING,
+			mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_BIT, mysql.MYSQL_TYPE_NEWDECIMAL, mysql.MYSQL_TYPE_BLOB},
+		ColumnMeta: []uint16{0, 20, 0xfe0a, 0xcefc, 0xf701, 0x0102, 0x0a02, 2},
+	}
+	expected := []struct {
+		length int
+		ok     bool
+	}{{0, false}, {20, true}, {10, true}, {1020, true}, {0, false}, {10, true}, {10, true}, {0, false}}
+	for i, exp := range expected {
+		length, ok := tableMapEvent.ColumnLength(i)
+		require.Equal(t, exp.ok, ok, "column %d", i)
+		require.Equal(t, exp.length, length, "column %d", i)
+	}
+	_, ok := tableMapEvent.ColumnLength(8)
+	require.False(t, ok)
+
+	size, ok := tableMapEvent.ColumnDecimalSize(6)
+	require.True(t, ok)
+	require.Equal(t, DecimalSize{Precision: 10, Scale: 2}, size)
+	_, ok = tableMapEvent.ColumnDecimalSize(0)
+	require.False(t, ok)
+}
+
+func TestTableMapColumnTypeName(t *testing.T) {
+	tableMapEvent := &TableMapEvent{
+		ColumnCount: 7,
+		ColumnType: []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_STRING,
+			mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_DATETIME2, mysql.MYSQL_TYPE_DATE},
+		ColumnMeta: []uint16{0, 10, 0xfe0a, 0xf701, 0xf801, 0, 0},
+		NullBitmap: []byte{0x00},
+	}
+	expected := []string{"INT", "VARCHAR", "CHAR", "ENUM", "SET", "DATETIME", "DATE"}
+	for i, name := range expected {
+		require.Equal(t, name, tableMapEvent.ColumnTypeName(i))
+	}
+
+	var buf bytes.Buffer
+	tableMapEvent.Dump(&buf)
+	require.Contains(t, buf.String(), "type=ENUM     (247)")
+}
+
+func TestTableMapGoType(t *testing.T) {
+	columnType := []byte{
+		mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_NEWDECIMAL, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_VARCHAR,
+		mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_BIT, mysql.MYSQL_TYPE_DATETIME2,
+		mysql.MYSQL_TYPE_TIME2, mysql.MYSQL_TYPE_DATE, mysql.MYSQL_TYPE_BLOB, mysql.MYSQL_TYPE_JSON,
+		mysql.MYSQL_TYPE_NULL,
+	}
+	columnMeta := []uint16{0, 10<<8 | 2, 800, 20, 0xf701, 0xf801, 1<<8 | 4, 3, 0, 0, 2, 4, 0}
+	table := &TableMapEvent{
+		ColumnCount: uint64(len(columnType)),
+		ColumnType:  columnType,
+		ColumnMeta:  columnMeta,
+		// utf8mb4_0900_ai_ci, binary
+		collations: map[int]uint64{2: 255, 3: 63},
+	}
+
+	s, b := reflect.TypeOf(""), reflect.TypeOf([]byte(nil))
+	expected := []reflect.Type{
+		reflect.TypeOf(int32(0)), s, s, s,
+		reflect.TypeOf(int64(0)), reflect.TypeOf(int64(0)), reflect.TypeOf(int64(0)), s,
+		s, s, b, s,
+		nil,
+	}
+	for i, tp := range expected {
+		require.Equal(t, tp, table.GoType(i, DecodeOptions{}), "column %d", i)
+	}
+
+	opts := DecodeOptions{
+		UseDecimal:        true,
+		ParseTime:         true,
+		UseMySQLTime:      true,
+		BitAsBytes:        true,
+		EnumSetWithLabels: true,
+		BinaryAsBytes:     true,
+		JSONAsNative:      true,
+	}
+	expected = []reflect.Type{
+		reflect.TypeOf(int32(0)), reflect.TypeOf(decimal.Decimal{}), s, b,
+		reflect.TypeOf(EnumValue{}), reflect.TypeOf(SetValue{}), b, reflect.TypeOf(time.Time{}),
+		reflect.TypeOf(MySQLTime{}), s, b, reflect.TypeOf((*interface{})(nil)).Elem(),
+		nil,
+	}
+	for i, tp := range expected {
+		require.Equal(t, tp, table.GoType(i, opts), "column %d", i)
+	}
+}
+
+func TestTableMapColumnDescriptor(t *testing.T) {
+	columnType := []byte{
+		mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_NEWDECIMAL, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_VARCHAR,
+		mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_BIT,
+		mysql.MYSQL_TYPE_DATETIME2, mysql.MYSQL_TYPE_TIME2, mysql.MYSQL_TYPE_BLOB, mysql.MYSQL_TYPE_BLOB,
+		mysql.MYSQL_TYPE_GEOMETRY, mysql.MYSQL_TYPE_VARCHAR,
+	}
+	columnMeta := []uint16{0, 10<<8 | 2, 800, 20, 0xfe0a, 0xf701, 0xf801, 1<<8 | 4, 3, 0, 2, 4, 4, 80}
+	table := &TableMapEvent{
+		ColumnCount: uint64(len(columnType)),
+		ColumnType:  columnType,
+		ColumnMeta:  columnMeta,
+		// the INT is unsigned, the DECIMAL is not
+		SignednessBitmap: []byte{0x80},
+		GeometryType:     []uint64{1},
+		// utf8mb4_0900_ai_ci, binary, latin1_swedish_ci
+		collations: map[int]uint64{2: 255, 3: 63, 4: 8, 10: 255},
+		enumLabels: map[int][]string{5: {"a", "it's"}},
+		setLabels:  map[int][]string{},
+	}
+	expected := []string{
+		"INT UNSIGNED", "DECIMAL(10,2)", "VARCHAR(200)", "VARBINARY(20)",
+		"CHAR(10)", "ENUM('a','it''s')", "SET", "BIT(12)",
+		"DATETIME(3)", "TIME", "TEXT", "LONGBLOB",
+		"POINT", "VARCHAR(80)",
+	}
+	for i, desc := range expected {
+		require.Equal(t, desc, table.ColumnDescriptor(i), "column %d", i)
+	}
+
+	var buf bytes.Buffer
+	table.Dump(&buf)
+	require.Contains(t, buf.String(), "desc=ENUM('a','it''s')")
+
+	// no optional metadata
+	table = &TableMapEvent{ColumnCount: uint64(len(columnType)), ColumnType: columnType, ColumnMeta: columnMeta}
+	expected = []string{
+		"INT", "DECIMAL(10,2)", "VARCHAR(800)", "VARCHAR(20)",
+		"CHAR(10)", "ENUM", "SET", "BIT(12)",
+		"DATETIME(3)", "TIME", "BLOB", "LONGBLOB",
+		"GEOMETRY", "VARCHAR(80)",
+	}
+	for i, desc := range expected {
+		require.Equal(t, desc, table.ColumnDescriptor(i), "column %d", i)
+	}
+}
+
+func TestRowsEventDriverValues(t *testing.T) {
+	ts := time.Date(2016, 10, 28, 15, 30, 42, 0, time.UTC)
+	e := &RowsEvent{
+		Rows: [][]interface{}{
+			{
+				nil, int8(-1), int16(2), int32(3), int64(4), uint64(math.MaxUint64),
+				float32(1.5), float64(2.5), decimal.New(123, -2),
+				"a", []byte("b"), ts, fracTime{Time: ts, Dec: 2},
+			},
+			{&JsonDiff{Op: JsonDiffOperationReplace, Path: "$.a", Value: "1"}},
+		},
+	}
+
+	values, err := e.DriverValues(0)
+	require.NoError(t, err)
+	require.Equal(t, []driver.Value{
+		nil, int64(-1), int64(2), int64(3), int64(4), "18446744073709551615",
+		float64(1.5), float64(2.5), "1.23",
+		"a", []byte("b"), ts, ts,
+	}, values)
+	for _, v := range values {
+		require.True(t, driver.IsValue(v))
+	}
+
+	_, err = e.DriverValues(1)
+	require.ErrorContains(t, err, "unsupported driver value type *replication.JsonDiff")
+
+	_, err = e.DriverValues(2)
+	require.Error(t, err)
+}
+
+// newUnsignedMaxEvent returns a rows event with the max values of the UNSIGNED
+// TINYINT, SMALLINT, MEDIUMINT, INT and BIGINT columns, and -1 in a signed INT column.
+func newUnsignedMaxEvent(t *testing.T) *RowsEvent {
+	table := &TableMapEvent{
+		ColumnCount: 6,
+		ColumnType: []byte{
+			mysql.MYSQL_TYPE_TINY, mysql.MYSQL_TYPE_SHORT, mysql.MYSQL_TYPE_INT24,
+			mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_LONGLONG, mysql.MYSQL_TYPE_LONG,
+		},
+		ColumnMeta:       make([]uint16, 6),
+		SignednessBitmap: []byte{0xf8},
+		ColumnName:       [][]byte{[]byte("t"), []byte("s"), []byte("m"), []byte("i"), []byte("b"), []byte("signed")},
+	}
+	data := append([]byte{0x00}, bytes.Repeat([]byte{0xff}, 1+2+3+4+8+4)...)
+	e := &RowsEvent{Table: table, ColumnCount: 6}
+	_, err := e.decodeImage(data, []byte{0x3f}, EnumRowImageTypeWriteAI)
+	require.NoError(t, err)
+	return e
+}
+
+func TestRowsEventDriverValuesUnsigned(t *testing.T) {
+	e := newUnsignedMaxEvent(t)
+	values, err := e.DriverValues(0)
+	require.NoError(t, err)
+	require.Equal(t, []driver.Value{
+		int64(math.MaxUint8), int64(math.MaxUint16), int64(1<<24 - 1),
+		int64(math.MaxUint32), "18446744073709551615", int64(-1),
+	}, values)
+}
+
+func TestRowsEventValueLiteralsUnsigned(t *testing.T) {
+	e := newUnsignedMaxEvent(t)
+	literals, err := e.ValueLiterals(0)
+	require.NoError(t, err)
+	require.Equal(t, []string{"255", "65535", "16777215", "4294967295", "18446744073709551615", "-1"}, literals)
+}
+
+func TestRowsEventAvroValuesUnsigned(t *testing.T) {
+	e := newUnsignedMaxEvent(t)
+	_, err := e.AvroValues(0)
+	require.ErrorContains(t, err, "value 18446744073709551615 overflows long")
+
+	e.Rows[0][4] = int64(math.MaxInt64)
+	values, err := e.AvroValues(0)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"t": int32(math.MaxUint8), "s": int32(math.MaxUint16), "m": int32(1<<24 - 1),
+		"i": int64(math.MaxUint32), "b": int64(math.MaxInt64), "signed": int32(-1),
+	}, values)
+}
+
+// newNativeJSONEvent returns a rows event decoded with JSONAsNative, with 1 in an INT
+// column and [1.5, 70000, true, "x"] in a JSON column.
+func newNativeJSONEvent(t *testing.T) *RowsEvent {
+	table := &TableMapEvent{
+		ColumnCount: 2,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_JSON},
+		ColumnMeta:  []uint16{0, 1},
+		ColumnName:  [][]byte{[]byte("id"), []byte("j")},
+	}
+	arr := []byte{
+		JSONB_LARGE_ARRAY,
+		0x04, 0x00, 0x00, 0x00, 0x26, 0x00, 0x00, 0x00, // count, size
+		JSONB_DOUBLE, 0x1c, 0x00, 0x00, 0x00,
+		JSONB_UINT32, 0x70, 0x11, 0x01, 0x00,
+		JSONB_LITERAL, JSONB_TRUE_LITERAL, 0x00, 0x00, 0x00,
+		JSONB_STRING, 0x24, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf8, 0x3f,
+		0x01, 'x',
+	}
+	data := append([]byte{0x00, 0x01, 0x00, 0x00, 0x00, byte(len(arr))}, arr...)
+	e := &RowsEvent{Table: table, ColumnCount: 2, jsonAsNative: true}
+	_, err := e.decodeImage(data, []byte{0x03}, EnumRowImageTypeWriteAI)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{1.5, uint64(70000), true, "x"}, e.Rows[0][1])
+	return e
+}
+
+func TestRowsEventConvertNativeJSON(t *testing.T) {
+	e := newNativeJSONEvent(t)
+
+	values, err := e.DriverValues(0)
+	require.NoError(t, err)
+	require.Equal(t, []driver.Value{int64(1), `[1.5,70000,true,"x"]`}, values)
+
+	literals, err := e.ValueLiterals(0)
+	require.NoError(t, err)
+	require.Equal(t, []string{"1", `'[1.5,70000,true,\"x\"]'`}, literals)
+
+	avro, err := e.AvroValues(0)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"id": int32(1), "j": `[1.5,70000,true,"x"]`}, avro)
+
+	// a JSON string is quoted as in the JSON text
+	e.Rows[0][1] = "x"
+	values, err = e.DriverValues(0)
+	require.NoError(t, err)
+	require.Equal(t, `"x"`, values[1])
+}
+
+func TestRowsEventTableIDSizeMismatch(t *testing.T) {
+	tableMapEvent := &TableMapEvent{
+		tableIDSize: 4,
+		TableID:     0x1d3,
+		ColumnCount: 1,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_TINY},
+		ColumnMeta:  []uint16{0},
+	}
+	rows := &RowsEvent{
+		tableIDSize: 6,
+		tables:      map[uint64]*TableMapEvent{0x1d3: tableMapEvent},
+		Version:     2,
+	}
+
+	data := []byte("\xd3\x01\x00\x00\x00\x00\x01\x00\x02\x00\x01\xff\xfe\x01")
+	_, err := rows.DecodeHeader(data)
+	require.ErrorIs(t, err, ErrTableIDSizeMismatch)
+
+	tableMapEvent.tableIDSize = 6
+	_, err = rows.DecodeHeader(data)
+	require.NoError(t, err)
+
+	// unset on a hand-built table map event
+	tableMapEvent.tableIDSize = 0
+	_, err = rows.DecodeHeader(data)
+	require.NoError(t, err)
+}
+
+func TestDecodeDatetimeLeapSecond(t *testing.T) {
+	datetime2 := func(year, month, day, hour, minute, second int64, frac ...byte) []byte {
+		ymd := (year*13+month)<<5 | day
+		hms := hour<<12 | minute<<6 | second
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], uint64((ymd<<17|hms)+DATETIMEF_INT_OFS))
+		return append(buf[3:], frac...)
+	}
+
+	for _, parseTime := range []bool{false, true} {
+		e := &RowsEvent{parseTime: parseTime}
+
+		// the leap second isn't normalized into 2017-01-01 00:00:00
+		v, n, err := e.decodeValue(datetime2(2016, 12, 31, 23, 59, 60), mysql.MYSQL_TYPE_DATETIME2, 0, false)
+		require.NoError(t, err)
+		require.Equal(t, 5, n)
+		require.Equal(t, "2016-12-31 23:59:60", v)
+
+		v, n, err = e.decodeValue(datetime2(2016, 12, 31, 23, 59, 60, 0x04, 0xd2), mysql.MYSQL_TYPE_DATETIME2, 4, false)
+		require.NoError(t, err)
+		require.Equal(t, 7, n)
+		require.Equal(t, "2016-12-31 23:59:60.1234", v)
+
+		// DATETIME before MySQL 5.6.4, YYYYMMDDhhmmss
+		data := make([]byte, 8)
+		binary.LittleEndian.PutUint64(data, 20161231235960)
+		v, n, err = e.decodeValue(data, mysql.MYSQL_TYPE_DATETIME, 0, false)
+		require.NoError(t, err)
+		require.Equal(t, 8, n)
+		require.Equal(t, "2016-12-31 23:59:60", v)
+
+		// a valid time is still decoded as usual
+		v, _, err = e.decodeValue(datetime2(2016, 12, 31, 23, 59, 59), mysql.MYSQL_TYPE_DATETIME2, 0, false)
+		require.NoError(t, err)
+		if parseTime {
+			require.Equal(t, time.Date(2016, 12, 31, 23, 59, 59, 0, time.UTC), v)
+		} else {
+			require.Equal(t, "2016-12-31 23:59:59", v)
+		}
+	}
+}
+
+func TestDecodeTemporal2Fsp(t *testing.T) {
+	// 12:30:45.123456 truncated to the fsp, an odd fsp is stored with one more digit
+	fracBytes := func(dec int, micro int64) []byte {
+		switch dec {
+		case 1, 2:
+			return []byte{byte(micro / 10000)}
+		case 3, 4:
+			return []byte{byte(micro / 100 >> 8), byte(micro / 100)}
+		case 5, 6:
+			return []byte{byte(micro >> 16), byte(micro >> 8), byte(micro)}
+		}
+		return nil
+	}
+	bigEndian := func(v int64, size int) []byte {
+		b := make([]byte, size)
+		for i := size - 1; i >= 0; i-- {
+			b[i] = byte(v)
+			v >>= 8
+		}
+		return b
+	}
+	hms := int64(12<<12 | 30<<6 | 45)
+	ts := time.Date(2024, 6, 1, 12, 30, 45, 0, time.UTC)
+
+	for dec := 0; dec <= 6; dec++ {
+		micro := int64(123456)
+		for i := dec; i < 6; i++ {
+			micro /= 10
+		}
+		for i := dec; i < 6; i++ {
+			micro *= 10
+		}
+		size := (dec + 1) / 2
+		frac := formatFrac(int(micro), dec)
+
+		// DATETIME2
+		data := bigEndian((((2024*13+6)<<5|1)<<17|hms)+DATETIMEF_INT_OFS, 5)
+		data = append(data, fracBytes(dec, micro)...)
+		require.Len(t, data, 5+size)
+		e := &RowsEvent{parseTime: true}
+		v, n, err := e.decodeValue(data, mysql.MYSQL_TYPE_DATETIME2, uint16(dec), false)
+		require.NoError(t, err)
+		require.Equal(t, 5+size, n, "DATETIME2(%d)", dec)
+		require.Equal(t, ts.Add(time.Duration(micro)*time.Microsecond), v, "DATETIME2(%d)", dec)
+		e = &RowsEvent{}
+		v, _, err = e.decodeValue(data, mysql.MYSQL_TYPE_DATETIME2, uint16(dec), false)
+		require.NoError(t, err)
+		require.Equal(t, "2024-06-01 12:30:45"+frac, v, "DATETIME2(%d)", dec)
+
+		// TIMESTAMP2
+		data = bigEndian(ts.Unix(), 4)
+		data = append(data, fracBytes(dec, micro)...)
+		e = &RowsEvent{timestampStringLocation: time.UTC}
+		v, n, err = e.decodeValue(data, mysql.MYSQL_TYPE_TIMESTAMP2, uint16(dec), false)
+		require.NoError(t, err)
+		require.Equal(t, 4+size, n, "TIMESTAMP2(%d)", dec)
+		require.Equal(t, "2024-06-01 12:30:45"+frac, v, "TIMESTAMP2(%d)", dec)
+
+		// TIME2
+		if dec < 5 {
+			data = bigEndian(hms+TIMEF_INT_OFS, 3)
+			data = append(data, fracBytes(dec, micro)...)
+		} else {
+			data = bigEndian(hms<<24+micro+TIMEF_OFS, 6)
+		}
+		e = &RowsEvent{useMySQLTime: true}
+		v, n, err = e.decodeValue(data, mysql.MYSQL_TYPE_TIME2, uint16(dec), false)
+		require.NoError(t, err)
+		require.Equal(t, 3+size, n, "TIME2(%d)", dec)
+		require.Equal(t, MySQLTime{Hours: 12, Minutes: 30, Seconds: 45, Microseconds: int(micro), Dec: dec}, v, "TIME2(%d)", dec)
+		require.Equal(t, "12:30:45"+frac, v.(MySQLTime).String())
+	}
+
+	// fsp beyond 6 is invalid
+	e := &RowsEvent{}
+	data := make([]byte, 16)
+	for _, tp := range []byte{mysql.MYSQL_TYPE_DATETIME2, mysql.MYSQL_TYPE_TIMESTAMP2, mysql.MYSQL_TYPE_TIME2} {
+		_, _, err := e.decodeValue(data, tp, 7, false)
+		require.ErrorContains(t, err, "fsp 7")
+	}
+}
+
+func TestDecodeTimestamp2Precision(t *testing.T) {
+	// 1477668642 is 2016-10-28 15:30:42 UTC, the fraction has extra digits beyond dec
+	testcases := []struct {
+		data []byte
+		dec  uint16
+		nsec int
+		str  string
+	}{
+		{[]byte("\x58\x13\x6f\x22"), 0, 0, "2016-10-28 15:30:42"},
+		{[]byte("\x58\x13\x6f\x22\x37"), 1, 500000000, "2016-10-28 15:30:42.5"},
+		{[]byte("\x58\x13\x6f\x22\x37"), 2, 550000000, "2016-10-28 15:30:42.55"},
+		{[]byte("\x58\x13\x6f\x22\x1e\x61"), 3, 777000000, "2016-10-28 15:30:42.777"},
+		{[]byte("\x58\x13\x6f\x22\x1e\x61"), 4, 777700000, "2016-10-28 15:30:42.7777"},
+		{[]byte("\x58\x13\x6f\x22\x0b\xde\x31"), 5, 777770000, "2016-10-28 15:30:42.77777"},
+		{[]byte("\x58\x13\x6f\x22\x0b\xde\x31"), 6, 777777000, "2016-10-28 15:30:42.777777"},
+	}
+	for _, tc := range testcases {
+		e := &RowsEvent{parseTime: true}
+		v, n, err := e.decodeValue(tc.data, mysql.MYSQL_TYPE_TIMESTAMP2, tc.dec, false)
+		require.NoError(t, err)
+		require.Equal(t, len(tc.data), n)
+		require.Equal(t, tc.nsec, v.(time.Time).Nanosecond())
+
+		e = &RowsEvent{timestampStringLocation: time.UTC}
+		v, _, err = e.decodeValue(tc.data, mysql.MYSQL_TYPE_TIMESTAMP2, tc.dec, false)
+		require.NoError(t, err)
+		require.Equal(t, tc.str, v)
+	}
+
+	// TIMESTAMP(5) keeps trailing zeros of the fraction, .12300 is stored as 123000
+	e := &RowsEvent{timestampStringLocation: time.UTC}
+	v, _, err := e.decodeValue([]byte("\x58\x13\x6f\x22\x01\xe0\x78"), mysql.MYSQL_TYPE_TIMESTAMP2, 5, false)
+	require.NoError(t, err)
+	require.Equal(t, "2016-10-28 15:30:42.12300", v)
+
+	v, _, err = e.decodeValue([]byte("\x00\x00\x00\x00\x01\xe0\x78"), mysql.MYSQL_TYPE_TIMESTAMP2, 5, false)
+	require.NoError(t, err)
+	require.Equal(t, "0000-00-00 00:00:00.12300", v)
+
+	// the location may move the date to the next day
+	e = &RowsEvent{timestampStringLocation: time.FixedZone("UTC+9", 9*3600)}
+	v, _, err = e.decodeValue([]byte("\x58\x13\x6f\x22\x01\xe0\x78"), mysql.MYSQL_TYPE_TIMESTAMP2, 5, false)
+	require.NoError(t, err)
+	require.Equal(t, "2016-10-29 00:30:42.12300", v)
+}
+
+func TestRowsEventDeleteKeys(t *testing.T) {
+	table := &TableMapEvent{
+		Schema:      []byte("test"),
+		Table:       []byte("t"),
+		ColumnCount: 3,
+		ColumnName:  [][]byte{[]byte("id"), []byte("name"), []byte("age")},
+		PrimaryKey:  []uint64{0},
+	}
+	e := &RowsEvent{
+		eventType:      DELETE_ROWS_EVENTv2,
+		Table:          table,
+		Rows:           [][]interface{}{{int32(1), "a", nil}},
+		SkippedColumns: [][]int{{2}},
+	}
+
+	keys, err := e.DeleteKeys(0)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"id": int32(1)}, keys)
+
+	// without primary key, the columns in the row image are used
+	table.PrimaryKey = nil
+	keys, err = e.DeleteKeys(0)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"id": int32(1), "name": "a"}, keys)
+
+	_, err = e.DeleteKeys(1)
+	require.Error(t, err)
+
+	e.Table = &TableMapEvent{Schema: []byte("test"), Table: []byte("t"), ColumnCount: 3}
+	_, err = e.DeleteKeys(0)
+	require.ErrorContains(t, err, "column names of table test.t are not available")
+}
+
+func TestRowsEventNdbInfo(t *testing.T) {
+	// transaction id and conflict flags
+	e := &RowsEvent{}
+	err := e.decodeExtraData([]byte("\x00\x0e\x00\x03\x00\x01\x00\x00\x00\x00\x00\x00\x00\x02\x00"))
+	require.NoError(t, err)
+	require.Equal(t, NDB_ERIF_TRANSID|NDB_ERIF_CFT_FLAGS, e.NdbFlags)
+	require.Equal(t, uint64(1), e.NdbTransactionID)
+	require.Equal(t, uint16(2), e.NdbConflictFlags)
+
+	// unknown version keeps the raw data only
+	e = &RowsEvent{}
+	err = e.decodeExtraData([]byte("\x00\x04\x07\xab\xcd"))
+	require.NoError(t, err)
+	version, ok := e.NdbInfoVersion()
+	require.True(t, ok)
+	require.Equal(t, byte(7), version)
+	require.Equal(t, []byte("\xab\xcd"), e.NdbData)
+	require.Equal(t, uint16(0), e.NdbFlags)
+
+	// transaction id flag without transaction id
+	e = &RowsEvent{}
+	err = e.decodeExtraData([]byte("\x00\x04\x00\x01\x00"))
+	require.ErrorContains(t, err, "transaction id")
+}
+
+func TestDecodeFromReader(t *testing.T) {
+	tableMapEventData := []byte("\xd3\x01\x00\x00\x00\x00\x01\x00\x04test\x00\nfunnytable\x00\x01\x01\x00\x01")
+	rowsEventData := []byte("\xd3\x01\x00\x00\x00\x00\x01\x00\x02\x00\x01\xff\xfe\x01\xff\xfe\x02")
+
+	// the reader returns at most one byte per call
+	r := iotest.OneByteReader(bytes.NewReader(append(append([]byte{}, tableMapEventData...), rowsEventData...)))
+
+	tableMapEvent := &TableMapEvent{tableIDSize: 6}
+	err := tableMapEvent.DecodeFrom(r, len(tableMapEventData))
+	require.NoError(t, err)
+	require.Equal(t, "funnytable", string(tableMapEvent.Table))
+
+	rows := &RowsEvent{
+		tableIDSize: 6,
+		tables:      map[uint64]*TableMapEvent{tableMapEvent.TableID: tableMapEvent},
+		Version:     2,
+		eventType:   WRITE_ROWS_EVENTv2,
+	}
+	err = rows.DecodeFrom(r, len(rowsEventData))
+	require.NoError(t, err)
+	require.Equal(t, [][]interface{}{{int8(1)}, {nil}, {int8(2)}}, rows.Rows)
+	require.Equal(t, RowsEventDecodeStats{Rows: 3, Nulls: 1}, rows.DecodeStats())
+
+	// short read
+	err = rows.DecodeFrom(bytes.NewReader(rowsEventData[:5]), len(rowsEventData))
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestRowsEventApproxByteSize(t *testing.T) {
+	e := &RowsEvent{}
+	require.Equal(t, 0, e.ApproxByteSize())
+
+	e.Rows = [][]interface{}{
+		{nil, int32(1), "abc", []byte("de")},
+		{time.Now(), decimal.New(1, 0), &JsonDiff{Path: "$.a", Value: "1"}},
+	}
+	require.Equal(t, 24+16*4+0+8+3+2+24+16*3+24+24+8+3+1, e.ApproxByteSize())
+}
+
+func TestDecodePartialDate(t *testing.T) {
+	// by default, time.Date normalizes a zero day to the last day of the previous month
+	testcases := []struct {
+		data     []byte
+		tp       byte
+		meta     uint16
+		defValue interface{}
+		expected PartialDate
+		str      string
+	}{
+		{
+			[]byte("\x99\xb3\x80\xc8\xb8"), mysql.MYSQL_TYPE_DATETIME2, 0, "2024-05-31 12:34:56",
+			PartialDate{Year: 2024, Month: 6, Hour: 12, Minute: 34, Second: 56}, "2024-06-00 12:34:56",
+		},
+		{
+			[]byte("\x99\xb3\x80\xc8\xb8\x0c"), mysql.MYSQL_TYPE_DATETIME2, 2, "2024-05-31 12:34:56.12",
+			PartialDate{Year: 2024, Month: 6, Hour: 12, Minute: 34, Second: 56, Microsecond: 120000, Dec: 2}, "2024-06-00 12:34:56.12",
+		},
+		{
+			[]byte("\x80\x00\x00\x00\x00"), mysql.MYSQL_TYPE_DATETIME2, 0, "0000-00-00 00:00:00",
+			PartialDate{}, "0000-00-00 00:00:00",
+		},
+		{
+			[]byte("\x40\xc8\xc7\xa1\x68\x12\x00\x00"), mysql.MYSQL_TYPE_DATETIME, 0, "2024-05-31 12:34:56",
+			PartialDate{Year: 2024, Month: 6, Hour: 12, Minute: 34, Second: 56}, "2024-06-00 12:34:56",
+		},
+		{
+			[]byte("\xc0\xd0\x0f"), mysql.MYSQL_TYPE_DATE, 0, "2024-06-00",
+			PartialDate{Year: 2024, Month: 6, DateOnly: true}, "2024-06-00",
+		},
+	}
+	for _, tc := range testcases {
+		e := &RowsEvent{}
+		v, n, err := e.decodeValue(tc.data, tc.tp, tc.meta, false)
+		require.NoError(t, err)
+		require.Equal(t, len(tc.data), n)
+		require.Equal(t, tc.defValue, v)
+
+		e.usePartialDate = true
+		v, n, err = e.decodeValue(tc.data, tc.tp, tc.meta, false)
+		require.NoError(t, err)
+		require.Equal(t, len(tc.data), n)
+		require.Equal(t, tc.expected, v)
+		require.Equal(t, tc.str, v.(PartialDate).String())
+	}
+
+	// complete dates are not affected
+	e := &RowsEvent{usePartialDate: true}
+	v, _, err := e.decodeValue([]byte("\x99\x9a\xb8\xf7\xaa"), mysql.MYSQL_TYPE_DATETIME2, 0, false)
+	require.NoError(t, err)
+	require.Equal(t, "2016-10-28 15:30:42", v)
+}
+
+func TestRowsEventInferredRowImage(t *testing.T) {
+	// id INT PRIMARY KEY, name VARCHAR, data BLOB
+	table := &TableMapEvent{
+		ColumnCount: 3,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_BLOB},
+		ColumnMeta:  []uint16{0, 255, 2},
+	}
+	testcases := []struct {
+		needBitmap2 bool
+		bitmap1     byte
+		bitmap2     byte
+		expected    string
+	}{
+		{false, 0x07, 0, "full"},
+		{true, 0x07, 0x07, "full"},
+		{false, 0x03, 0, "partial"},
+		{true, 0x03, 0x03, "partial"},
+		{true, 0x01, 0x02, "minimal"},
+		{true, 0x07, 0x02, "minimal"},
+		{false, 0x05, 0, "minimal"},
+	}
+	for _, tc := range testcases {
+		e := &RowsEvent{
+			Table:         table,
+			ColumnCount:   3,
+			needBitmap2:   tc.needBitmap2,
+			ColumnBitmap1: []byte{tc.bitmap1},
+		}
+		if tc.needBitmap2 {
+			e.ColumnBitmap2 = []byte{tc.bitmap2}
+		}
+		require.Equal(t, tc.expected, e.InferredRowImage())
+	}
+
+	e := &RowsEvent{ColumnCount: 3, ColumnBitmap1: []byte{0x01}}
+	require.Equal(t, "partial", e.InferredRowImage())
+}
+
+func TestJsonLargeDocument(t *testing.T) {
+	// {"k": "xxx...", "n": 1} with a 70000 bytes string, so the large format with 4-byte offsets is used
+	str := bytes.Repeat([]byte("x"), 70000)
+	const headerSize = 8 + 2*6 + 2*5
+	size := headerSize + 2 + 3 + len(str)
+
+	data := []byte{JSONB_LARGE_OBJECT}
+	data = append(data, mysql.Uint32ToBytes(2)...)
+	data = append(data, mysql.Uint32ToBytes(uint32(size))...)
+	// key entries
+	data = append(data, mysql.Uint32ToBytes(headerSize)...)
+	data = append(data, mysql.Uint16ToBytes(1)...)
+	data = append(data, mysql.Uint32ToBytes(headerSize+1)...)
+	data = append(data, mysql.Uint16ToBytes(1)...)
+	// value entries, int32 is inlined in the large format
+	data = append(data, JSONB_STRING)
+	data = append(data, mysql.Uint32ToBytes(headerSize+2)...)
+	data = append(data, JSONB_INT32)
+	data = append(data, mysql.Uint32ToBytes(1)...)
+	// keys and values
+	data = append(data, 'k', 'n', 0xf0, 0xa2, 0x04)
+	data = append(data, str...)
+	require.Len(t, data, 1+size)
+
+	e := &RowsEvent{}
+	d, err := e.decodeJsonBinary(data)
+	require.NoError(t, err)
+	require.Equal(t, `{"k":"`+string(str)+`","n":1}`, string(d))
+
+	// a value offset beyond the object is reported instead of misread
+	bad := append([]byte{}, data...)
+	binary.LittleEndian.PutUint32(bad[1+8+12+1:], uint32(size))
+	_, err = e.decodeJsonBinary(bad)
+	require.ErrorContains(t, err, fmt.Sprintf("invalid value offset %d", size))
+
+	// a key offset beyond the object too
+	bad = append([]byte{}, data...)
+	binary.LittleEndian.PutUint32(bad[1+8:], uint32(size))
+	_, err = e.decodeJsonBinary(bad)
+	require.ErrorContains(t, err, fmt.Sprintf("invalid key offset %d", size))
+}
+
+func TestRowsEventFlags(t *testing.T) {
+	testcases := []struct {
+		flavor                  string
+		flags                   uint16
+		stmtEnd                 bool
+		noForeignKeyChecks      bool
+		relaxedUniqueChecks     bool
+		completeRows            bool
+		noCheckConstraintChecks bool
+		str                     string
+	}{
+		{mysql.MySQLFlavor, 0x01, true, false, false, false, false, "STMT_END_F"},
+		{mysql.MySQLFlavor, 0x0e, false, true, true, true, false, "NO_FOREIGN_KEY_CHECKS_F|RELAXED_UNIQUE_CHECKS_F|COMPLETE_ROWS_F"},
+		// bit 7 has no meaning in MySQL
+		{mysql.MySQLFlavor, 0x81, true, false, false, false, false, "STMT_END_F|NO_CHECK_CONSTRAINT_CHECKS_F"},
+		{mysql.MariaDBFlavor, 0x01, true, false, false, false, false, "STMT_END_F"},
+		{mysql.MariaDBFlavor, 0x0e, false, true, true, true, false, "NO_FOREIGN_KEY_CHECKS_F|RELAXED_UNIQUE_CHECKS_F|COMPLETE_ROWS_F"},
+		{mysql.MariaDBFlavor, 0x81, true, false, false, false, true, "STMT_END_F|NO_CHECK_CONSTRAINT_CHECKS_F"},
+	}
+	for _, tc := range testcases {
+		e := &RowsEvent{Flags: tc.flags, Table: &TableMapEvent{flavor: tc.flavor}}
+		require.Equal(t, tc.stmtEnd, e.IsStatementEnd())
+		require.Equal(t, tc.noForeignKeyChecks, e.NoForeignKeyChecks())
+		require.Equal(t, tc.relaxedUniqueChecks, e.RelaxedUniqueChecks())
+		require.Equal(t, tc.completeRows, e.CompleteRows())
+		require.Equal(t, tc.noCheckConstraintChecks, e.NoCheckConstraintChecks())
+		require.Equal(t, tc.str, e.FlagSet().String())
+		require.Equal(t, tc.flags&0x80 != 0, e.FlagSet().NoCheckConstraintChecks())
+	}
+
+	require.Equal(t, "0", RowsEventFlags(0).String())
+	require.Equal(t, "STMT_END_F|0x110", RowsEventFlags(0x111).String())
+
+	var buf bytes.Buffer
+	e := &RowsEvent{Flags: 0x03}
+	e.Dump(&buf)
+	require.Contains(t, buf.String(), "Flags: 3 (STMT_END_F|NO_FOREIGN_KEY_CHECKS_F)\n")
+}
+
+func TestRowsEventDecodeRowAt(t *testing.T) {
+	tableMapEvent := &TableMapEvent{
+		tableIDSize: 6,
+		TableID:     0x1d3,
+		ColumnCount: 1,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_TINY},
+		ColumnMeta:  []uint16{0},
+	}
+	rows := &RowsEvent{
+		tableIDSize: 6,
+		tables:      map[uint64]*TableMapEvent{tableMapEvent.TableID: tableMapEvent},
+		Version:     2,
+		eventType:   UPDATE_ROWS_EVENTv2,
+		needBitmap2: true,
+	}
+
+	// UPDATE t SET c = c + 1, for c = 1 and c = 3
+	data := []byte("\xd3\x01\x00\x00\x00\x00\x01\x00\x02\x00\x01\xff\xff\xfe\x01\xfe\x02\xfe\x03\xfe\x04")
+	err := rows.Decode(data)
+	require.NoError(t, err)
+	require.Equal(t, []int{13, 15, 17, 19}, rows.RowOffsets)
+	stats := rows.DecodeStats()
+
+	for i, offset := range rows.RowOffsets {
+		row, err := rows.DecodeRowAt(offset)
+		require.NoError(t, err)
+		require.Equal(t, rows.Rows[i], row)
+	}
+	require.Len(t, rows.Rows, 4)
+	require.Equal(t, stats, rows.DecodeStats())
+
+	// the event is not changed, so it can be read concurrently, see go test -race
+	var wg sync.WaitGroup
+	errs := make([]error, len(rows.RowOffsets))
+	for i, offset := range rows.RowOffsets {
+		wg.Add(1)
+		go func(i, offset int) {
+			defer wg.Done()
+			_, errs[i] = rows.DecodeRowAt(offset)
+		}(i, offset)
+	}
+	for i := range rows.Rows {
+		require.Len(t, rows.Rows[i], 1)
+	}
+	require.Equal(t, stats, rows.DecodeStats())
+	wg.Wait()
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	_, err = rows.DecodeRowAt(14)
+	require.ErrorContains(t, err, "offset 14 is not the start of a decoded row image")
+
+	err = rows.DecodeHeaderOnly(data)
+	require.NoError(t, err)
+	_, err = rows.DecodeRowAt(13)
+	require.Error(t, err)
+}
+
+func TestTableMapEventSharedCaches(t *testing.T) {
+	// CREATE TABLE d.t (c VARCHAR(10), e ENUM('a')) DEFAULT CHARSET latin1 with
+	// binlog_row_metadata = MINIMAL, the enum values are known from the schema
+	data := []byte("\x01\x00\x00\x00\x00\x00\x00\x00\x01d\x00\x01t\x00\x02\x0f\xfe\x04\x0a\x00\xf7\x01\x00\x02\x01\x08")
+	table := &TableMapEvent{
+		tableIDSize: 6,
+		enumSetLabelsFunc: func(schema, table string, column int) []string {
+			return []string{"a"}
+		},
+	}
+	require.NoError(t, table.Decode(data))
+	require.Equal(t, map[int]uint64{0: 8}, table.collations)
+	require.Equal(t, map[int][]string{1: {"a"}}, table.enumLabels)
+
+	// rows events of parsers sharing the table map event, see go test -race
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	rows := make([]*RowsEvent, len(errs))
+	for w := range errs {
+		rows[w] = &RowsEvent{Table: table, ColumnCount: 2, transcodeToUTF8: true, enumSetWithLabels: true}
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			_, errs[w] = rows[w].decodeImage([]byte{0x00, 0x01, 0xe9, 0x01}, []byte{0x03}, EnumRowImageTypeWriteAI)
+		}(w)
+	}
+	wg.Wait()
+	for w, err := range errs {
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{"é", EnumValue{Index: 1, Label: "a"}}, rows[w].Rows[0])
+	}
+}
+
+func TestTranscodeToUTF8(t *testing.T) {
+	table := TableMapEvent{
+		ColumnType: []byte{mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_VARCHAR},
+		ColumnMeta: []uint16{255, 255, 255, 255},
+		// latin1_swedish_ci, gbk_chinese_ci, no collation, binary
+		collations: map[int]uint64{0: 8, 1: 28, 3: 63},
+	}
+	data := []byte("\x00\x01\xe9\x02\xc4\xe3\x01\xff\x01\xff")
+
+	e := RowsEvent{
+		Table:       &table,
+		ColumnCount: uint64(len(table.ColumnType)),
+	}
+	n, err := e.decodeImage(data, []byte{0x0f}, EnumRowImageTypeWriteAI)
+	require.NoError(t, err)
+	require.Len(t, data, n)
+	require.Equal(t, []interface{}{"\xe9", "\xc4\xe3", "\xff", "\xff"}, e.Rows[0])
+
+	e = RowsEvent{
+		Table:           &table,
+		ColumnCount:     uint64(len(table.ColumnType)),
+		transcodeToUTF8: true,
+	}
+	_, err = e.decodeImage(data, []byte{0x0f}, EnumRowImageTypeWriteAI)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{"é", "你", "�", "\xff"}, e.Rows[0])
+
+	// a known latin1 server, the collations take precedence
+	e = RowsEvent{
+		Table:           &table,
+		ColumnCount:     uint64(len(table.ColumnType)),
+		transcodeToUTF8: true,
+		defaultCharset:  "latin1",
+	}
+	_, err = e.decodeImage(data, []byte{0x0f}, EnumRowImageTypeWriteAI)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{"é", "你", "ÿ", "\xff"}, e.Rows[0])
+
+	// no collation is logged
+	noCollations := TableMapEvent{ColumnType: table.ColumnType, ColumnMeta: table.ColumnMeta}
+	for _, tc := range []struct {
+		charset  string
+		expected []interface{}
+	}{
+		{"latin1", []interface{}{"é", "Ä\u00e3", "ÿ", "ÿ"}},
+		{"gbk", []interface{}{"�", "你", "�", "�"}},
+		{"binary", []interface{}{"\xe9", "\xc4\xe3", "\xff", "\xff"}},
+		{"unknown", []interface{}{"�", "�", "�", "�"}},
+	} {
+		e = RowsEvent{
+			Table:           &noCollations,
+			ColumnCount:     uint64(len(table.ColumnType)),
+			transcodeToUTF8: true,
+			defaultCharset:  tc.charset,
+		}
+		_, err = e.decodeImage(data, []byte{0x0f}, EnumRowImageTypeWriteAI)
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, e.Rows[0], tc.charset)
+	}
+
+	p := NewBinlogParser()
+	p.SetDefaultCharset("LATIN1")
+	require.Equal(t, "latin1", p.defaultCharset)
+}
+
+func TestRowsEventVisibleRow(t *testing.T) {
+	// CREATE TABLE t (a INT, b INT INVISIBLE, c INT, d INT INVISIBLE)
+	table := &TableMapEvent{
+		ColumnCount:      4,
+		VisibilityBitmap: []byte{0xa0},
+	}
+	e := &RowsEvent{
+		Table: table,
+		Rows:  [][]interface{}{{int32(1), int32(2), int32(3), int32(4)}},
+	}
+
+	row, err := e.VisibleRow(0)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{int32(1), int32(3)}, row)
+
+	// visibility is not logged
+	table.VisibilityBitmap = nil
+	row, err = e.VisibleRow(0)
+	require.NoError(t, err)
+	require.Equal(t, e.Rows[0], row)
+
+	_, err = e.VisibleRow(1)
+	require.Error(t, err)
+
+	e.Table = nil
+	_, err = e.VisibleRow(0)
+	require.Error(t, err)
+}
+
+func TestValuesEqual(t *testing.T) {
+	ts := time.Date(2024, 6, 1, 12, 30, 45, 120000000, time.UTC)
+
+	testcases := []struct {
+		a, b  interface{}
+		tp    byte
+		equal bool
+	}{
+		{nil, nil, mysql.MYSQL_TYPE_LONG, true},
+		{nil, int32(0), mysql.MYSQL_TYPE_LONG, false},
+		{int32(1), int64(1), mysql.MYSQL_TYPE_LONG, true},
+		{uint64(math.MaxUint64), uint64(math.MaxUint64), mysql.MYSQL_TYPE_LONGLONG, true},
+		{int32(1), int32(2), mysql.MYSQL_TYPE_LONG, false},
+
+		{decimal.RequireFromString("1.50"), "1.5", mysql.MYSQL_TYPE_NEWDECIMAL, true},
+		{"1.50", "1.500", mysql.MYSQL_TYPE_NEWDECIMAL, true},
+		{decimal.RequireFromString("-0.00"), decimal.Zero, mysql.MYSQL_TYPE_NEWDECIMAL, true},
+		{"1.5", "1.51", mysql.MYSQL_TYPE_NEWDECIMAL, false},
+		{float64(1.5), float32(1.5), mysql.MYSQL_TYPE_DOUBLE, true},
+
+		{fracTime{Time: ts, Dec: 2}, fracTime{Time: ts, Dec: 6}, mysql.MYSQL_TYPE_DATETIME2, true},
+		{fracTime{Time: ts, Dec: 2}, "2024-06-01 12:30:45.12", mysql.MYSQL_TYPE_DATETIME2, true},
+		{"2024-06-01 12:30:45.120", "2024-06-01 12:30:45.12", mysql.MYSQL_TYPE_DATETIME2, true},
+		{ts.In(time.FixedZone("UTC+8", 8*3600)), ts, mysql.MYSQL_TYPE_TIMESTAMP2, true},
+		{ts, ts.Add(time.Microsecond), mysql.MYSQL_TYPE_TIMESTAMP2, false},
+		{"2024-06-01", fracTime{Time: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)}, mysql.MYSQL_TYPE_DATE, true},
+		{"0000-00-00 00:00:00", "0000-00-00 00:00:00", mysql.MYSQL_TYPE_DATETIME2, true},
+		{"0000-00-00 00:00:00", ts, mysql.MYSQL_TYPE_DATETIME2, false},
+
+		{[]byte("abc"), "abc", mysql.MYSQL_TYPE_BLOB, true},
+		{[]byte("abc"), []byte("abc"), mysql.MYSQL_TYPE_BLOB, true},
+		{[]byte("abc"), []byte("ABC"), mysql.MYSQL_TYPE_BLOB, false},
+		{[]byte("\x00"), []byte{}, mysql.MYSQL_TYPE_BLOB, false},
+		{"abc", int64(1), mysql.MYSQL_TYPE_VARCHAR, false},
+	}
+
+	for i, tc := range testcases {
+		require.Equal(t, tc.equal, ValuesEqual(tc.a, tc.b, tc.tp), "case %d", i)
+		require.Equal(t, tc.equal, ValuesEqual(tc.b, tc.a, tc.tp), "case %d", i)
+	}
+}
+
+func TestEnumSetWithLabels(t *testing.T) {
+	// CREATE TABLE t (e ENUM('a', 'b', 'c'), s SET('x', 'y', 'z'))
+	newTable := func() *TableMapEvent {
+		return &TableMapEvent{
+			ColumnCount:  2,
+			ColumnType:   []byte{mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_STRING},
+			ColumnMeta:   []uint16{uint16(mysql.MYSQL_TYPE_ENUM)<<8 | 1, uint16(mysql.MYSQL_TYPE_SET)<<8 | 1},
+			EnumStrValue: [][][]byte{{[]byte("a"), []byte("b"), []byte("c")}},
+			SetStrValue:  [][][]byte{{[]byte("x"), []byte("y"), []byte("z")}},
+		}
+	}
+	// e = 'b', s = 'x,z'; e = '', s = ''
+	data := []byte("\x00\x02\x05\x00\x00\x00")
+
+	e := RowsEvent{Table: newTable(), ColumnCount: 2}
+	_, err := e.decodeImage(data, []byte{0x03}, EnumRowImageTypeWriteAI)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{int64(2), int64(5)}, e.Rows[0])
+
+	e = RowsEvent{Table: newTable(), ColumnCount: 2, enumSetWithLabels: true}
+	n, err := e.decodeImage(data, []byte{0x03}, EnumRowImageTypeWriteAI)
+	require.NoError(t, err)
+	_, err = e.decodeImage(data[n:], []byte{0x03}, EnumRowImageTypeWriteAI)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{
+		EnumValue{Index: 2, Label: "b"},
+		SetValue{Mask: 5, Labels: []string{"x", "z"}},
+	}, e.Rows[0])
+	require.Equal(t, []interface{}{
+		EnumValue{Index: 0},
+		SetValue{Mask: 0, Labels: []string{}},
+	}, e.Rows[1])
+
+	values, err := e.DriverValues(0)
+	require.NoError(t, err)
+	require.Equal(t, []driver.Value{int64(2), int64(5)}, values)
+
+	// values are not logged
+	table := newTable()
+	table.EnumStrValue = nil
+	table.SetStrValue = nil
+	e = RowsEvent{Table: table, ColumnCount: 2, enumSetWithLabels: true}
+	_, err = e.decodeImage(data, []byte{0x03}, EnumRowImageTypeWriteAI)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{EnumValue{Index: 2}, SetValue{Mask: 5}}, e.Rows[0])
+}
+
+func TestEnumSetLabelsFunc(t *testing.T) {
+	// CREATE TABLE db.t (e ENUM('a', 'b', 'c'), s SET('x', 'y', 'z')), only the enum values are logged
+	table := &TableMapEvent{
+		Schema:       []byte("db"),
+		Table:        []byte("t"),
+		ColumnCount:  2,
+		ColumnType:   []byte{mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_STRING},
+		ColumnMeta:   []uint16{uint16(mysql.MYSQL_TYPE_ENUM)<<8 | 1, uint16(mysql.MYSQL_TYPE_SET)<<8 | 1},
+		EnumStrValue: [][][]byte{{[]byte("a"), []byte("b"), []byte("c")}},
+	}
+	var calls []string
+	table.SetEnumSetLabelsFunc(func(schema, table string, column int) []string {
+		calls = append(calls, fmt.Sprintf("%s.%s.%d", schema, table, column))
+		if column == 0 {
+			return []string{"stale", "values", "here"}
+		}
+		return []string{"x", "y", "z"}
+	})
+	// e = 'b', s = 'x,z'
+	data := []byte("\x00\x02\x05")
+
+	e := RowsEvent{Table: table, ColumnCount: 2, enumSetWithLabels: true}
+	_, err := e.decodeImage(data, []byte{0x03}, EnumRowImageTypeWriteAI)
+	require.NoError(t, err)
+	// the logged values take precedence
+	require.Equal(t, []interface{}{
+		EnumValue{Index: 2, Label: "b"},
+		SetValue{Mask: 5, Labels: []string{"x", "z"}},
+	}, e.Rows[0])
+	require.Equal(t, []string{"db.t.1"}, calls)
+	require.Nil(t, table.SetStrValueMap())
+
+	// the func is called once per column
+	_, err = e.decodeImage(data, []byte{0x03}, EnumRowImageTypeWriteAI)
+	require.NoError(t, err)
+	require.Equal(t, []string{"db.t.1"}, calls)
+
+	// no values from either
+	table = &TableMapEvent{
+		ColumnCount: 2,
+		ColumnType:  table.ColumnType,
+		ColumnMeta:  table.ColumnMeta,
+	}
+	table.SetEnumSetLabelsFunc(func(schema, table string, column int) []string { return nil })
+	e = RowsEvent{Table: table, ColumnCount: 2, enumSetWithLabels: true}
+	_, err = e.decodeImage(data, []byte{0x03}, EnumRowImageTypeWriteAI)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{EnumValue{Index: 2}, SetValue{Mask: 5}}, e.Rows[0])
+}
+
+func TestEnumSetWithTrimmedLabels(t *testing.T) {
+	// CREATE TABLE t (e ENUM('a  ', 'caf\xe9 '), s SET('x ', 'y')) CHARSET latin1, the labels are padded
+	table := &TableMapEvent{
+		ColumnCount:           2,
+		ColumnType:            []byte{mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_STRING},
+		ColumnMeta:            []uint16{uint16(mysql.MYSQL_TYPE_ENUM)<<8 | 1, uint16(mysql.MYSQL_TYPE_SET)<<8 | 1},
+		EnumStrValue:          [][][]byte{{[]byte("a  "), []byte("caf\xe9 ")}},
+		SetStrValue:           [][][]byte{{[]byte("x "), []byte("y")}},
+		EnumSetDefaultCharset: []uint64{8}, // latin1_swedish_ci
+	}
+	// e = 'caf\xe9 ', s = 'x ,y'
+	data := []byte("\x00\x02\x03")
+
+	e := RowsEvent{Table: table, ColumnCount: 2, enumSetWithLabels: true}
+	_, err := e.decodeImage(data, []byte{0x03}, EnumRowImageTypeWriteAI)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{
+		EnumValue{Index: 2, Label: "caf\xe9 "},
+		SetValue{Mask: 3, Labels: []string{"x ", "y"}},
+	}, e.Rows[0])
+
+	e = RowsEvent{Table: table, ColumnCount: 2, enumSetWithLabels: true, trimEnumSetLabels: true}
+	_, err = e.decodeImage(data, []byte{0x03}, EnumRowImageTypeWriteAI)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{
+		EnumValue{Index: 2, Label: "caf\xe9"},
+		SetValue{Mask: 3, Labels: []string{"x", "y"}},
+	}, e.Rows[0])
+
+	e = RowsEvent{Table: table, ColumnCount: 2, enumSetWithLabels: true, trimEnumSetLabels: true, transcodeToUTF8: true}
+	_, err = e.decodeImage(data, []byte{0x03}, EnumRowImageTypeWriteAI)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{
+		EnumValue{Index: 2, Label: "café"},
+		SetValue{Mask: 3, Labels: []string{"x", "y"}},
+	}, e.Rows[0])
+
+	// the logged labels are not changed
+	require.Equal(t, [][][]byte{{[]byte("a  "), []byte("caf\xe9 ")}}, table.EnumStrValue)
+	require.Equal(t, []string{"x ", "y"}, table.SetStrValueMap()[1])
+}
+
+func TestNewEnumValue(t *testing.T) {
+	labels := []string{"a", "b", "c"}
+	require.Equal(t, EnumValue{Index: 0, Label: ""}, newEnumValue(0, labels))
+	require.Equal(t, EnumValue{Index: 1, Label: "a"}, newEnumValue(1, labels))
+	require.Equal(t, EnumValue{Index: 3, Label: "c"}, newEnumValue(3, labels))
+	require.Equal(t, EnumValue{Index: 4, Label: ""}, newEnumValue(4, labels))
+	require.Equal(t, EnumValue{Index: -1, Label: ""}, newEnumValue(-1, labels))
+	require.Equal(t, EnumValue{Index: 1, Label: ""}, newEnumValue(1, nil))
+
+	// the index decoded from the row image
+	table := &TableMapEvent{
+		ColumnCount:  1,
+		ColumnType:   []byte{mysql.MYSQL_TYPE_STRING},
+		ColumnMeta:   []uint16{uint16(mysql.MYSQL_TYPE_ENUM)<<8 | 1},
+		EnumStrValue: [][][]byte{{[]byte("a"), []byte("b"), []byte("c")}},
+	}
+	e := RowsEvent{Table: table, ColumnCount: 1, enumSetWithLabels: true}
+	_, err := e.decodeImage([]byte("\x00\x01"), []byte{0x01}, EnumRowImageTypeWriteAI)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{EnumValue{Index: 1, Label: "a"}}, e.Rows[0])
+}
+
+func TestBlobDecodeFunc(t *testing.T) {
+	// CREATE TABLE t (id INT, b BLOB, c BLOB)
+	table := &TableMapEvent{
+		ColumnCount: 3,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_BLOB, mysql.MYSQL_TYPE_BLOB},
+		ColumnMeta:  []uint16{0, 2, 2},
+	}
+	// 1, 'hello', NULL
+	data := []byte("\x04\x01\x00\x00\x00\x05\x00hello")
+
+	var cols []int
+	e := RowsEvent{Table: table, ColumnCount: 3}
+	e.blobDecodeFunc = func(col int, r io.Reader) (interface{}, error) {
+		cols = append(cols, col)
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return fmt.Sprintf("ref-%d-%d", col, len(b)), nil
+	}
+	n, err := e.decodeImage(data, []byte{0x07}, EnumRowImageTypeWriteAI)
+	require.NoError(t, err)
+	require.Len(t, data, n)
+	require.Equal(t, []interface{}{int32(1), "ref-1-5", nil}, e.Rows[0])
+	require.Equal(t, []int{1}, cols)
+
+	e = RowsEvent{Table: table, ColumnCount: 3}
+	_, err = e.decodeImage(data, []byte{0x07}, EnumRowImageTypeWriteAI)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{int32(1), []byte("hello"), nil}, e.Rows[0])
+
+	e.blobDecodeFunc = func(col int, r io.Reader) (interface{}, error) {
+		return nil, fmt.Errorf("storage unavailable")
+	}
+	_, err = e.decodeImage(data, []byte{0x07}, EnumRowImageTypeWriteAI)
+	require.ErrorContains(t, err, "storage unavailable")
+
+	// a truncated value fails before the function is called
+	cols = nil
+	e.blobDecodeFunc = func(col int, r io.Reader) (interface{}, error) {
+		cols = append(cols, col)
+		return nil, nil
+	}
+	_, err = e.decodeImage(data[:len(data)-1], []byte{0x07}, EnumRowImageTypeWriteAI)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	require.Empty(t, cols)
+}
+
+func TestRowsEventV0(t *testing.T) {
+	parser := NewBinlogParser()
+	parser.format = &FormatDescriptionEvent{EventTypeHeaderLengths: make([]byte, 256)}
+	for _, tp := range []EventType{WRITE_ROWS_EVENTv0, UPDATE_ROWS_EVENTv0, DELETE_ROWS_EVENTv0} {
+		parser.format.EventTypeHeaderLengths[tp-1] = 8
+	}
+
+	// CREATE TABLE t (id INT, name VARCHAR(10))
+	table := &TableMapEvent{
+		TableID:     42,
+		ColumnCount: 2,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR},
+		ColumnMeta:  []uint16{0, 10},
+	}
+	parse := func(tp EventType, data string) *RowsEvent {
+		parser.tables[42] = table
+		e, err := parser.parseEvent(&EventHeader{EventType: tp}, []byte(data), nil)
+		require.NoError(t, err)
+		return e.(*RowsEvent)
+	}
+
+	// the v0 events have the header of the v1 events, table id, flags, column count
+	// and the column bitmap, then the row images
+	header := "\x2a\x00\x00\x00\x00\x00\x01\x00\x02\x03"
+
+	e := parse(WRITE_ROWS_EVENTv0, header+"\x00\x01\x00\x00\x00\x01a")
+	require.Equal(t, 0, e.Version)
+	require.Equal(t, uint64(42), e.TableID)
+	require.True(t, e.IsStatementEnd())
+	require.Equal(t, [][]interface{}{{int32(1), "a"}}, e.Rows)
+
+	e = parse(DELETE_ROWS_EVENTv0, header+"\x00\x01\x00\x00\x00\x01a")
+	require.Equal(t, [][]interface{}{{int32(1), "a"}}, e.Rows)
+
+	// UPDATE t SET id = 2, name = 'bc', there is a single bitmap for both images
+	e = parse(UPDATE_ROWS_EVENTv0, header+"\x00\x01\x00\x00\x00\x01a\x00\x02\x00\x00\x00\x02bc")
+	require.Equal(t, []byte{0x03}, e.ColumnBitmap1)
+	require.Equal(t, []byte{0x03}, e.ColumnBitmap2)
+	require.Equal(t, [][]interface{}{{int32(1), "a"}, {int32(2), "bc"}}, e.Rows)
+
+	// a minimal image with only the id column
+	e = parse(UPDATE_ROWS_EVENTv0, "\x2a\x00\x00\x00\x00\x00\x01\x00\x02\x01"+"\x00\x01\x00\x00\x00\x00\x02\x00\x00\x00")
+	require.Equal(t, [][]interface{}{{int32(1), nil}, {int32(2), nil}}, e.Rows)
+	require.Equal(t, [][]int{{1}, {1}}, e.SkippedColumns)
+
+	// the encoding is the inverse
+	data, err := e.Encode()
+	require.NoError(t, err)
+	require.Equal(t, "\x2a\x00\x00\x00\x00\x00\x01\x00\x02\x01"+"\x00\x01\x00\x00\x00\x00\x02\x00\x00\x00", string(data))
+}
+
+func TestUseTimeWithDec(t *testing.T) {
+	// CREATE TABLE t (dt DATETIME(3), ts TIMESTAMP(2), d0 DATETIME)
+	table := &TableMapEvent{
+		TableID:     42,
+		ColumnCount: 3,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_DATETIME2, mysql.MYSQL_TYPE_TIMESTAMP2, mysql.MYSQL_TYPE_DATETIME2},
+		ColumnMeta:  []uint16{3, 2, 0},
+	}
+	dt := time.Date(2024, 6, 1, 12, 0, 0, 120000000, time.UTC)
+	ts := time.Date(2024, 6, 1, 12, 0, 0, 500000000, time.UTC)
+	d0 := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	e, err := NewRowsEventBuilder(table, WRITE_ROWS_EVENTv2).AddRow(dt, ts, d0).Build()
+	require.NoError(t, err)
+	data, err := e.Encode()
+	require.NoError(t, err)
+
+	rows := &RowsEvent{
+		tableIDSize:    6,
+		tables:         map[uint64]*TableMapEvent{42: table},
+		Version:        2,
+		eventType:      WRITE_ROWS_EVENTv2,
+		parseTime:      true,
+		useTimeWithDec: true,
+	}
+	require.NoError(t, rows.Decode(data))
+	row := rows.Rows[0]
+	require.Equal(t, TimeWithDec{Time: dt, Dec: 3}, row[0])
+	require.Equal(t, 2, row[1].(TimeWithDec).Dec)
+	require.True(t, ts.Equal(row[1].(TimeWithDec).Time))
+	require.Equal(t, TimeWithDec{Time: d0, Dec: 0}, row[2])
+
+	// the trailing zeros of the precision are kept
+	require.Equal(t, "2024-06-01 12:00:00.120", row[0].(TimeWithDec).String())
+	require.Equal(t, "2024-06-01 12:00:00", row[2].(TimeWithDec).String())
+
+	values, err := rows.DriverValues(0)
+	require.NoError(t, err)
+	require.Equal(t, dt, values[0])
+	require.True(t, ValuesEqual(row[0], dt, mysql.MYSQL_TYPE_DATETIME2))
+
+	// without the option
+	rows.useTimeWithDec = false
+	require.NoError(t, rows.Decode(data))
+	require.Equal(t, dt, rows.Rows[0][0])
+
+	require.Equal(t, reflect.TypeOf(TimeWithDec{}), table.GoType(0, DecodeOptions{ParseTime: true, UseTimeWithDec: true}))
+	require.Equal(t, reflect.TypeOf(""), table.GoType(0, DecodeOptions{UseTimeWithDec: true}))
+}
+
+func TestRowImageMismatch(t *testing.T) {
+	// CREATE TABLE t (id INT, name VARCHAR(10))
+	table := &TableMapEvent{
+		TableID:     42,
+		ColumnCount: 2,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR},
+		ColumnMeta:  []uint16{0, 10},
+	}
+	e, err := NewRowsEventBuilder(table, WRITE_ROWS_EVENTv2).
+		AddRow(int32(1), "a").AddRow(int32(2), "bc").
+		Build()
+	require.NoError(t, err)
+	data, err := e.Encode()
+	require.NoError(t, err)
+
+	decode := func(table *TableMapEvent) (*RowsEvent, error) {
+		rows := &RowsEvent{
+			tableIDSize: 6,
+			tables:      map[uint64]*TableMapEvent{42: table},
+			Version:     2,
+			eventType:   WRITE_ROWS_EVENTv2,
+		}
+		return rows, rows.Decode(data)
+	}
+
+	rows, err := decode(table)
+	require.NoError(t, err)
+	require.Equal(t, [][]interface{}{{int32(1), "a"}, {int32(2), "bc"}}, rows.Rows)
+
+	// ALTER TABLE t MODIFY id BIGINT, without a new table map event
+	rows, err = decode(&TableMapEvent{
+		TableID:     42,
+		ColumnCount: 2,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONGLONG, mysql.MYSQL_TYPE_VARCHAR},
+		ColumnMeta:  []uint16{0, 10},
+	})
+	require.ErrorIs(t, err, ErrRowImageMismatch)
+	// found after decoding the first row, with garbage
+	require.Len(t, rows.Rows, 1)
+
+	// ALTER TABLE t ADD c INT, the table map event has fewer columns than the rows event
+	_, err = decode(&TableMapEvent{
+		TableID:     42,
+		ColumnCount: 1,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG},
+		ColumnMeta:  []uint16{0},
+	})
+	require.ErrorIs(t, err, ErrRowImageMismatch)
+
+	// the before image of an update without its after image
+	rows = &RowsEvent{Table: table, ColumnCount: 2, ColumnBitmap1: []byte{0x03}, ColumnBitmap2: []byte{0x03}, needBitmap2: true}
+	err = rows.DecodeData(0, []byte("\x00\x01\x00\x00\x00\x01a"))
+	require.ErrorIs(t, err, ErrRowImageMismatch)
+
+	// a truncated trailing image doesn't prevent MaxRows from keeping the rows before
+	truncated := append(append([]byte(nil), data...), 0x00, 0x03, 0x00)
+	rows = &RowsEvent{
+		tableIDSize: 6,
+		tables:      map[uint64]*TableMapEvent{42: table},
+		Version:     2,
+		eventType:   WRITE_ROWS_EVENTv2,
+		maxRows:     1,
+	}
+	err = rows.Decode(truncated)
+	require.ErrorIs(t, err, ErrTooManyRows)
+	require.Equal(t, [][]interface{}{{int32(1), "a"}}, rows.Rows)
+
+	rows.maxRows = 0
+	err = rows.Decode(truncated)
+	require.ErrorIs(t, err, ErrRowImageMismatch)
+	require.Equal(t, [][]interface{}{{int32(1), "a"}, {int32(2), "bc"}}, rows.Rows)
+
+	// the same with DecodeDataFunc
+	pos, err := rows.DecodeHeader(truncated)
+	require.NoError(t, err)
+	images := 0
+	err = rows.DecodeDataFunc(pos, truncated, func(row []interface{}, skippedColumns []int) error {
+		images++
+		return nil
+	})
+	require.ErrorIs(t, err, ErrRowImageMismatch)
+	require.Equal(t, 2, images)
+}
+
+func TestContinueOnRowError(t *testing.T) {
+	// CREATE TABLE t (id INT, b BLOB)
+	table := &TableMapEvent{
+		TableID:     42,
+		ColumnCount: 2,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_BLOB},
+		ColumnMeta:  []uint16{0, 2},
+	}
+	e, err := NewRowsEventBuilder(table, UPDATE_ROWS_EVENTv2).
+		AddRow(int32(1), []byte("a")).AddRow(int32(1), []byte("bad")).
+		AddRow(int32(2), []byte("bad")).AddRow(int32(2), []byte("b")).
+		AddRow(int32(3), []byte("c")).AddRow(int32(3), nil).
+		Build()
+	require.NoError(t, err)
+	data, err := e.Encode()
+	require.NoError(t, err)
+
+	rows := &RowsEvent{
+		tableIDSize: 6,
+		tables:      map[uint64]*TableMapEvent{42: table},
+		Version:     2,
+		eventType:   UPDATE_ROWS_EVENTv2,
+		needBitmap2: true,
+	}
+	rows.blobDecodeFunc = func(col int, r io.Reader) (interface{}, error) {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		if string(b) == "bad" {
+			return nil, fmt.Errorf("bad blob")
+		}
+		return b, nil
+	}
+
+	// fail fast by default
+	err = rows.Decode(data)
+	require.ErrorContains(t, err, "bad blob")
+	require.Nil(t, rows.RowErrors)
+
+	rows.continueOnRowError = true
+	require.NoError(t, rows.Decode(data))
+	require.Equal(t, [][]interface{}{
+		{int32(1), []byte("a")}, nil,
+		nil, {int32(2), []byte("b")},
+		{int32(3), []byte("c")}, {int32(3), nil},
+	}, rows.Rows)
+	require.Len(t, rows.SkippedColumns, 6)
+	require.Nil(t, rows.SkippedColumns[1])
+	require.Len(t, rows.RowOffsets, 6)
+	require.Len(t, rows.RowErrors, 6)
+	for k, err := range rows.RowErrors {
+		if k == 1 || k == 2 {
+			require.ErrorContains(t, err, "bad blob")
+			require.ErrorContains(t, err, fmt.Sprintf("row image %d", k))
+		} else {
+			require.NoError(t, err)
+		}
+	}
+
+	// the length of an image with an unknown type can't be found
+	rows.blobDecodeFunc = nil
+	table.ColumnType[1] = 0xf0
+	err = rows.Decode(data)
+	require.Error(t, err)
+}
+
+func TestDecodeGeometryFlavor(t *testing.T) {
+	// CREATE TABLE t (g GEOMETRY), INSERT INTO t VALUES (ST_GeomFromText('POINT(1 1)'))
+	wkb := []byte("\x00\x00\x00\x00\x01\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\xf0\x3f\x00\x00\x00\x00\x00\x00\xf0\x3f")
+	data := append([]byte{0x00, byte(len(wkb)), 0x00, 0x00, 0x00}, wkb...)
+
+	for _, flavor := range []string{"mysql", "mariadb"} {
+		table := &TableMapEvent{
+			flavor:      flavor,
+			ColumnCount: 1,
+			ColumnType:  []byte{mysql.MYSQL_TYPE_GEOMETRY},
+			ColumnMeta:  []uint16{4},
+			// binary
+			collations: map[int]uint64{0: 63},
+		}
+		require.Equal(t, flavor == "mariadb", table.IsCharacterColumn(0))
+
+		e := RowsEvent{Table: table, ColumnCount: 1, transcodeToUTF8: true, binaryAsBytes: true}
+		n, err := e.decodeImage(data, []byte{0x01}, EnumRowImageTypeWriteAI)
+		require.NoError(t, err)
+		require.Len(t, data, n)
+		require.Equal(t, []interface{}{wkb}, e.Rows[0], flavor)
+
+		literals, err := e.ValueLiterals(0)
+		require.NoError(t, err)
+		require.Equal(t, "X'"+hex.EncodeToString(wkb)+"'", literals[0], flavor)
+	}
+}
+
+func TestDecodeNewDate(t *testing.T) {
+	table := &TableMapEvent{
+		ColumnCount: 2,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_NEWDATE, mysql.MYSQL_TYPE_DATE},
+	}
+	require.NoError(t, table.decodeMeta(nil))
+	require.Equal(t, []uint16{0, 0}, table.ColumnMeta)
+
+	e := &RowsEvent{}
+	for _, tp := range table.ColumnType {
+		// 2024-06-15
+		v, n, err := e.decodeValue([]byte("\xcf\xd0\x0f\xff"), tp, 0, false)
+		require.NoError(t, err)
+		require.Equal(t, 3, n)
+		require.Equal(t, "2024-06-15", v)
+
+		v, _, err = e.decodeValue([]byte("\x00\x00\x00"), tp, 0, false)
+		require.NoError(t, err)
+		require.Equal(t, "0000-00-00", v)
+	}
+}
+
+func TestJsonDiffJSONPatch(t *testing.T) {
+	testcases := []struct {
+		diff     JsonDiff
+		expected string
+	}{
+		{JsonDiff{Op: JsonDiffOperationReplace, Path: "$.a", Value: "3"}, `[{"op":"replace","path":"/a","value":3}]`},
+		{JsonDiff{Op: JsonDiffOperationInsert, Path: "$.a[1]", Value: `{"b":"c"}`}, `[{"op":"add","path":"/a/1","value":{"b":"c"}}]`},
+		{JsonDiff{Op: JsonDiffOperationRemove, Path: "$[0].b"}, `[{"op":"remove","path":"/0/b"}]`},
+		{JsonDiff{Op: JsonDiffOperationReplace, Path: `$."a/b"."c~d"."e\"f"`, Value: "null"}, `[{"op":"replace","path":"/a~1b/c~0d/e\"f","value":null}]`},
+		{JsonDiff{Op: JsonDiffOperationReplace, Path: "$", Value: "[]"}, `[{"op":"replace","path":"","value":[]}]`},
+	}
+	for _, tc := range testcases {
+		patch, err := tc.diff.JSONPatch()
+		require.NoError(t, err)
+		require.JSONEq(t, tc.expected, string(patch))
+		// String() is unchanged
+		require.Contains(t, tc.diff.String(), tc.diff.Path)
+	}
+
+	for _, path := range []string{"a", "$.*", "$[*]", "$[last]", "$.a[1 to 2]", `$."a`, "$**.a"} {
+		_, err := (&JsonDiff{Path: path, Value: "1"}).JSONPatch()
+		require.Error(t, err, path)
+	}
+}
+
+func TestTableMapVisibilityMapUnaligned(t *testing.T) {
+	// 11 columns, the 5 padding bits of the last byte are set
+	table := &TableMapEvent{
+		ColumnCount:      11,
+		VisibilityBitmap: []byte{0xaa, 0xbf},
+	}
+	expected := map[int]bool{
+		0: true, 1: false, 2: true, 3: false, 4: true, 5: false, 6: true, 7: false,
+		8: true, 9: false, 10: true,
+	}
+	require.Equal(t, expected, table.VisibilityMap())
+
+	// a byte-aligned column count
+	table = &TableMapEvent{ColumnCount: 8, VisibilityBitmap: []byte{0x81}}
+	require.Equal(t, map[int]bool{0: true, 1: false, 2: false, 3: false, 4: false, 5: false, 6: false, 7: true}, table.VisibilityMap())
+	require.Equal(t, []bool{true, false, false, false, false, false, false, true}, table.VisibilitySlice())
+	require.Nil(t, (&TableMapEvent{ColumnCount: 8}).VisibilitySlice())
+
+	// a short bitmap doesn't panic
+	table = &TableMapEvent{ColumnCount: 11, VisibilityBitmap: []byte{0xfe}}
+	require.Len(t, table.VisibilityMap(), 8)
+	require.Equal(t, []bool{true, true, true, true, true, true, true, false, true, true, true}, table.VisibilitySlice())
+
+	table = &TableMapEvent{ColumnCount: 0, VisibilityBitmap: []byte{0xff}}
+	require.Empty(t, table.VisibilityMap())
+}
+
+func TestRowsEventTruncatedColumnBitmap(t *testing.T) {
+	table := &TableMapEvent{TableID: 1, ColumnCount: 9}
+	newEvent := func(needBitmap2 bool) *RowsEvent {
+		return &RowsEvent{
+			Version:     2,
+			tableIDSize: 6,
+			needBitmap2: needBitmap2,
+			tables:      map[uint64]*TableMapEvent{1: table},
+		}
+	}
+	// table id, flags, extra data len, column count 9
+	header := []byte("\x01\x00\x00\x00\x00\x00\x00\x00\x02\x00\x09")
+
+	_, err := newEvent(false).DecodeHeader(append(header, 0xff))
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	pos, err := newEvent(false).DecodeHeader(append(header, 0xff, 0x01))
+	require.NoError(t, err)
+	require.Equal(t, len(header)+2, pos)
+
+	_, err = newEvent(true).DecodeHeader(append(header, 0xff, 0x01, 0xff))
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	_, err = newEvent(true).DecodeHeader(append(header, 0xff, 0x01, 0xff, 0x01))
+	require.NoError(t, err)
+
+	// a corrupted column count
+	_, err = newEvent(false).DecodeHeader([]byte("\x01\x00\x00\x00\x00\x00\x00\x00\x02\x00\xfe\xff\xff\xff\xff\xff\xff\xff\xff"))
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	_, err = newEvent(false).DecodeHeader(header)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestJsonParseTime(t *testing.T) {
+	// ["2024-06-01 12:30:45.123456", "0000-00-00 00:00:00"] with DATETIME values inlined as OPAQUE
+	datetime := int64(((2024*13+6)<<5|1)<<17|12<<12|30<<6|45)<<24 | 123456
+	data := []byte{
+		JSONB_SMALL_ARRAY,
+		0x02, 0x00, 0x1e, 0x00, // count, size
+		JSONB_OPAQUE, 0x0a, 0x00,
+		JSONB_OPAQUE, 0x14, 0x00,
+		mysql.MYSQL_TYPE_DATETIME, 0x08,
+	}
+	data = append(data, mysql.Uint64ToBytes(uint64(datetime))...)
+	data = append(data, mysql.MYSQL_TYPE_DATETIME, 0x08)
+	data = append(data, mysql.Uint64ToBytes(0)...)
+
+	e := &RowsEvent{}
+	d, err := e.decodeJsonBinary(data)
+	require.NoError(t, err)
+	require.Equal(t, `["2024-06-01 12:30:45.123456","0000-00-00 00:00:00"]`, string(d))
+
+	// the JSON text is unchanged
+	e.parseTime = true
+	d, err = e.decodeJsonBinary(data)
+	require.NoError(t, err)
+	require.Equal(t, `["2024-06-01 12:30:45.123456","0000-00-00 00:00:00"]`, string(d))
+
+	// native values
+	v, err := e.decodeJsonBinaryValue(data, true)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{time.Date(2024, 6, 1, 12, 30, 45, 123456000, time.UTC), "0000-00-00 00:00:00"}, v)
+
+	e.parseTime = false
+	v, err = e.decodeJsonBinaryValue(data, true)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{"2024-06-01 12:30:45.123456", "0000-00-00 00:00:00"}, v)
+}
+
+func TestJsonScalarRoot(t *testing.T) {
+	longString := strings.Repeat("a", 200)
+	testcases := []struct {
+		data     []byte
+		expected string
+	}{
+		{[]byte{JSONB_INT16, 0xd6, 0xff}, `-42`},
+		{[]byte{JSONB_UINT16, 0xff, 0xff}, `65535`},
+		{append([]byte{JSONB_INT32}, mysql.Uint32ToBytes(uint32(0x80000000))...), `-2147483648`},
+		{append([]byte{JSONB_UINT32}, mysql.Uint32ToBytes(42)...), `42`},
+		{append([]byte{JSONB_INT64}, mysql.Uint64ToBytes(uint64(1)<<63)...), `-9223372036854775808`},
+		{append([]byte{JSONB_UINT64}, mysql.Uint64ToBytes(math.MaxUint64)...), `18446744073709551615`},
+		{append([]byte{JSONB_DOUBLE}, mysql.Uint64ToBytes(math.Float64bits(1.5))...), `1.5`},
+		{append([]byte{JSONB_DOUBLE}, mysql.Uint64ToBytes(math.Float64bits(-2.5e-10))...), `-2.5e-10`},
+		{[]byte{JSONB_STRING, 0x05, 'h', 'e', 'l', 'l', 'o'}, `"hello"`},
+		{[]byte{JSONB_STRING, 0x00}, `""`},
+		{[]byte{JSONB_STRING, 0x03, 'a', '"', 'b'}, `"a\"b"`},
+		{append([]byte{JSONB_STRING, 0xc8, 0x01}, longString...), `"` + longString + `"`},
+		{[]byte{JSONB_LITERAL, JSONB_TRUE_LITERAL}, `true`},
+		{[]byte{JSONB_LITERAL, JSONB_FALSE_LITERAL}, `false`},
+		{[]byte{JSONB_LITERAL, JSONB_NULL_LITERAL}, `null`},
+	}
+	for _, tc := range testcases {
+		e := &RowsEvent{}
+		d, err := e.decodeJsonBinary(tc.data)
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, string(d))
+		require.True(t, json.Valid(d))
+	}
+
+	// truncated scalars
+	for _, data := range [][]byte{{JSONB_INT16, 0x01}, {JSONB_DOUBLE, 0x00}, {JSONB_STRING, 0x05, 'h'}, {JSONB_LITERAL}} {
+		e := &RowsEvent{}
+		_, err := e.decodeJsonBinary(data)
+		require.Error(t, err)
+	}
+}
+
+func TestRowsEventMaxRows(t *testing.T) {
+	table := &TableMapEvent{
+		TableID:     1,
+		ColumnCount: 1,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG},
+		ColumnMeta:  []uint16{0},
+	}
+	built, err := NewRowsEventBuilder(table, WRITE_ROWS_EVENTv2).
+		AddRow(int32(1)).AddRow(int32(2)).AddRow(int32(3)).
+		Build()
+	require.NoError(t, err)
+	data, err := built.Encode()
+	require.NoError(t, err)
+
+	newEvent := func(maxRows int) *RowsEvent {
+		return &RowsEvent{
+			Version:     2,
+			tableIDSize: 6,
+			tables:      map[uint64]*TableMapEvent{1: table},
+			eventType:   WRITE_ROWS_EVENTv2,
+			maxRows:     maxRows,
+		}
+	}
+
+	for _, maxRows := range []int{0, 3} {
+		e := newEvent(maxRows)
+		require.NoError(t, e.Decode(data))
+		require.Len(t, e.Rows, 3)
+	}
+
+	e := newEvent(2)
+	err = e.Decode(data)
+	require.ErrorIs(t, err, ErrTooManyRows)
+	require.Equal(t, [][]interface{}{{int32(1)}, {int32(2)}}, e.Rows)
+
+	// the after image of an update counts as a row, in both decoding paths
+	built, err = NewRowsEventBuilder(table, UPDATE_ROWS_EVENTv2).
+		AddRow(int32(1)).AddRow(int32(2)).AddRow(int32(3)).AddRow(int32(4)).
+		Build()
+	require.NoError(t, err)
+	data, err = built.Encode()
+	require.NoError(t, err)
+	for _, parallelDecodeMinRows := range []int{0, 1} {
+		e = newEvent(3)
+		e.eventType, e.needBitmap2 = UPDATE_ROWS_EVENTv2, true
+		e.parallelDecodeMinRows = parallelDecodeMinRows
+		err = e.Decode(data)
+		require.ErrorIs(t, err, ErrTooManyRows)
+		require.Equal(t, [][]interface{}{{int32(1)}, {int32(2)}, {int32(3)}}, e.Rows)
+
+		e = newEvent(4)
+		e.eventType, e.needBitmap2 = UPDATE_ROWS_EVENTv2, true
+		e.parallelDecodeMinRows = parallelDecodeMinRows
+		require.NoError(t, e.Decode(data))
+		require.Len(t, e.Rows, 4)
+	}
+}
+
+func TestEncodeDecimal(t *testing.T) {
+	for _, d := range decimalData {
+		data, err := encodeDecimal(d.num, int(d.meta>>8), int(d.meta&0xFF))
+		require.NoError(t, err)
+		require.Equal(t, d.dumpData, data, d.num)
+	}
+
+	testcases := []struct {
+		value     string
+		precision int
+		decimals  int
+		expected  string
+	}{
+		{"-123.45", 10, 2, "-123.45"},
+		{"123.4", 10, 2, "123.40"},
+		{"0042", 5, 0, "42"},
+		{"-0.00", 4, 2, "0.00"},
+		{".5", 4, 2, "0.50"},
+		{"+7.", 4, 2, "7.00"},
+		{"-99999999999999999999999999999999999.999999999999999999999999999999", 65, 30, "-99999999999999999999999999999999999.999999999999999999999999999999"},
+		{"123456789012345678.000000001", 30, 9, "123456789012345678.000000001"},
+		{"-1.000000000000000000000000000001", 65, 30, "-1.000000000000000000000000000001"},
+		{"1.23400", 4, 3, "1.234"},
+	}
+	for _, tc := range testcases {
+		data, err := encodeDecimal(tc.value, tc.precision, tc.decimals)
+		require.NoError(t, err, tc.value)
+		v, n, err := decodeDecimal(data, tc.precision, tc.decimals, false)
+		require.NoError(t, err)
+		require.Len(t, data, n)
+		require.Equal(t, tc.expected, v)
+	}
+
+	// invalid or out of range of decimal(5,2)
+	for _, value := range []string{"", "-", ".", "1.2.3", "1e5", "abc", "123.456", "1234", "-1000.1"} {
+		_, err := encodeDecimal(value, 5, 2)
+		require.Error(t, err, value)
+	}
+	_, err := encodeDecimal("1", 66, 0)
+	require.Error(t, err)
+	_, err = encodeDecimal("1", 2, 3)
+	require.Error(t, err)
+
+	// RowsEvent.Encode supports DECIMAL columns
+	table := &TableMapEvent{
+		ColumnCount: 2,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_NEWDECIMAL, mysql.MYSQL_TYPE_NEWDECIMAL},
+		ColumnMeta:  []uint16{10<<8 | 2, 10<<8 | 2},
+	}
+	e, err := NewRowsEventBuilder(table, WRITE_ROWS_EVENTv2).AddRow("-123.45", decimal.RequireFromString("1.5")).Build()
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{"-123.45", "1.50"}, e.Rows[0])
+}
+
+func TestBinaryAsBytes(t *testing.T) {
+	// CREATE TABLE t (b BINARY(16), vb VARBINARY(20), v VARCHAR(20))
+	newTable := func() *TableMapEvent {
+		return &TableMapEvent{
+			ColumnType: []byte{mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_VARCHAR},
+			ColumnMeta: []uint16{uint16(mysql.MYSQL_TYPE_STRING)<<8 | 16, 20, 80},
+			// binary, binary, utf8mb4_0900_ai_ci
+			collations: map[int]uint64{0: 63, 1: 63, 2: 255},
+		}
+	}
+	data := []byte("\x00\x03a\x00b\x02\xff\xfe\x02hi")
+
+	e := RowsEvent{Table: newTable(), ColumnCount: 3}
+	n, err := e.decodeImage(data, []byte{0x07}, EnumRowImageTypeWriteAI)
+	require.NoError(t, err)
+	require.Len(t, data, n)
+	require.Equal(t, []interface{}{"a\x00b", "\xff\xfe", "hi"}, e.Rows[0])
+
+	e = RowsEvent{Table: newTable(), ColumnCount: 3, binaryAsBytes: true}
+	_, err = e.decodeImage(data, []byte{0x07}, EnumRowImageTypeWriteAI)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{[]byte("a\x00b"), []byte("\xff\xfe"), "hi"}, e.Rows[0])
+
+	// together with the transcoding
+	e = RowsEvent{Table: newTable(), ColumnCount: 3, binaryAsBytes: true, transcodeToUTF8: true}
+	_, err = e.decodeImage(data, []byte{0x07}, EnumRowImageTypeWriteAI)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{[]byte("a\x00b"), []byte("\xff\xfe"), "hi"}, e.Rows[0])
+
+	// the collations are not logged
+	table := newTable()
+	table.collations = map[int]uint64{}
+	e = RowsEvent{Table: table, ColumnCount: 3, binaryAsBytes: true}
+	_, err = e.decodeImage(data, []byte{0x07}, EnumRowImageTypeWriteAI)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{"a\x00b", "\xff\xfe", "hi"}, e.Rows[0])
+}
+
+func TestBinaryAsHex(t *testing.T) {
+	// CREATE TABLE t (b BINARY(16), vb VARBINARY(20), v VARCHAR(20), bl BLOB, tx TEXT)
+	newTable := func() *TableMapEvent {
+		return &TableMapEvent{
+			ColumnType: []byte{
+				mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_VARCHAR,
+				mysql.MYSQL_TYPE_BLOB, mysql.MYSQL_TYPE_BLOB,
+			},
+			ColumnMeta: []uint16{uint16(mysql.MYSQL_TYPE_STRING)<<8 | 16, 20, 80, 2, 2},
+			// binary, binary, utf8mb4_0900_ai_ci, binary, utf8mb4_0900_ai_ci
+			collations: map[int]uint64{0: 63, 1: 63, 2: 255, 3: 63, 4: 255},
+		}
+	}
+	data := []byte("\x00\x03a\x00b\x02\xff\xfe\x02hi\x02\x00\xab\xcd\x02\x00hi")
+
+	e := RowsEvent{Table: newTable(), ColumnCount: 5, binaryAsHex: true}
+	n, err := e.decodeImage(data, []byte{0x1f}, EnumRowImageTypeWriteAI)
+	require.NoError(t, err)
+	require.Len(t, data, n)
+	require.Equal(t, []interface{}{"610062", "fffe", "hi", "abcd", []byte("hi")}, e.Rows[0])
+
+	// uppercase, and before BinaryAsBytes
+	e = RowsEvent{Table: newTable(), ColumnCount: 5, binaryAsHex: true, binaryAsUpperHex: true, binaryAsBytes: true}
+	_, err = e.decodeImage(data, []byte{0x1f}, EnumRowImageTypeWriteAI)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{"610062", "FFFE", "hi", "ABCD", []byte("hi")}, e.Rows[0])
+
+	opts := DecodeOptions{BinaryAsHex: true}
+	for i, tp := range []reflect.Type{stringType, stringType, stringType, stringType, bytesType} {
+		require.Equal(t, tp, e.Table.GoType(i, opts), "column %d", i)
+	}
+
+	// the Avro bytes fields hold the bytes
+	e.Table.ColumnCount = 5
+	e.Table.ColumnName = [][]byte{[]byte("b"), []byte("vb"), []byte("v"), []byte("bl"), []byte("tx")}
+	avro, err := e.AvroValues(0)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"b": []byte("a\x00b"), "vb": []byte("\xff\xfe"), "v": "hi", "bl": []byte("\xab\xcd"), "tx": "hi",
+	}, avro)
+
+	// the collations are not logged, BLOB and TEXT can't be told apart
+	table := newTable()
+	table.collations = map[int]uint64{}
+	e = RowsEvent{Table: table, ColumnCount: 5, binaryAsHex: true}
+	_, err = e.decodeImage(data, []byte{0x1f}, EnumRowImageTypeWriteAI)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{"a\x00b", "\xff\xfe", "hi", "abcd", "6869"}, e.Rows[0])
+}
+
+func TestRowsEventValueLiterals(t *testing.T) {
+	table := &TableMapEvent{
+		ColumnCount: 11,
+		ColumnType: []byte{
+			mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_DOUBLE,
+			mysql.MYSQL_TYPE_NEWDECIMAL, mysql.MYSQL_TYPE_BIT, mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_DATETIME2,
+			mysql.MYSQL_TYPE_BLOB, mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_LONG,
+		},
+		ColumnMeta: []uint16{0, 80, 20, 8, 10<<8 | 2, 2<<8 | 1, uint16(mysql.MYSQL_TYPE_ENUM)<<8 | 1, 3, 2, 0, 0},
+		// utf8mb4_0900_ai_ci, binary
+		collations: map[int]uint64{1: 255, 2: 63},
+		enumLabels: map[int][]string{6: {"a", "b"}},
+	}
+	e := &RowsEvent{
+		Table: table,
+		Rows: [][]interface{}{{
+			int32(-1), "it's", "\x00\xff", 1.5, "-12.34", int64(5), int64(2),
+			time.Date(2024, 1, 2, 3, 4, 5, 6000000, time.UTC), []byte("\xff"), nil, nil,
+		}},
+		SkippedColumns: [][]int{{10}},
+	}
+
+	literals, err := e.ValueLiterals(0)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"-1", `'it\'s'`, "X'00ff'", "1.5", "-12.34", "b'101'", "'b'",
+		"'2024-01-02 03:04:05.006'", "X'ff'", "NULL", "",
+	}, literals)
+
+	// ENUM without labels
+	table.enumLabels = map[int][]string{}
+	literals, err = e.ValueLiterals(0)
+	require.NoError(t, err)
+	require.Equal(t, "2", literals[6])
+
+	e.Rows[0][1] = &JsonDiff{}
+	_, err = e.ValueLiterals(0)
+	require.Error(t, err)
+
+	_, err = e.ValueLiterals(1)
+	require.Error(t, err)
+}
+
+func TestRowsEventAvroValues(t *testing.T) {
+	table := &TableMapEvent{
+		ColumnCount: 11,
+		ColumnType: []byte{
+			mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_LONGLONG,
+			mysql.MYSQL_TYPE_NEWDECIMAL, mysql.MYSQL_TYPE_NEWDECIMAL, mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_DATETIME2,
+			mysql.MYSQL_TYPE_BLOB, mysql.MYSQL_TYPE_JSON, mysql.MYSQL_TYPE_LONG,
+		},
+		ColumnMeta: []uint16{0, 80, 20, 0, 10<<8 | 2, 10<<8 | 2, uint16(mysql.MYSQL_TYPE_ENUM)<<8 | 1, 3, 2, 4, 0},
+		ColumnName: [][]byte{
+			[]byte("i"), []byte("s"), []byte("b"), []byte("u"), []byte("d1"), []byte("d2"),
+			[]byte("e"), []byte("dt"), []byte("blob"), []byte("j"), []byte("skipped"),
+		},
+		// utf8mb4_0900_ai_ci, binary
+		collations: map[int]uint64{1: 255, 2: 63},
+		enumLabels: map[int][]string{6: {"a", "b"}},
+	}
+	e := &RowsEvent{
+		Table: table,
+		Rows: [][]interface{}{{
+			int32(-1), "it's", "\x00\xff", uint64(1 << 40), "-12.34", decimal.RequireFromString("1.28"), int64(2),
+			time.Date(2024, 1, 2, 3, 4, 5, 6000000, time.UTC), []byte("\xff"), []byte(`{"a":1}`), nil,
+		}},
+		SkippedColumns: [][]int{{10}},
+	}
+
+	values, err := e.AvroValues(0)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"i":    int32(-1),
+		"s":    "it's",
+		"b":    []byte("\x00\xff"),
+		"u":    int64(1 << 40),
+		"d1":   []byte{0xfb, 0x2e},
+		"d2":   []byte{0x00, 0x80},
+		"e":    "b",
+		"dt":   "2024-01-02 03:04:05.006",
+		"blob": []byte("\xff"),
+		"j":    `{"a":1}`,
+	}, values)
+
+	// ENUM without labels
+	table.enumLabels = map[int][]string{}
+	values, err = e.AvroValues(0)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), values["e"])
+
+	e.Rows[0][4] = "1.234"
+	_, err = e.AvroValues(0)
+	require.ErrorContains(t, err, "more than 2 decimal digits")
+
+	e.Rows[0][4] = nil
+	e.Rows[0][3] = uint64(math.MaxUint64)
+	_, err = e.AvroValues(0)
+	require.ErrorContains(t, err, "overflows long")
+
+	e.Rows[0][3] = nil
+	e.Rows[0][1] = &JsonDiff{}
+	_, err = e.AvroValues(0)
+	require.Error(t, err)
+
+	_, err = e.AvroValues(1)
+	require.Error(t, err)
+
+	_, err = (&RowsEvent{Table: &TableMapEvent{}, Rows: e.Rows}).AvroValues(0)
+	require.ErrorContains(t, err, "column names")
+}
+
+func TestTwosComplementBytes(t *testing.T) {
+	testcases := []struct {
+		x        int64
+		expected []byte
+	}{
+		{0, []byte{0x00}},
+		{1, []byte{0x01}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x00, 0x80}},
+		{-1, []byte{0xff}},
+		{-128, []byte{0x80}},
+		{-129, []byte{0xff, 0x7f}},
+		{-1234, []byte{0xfb, 0x2e}},
+		{math.MinInt64, []byte{0x80, 0, 0, 0, 0, 0, 0, 0}},
+	}
+	for _, tc := range testcases {
+		require.Equal(t, tc.expected, twosComplementBytes(big.NewInt(tc.x)), "%d", tc.x)
+	}
+}
+
+func TestRowsEventRowsWithSchema(t *testing.T) {
+	table := &TableMapEvent{
+		ColumnCount: 3,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_NEWDECIMAL},
+		ColumnMeta:  []uint16{0, 80, 10<<8 | 2},
+		ColumnName:  [][]byte{[]byte("id"), []byte("name"), []byte("price")},
+		// utf8mb4_0900_ai_ci
+		collations: map[int]uint64{1: 255},
+	}
+	e := &RowsEvent{
+		Table:       table,
+		ColumnCount: 3,
+		Rows: [][]interface{}{
+			{int32(1), "a", "1.50"},
+			{int32(2), nil, nil},
+			nil,
+		},
+		SkippedColumns: [][]int{{}, {2}, nil},
+	}
+
+	rows, err := e.RowsWithSchema()
+	require.NoError(t, err)
+	require.Equal(t, []SchemaRow{
+		{
+			{Name: "id", Type: "INT", Value: int32(1)},
+			{Name: "name", Type: "VARCHAR(20)", Value: "a"},
+			{Name: "price", Type: "DECIMAL(10,2)", Value: "1.50"},
+		},
+		{
+			{Name: "id", Type: "INT", Value: int32(2)},
+			{Name: "name", Type: "VARCHAR(20)", Value: nil},
+		},
+		nil,
+	}, rows)
+
+	// the names are not logged
+	table = &TableMapEvent{ColumnCount: 3, ColumnType: table.ColumnType, ColumnMeta: table.ColumnMeta}
+	e.Table = table
+	rows, err = e.RowsWithSchema()
+	require.NoError(t, err)
+	require.Equal(t, SchemaRow{
+		{Name: "col_0", Type: "INT", Value: int32(2)},
+		{Name: "col_1", Type: "VARCHAR(80)", Value: nil},
+	}, rows[1])
+
+	e.Table = nil
+	_, err = e.RowsWithSchema()
+	require.ErrorIs(t, err, errMissingTableMapEvent)
+}
+
+func TestRowsEventColumnPresent(t *testing.T) {
+	tableMapEvent := &TableMapEvent{
+		tableIDSize: 6,
+		TableID:     0x1d3,
+		ColumnCount: 3,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_TINY, mysql.MYSQL_TYPE_TINY, mysql.MYSQL_TYPE_TINY},
+		ColumnMeta:  []uint16{0, 0, 0},
+	}
+	rows := &RowsEvent{
+		tableIDSize: 6,
+		tables:      map[uint64]*TableMapEvent{tableMapEvent.TableID: tableMapEvent},
+		Version:     2,
+		eventType:   UPDATE_ROWS_EVENTv2,
+		needBitmap2: true,
+	}
+
+	// CREATE TABLE t (a TINYINT PRIMARY KEY, b TINYINT, c TINYINT)
+	// UPDATE t SET b = 5, c = NULL WHERE a = 1, with binlog_row_image=MINIMAL
+	data := []byte("\xd3\x01\x00\x00\x00\x00\x01\x00\x02\x00\x03\x01\x06\x00\x01\x02\x05")
+	err := rows.Decode(data)
+	require.NoError(t, err)
+	require.Equal(t, [][]interface{}{{int8(1), nil, nil}, {nil, int8(5), nil}}, rows.Rows)
+
+	// before image
+	require.True(t, rows.ColumnPresent(0, 0))
+	require.False(t, rows.ColumnPresent(0, 1))
+	require.False(t, rows.ColumnPresent(0, 2))
+	// after image, c is present and NULL
+	require.False(t, rows.ColumnPresent(1, 0))
+	require.True(t, rows.ColumnPresent(1, 1))
+	require.True(t, rows.ColumnPresent(1, 2))
+
+	require.False(t, rows.ColumnPresent(2, 0))
+	require.False(t, rows.ColumnPresent(0, 3))
+	require.False(t, rows.ColumnPresent(-1, 0))
+}
+
+func TestRowsEventMergedUpdates(t *testing.T) {
+	// CREATE TABLE t (id INT PRIMARY KEY, a VARCHAR(10), b BLOB, c JSON)
+	e := &RowsEvent{
+		Table: &TableMapEvent{
+			ColumnCount: 4,
+			ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_BLOB, mysql.MYSQL_TYPE_JSON},
+			ColumnMeta:  []uint16{0, 10, 2, 4},
+		},
+		ColumnCount:   4,
+		eventType:     UPDATE_ROWS_EVENTv2,
+		needBitmap2:   true,
+		ColumnBitmap1: []byte{0x0f},
+		ColumnBitmap2: []byte{0x0f},
+		Rows: [][]interface{}{
+			// UPDATE t SET a = 'y', c = JSON_REPLACE(c, '$.a', 1) WHERE id = 1
+			{int32(1), "x", []byte("b"), `{"a":0}`},
+			{int32(1), "y", []byte("b"), &JsonDiff{Op: JsonDiffOperationReplace, Path: "$.a", Value: "1"}},
+			// UPDATE t SET a = NULL, b = 'c' WHERE id = 2
+			{int32(2), "x", []byte("b"), nil},
+			{int32(2), nil, []byte("c"), nil},
+		},
+	}
+
+	updates, err := e.MergedUpdates()
+	require.NoError(t, err)
+	require.Equal(t, []MergedUpdate{
+		{
+			Row:     e.Rows[1],
+			Changed: []int{1, 3},
+			Before:  map[int]interface{}{1: "x", 3: `{"a":0}`},
+		},
+		{
+			Row:     e.Rows[3],
+			Changed: []int{1, 2},
+			Before:  map[int]interface{}{1: "x", 2: []byte("b")},
+		},
+	}, updates)
+
+	// binlog_row_image=MINIMAL: the before image has the primary key,
+	// the after image the changed columns
+	e.ColumnBitmap1 = []byte{0x01}
+	e.ColumnBitmap2 = []byte{0x06}
+	e.Rows = [][]interface{}{
+		{int32(1), nil, nil, nil},
+		{nil, "y", []byte("b"), nil},
+	}
+	updates, err = e.MergedUpdates()
+	require.NoError(t, err)
+	require.Equal(t, []MergedUpdate{{
+		Row:     []interface{}{int32(1), "y", []byte("b"), nil},
+		Changed: []int{1, 2},
+		Missing: []int{3},
+	}}, updates)
+
+	// a row image failed to decode
+	e.RowErrors = []error{nil, fmt.Errorf("bad row")}
+	e.Rows[1] = nil
+	_, err = e.MergedUpdates()
+	require.ErrorContains(t, err, "bad row")
+
+	e.RowErrors = nil
+	e.Rows = e.Rows[:1]
+	_, err = e.MergedUpdates()
+	require.ErrorContains(t, err, "odd number")
+
+	e.needBitmap2 = false
+	e.eventType = WRITE_ROWS_EVENTv2
+	_, err = e.MergedUpdates()
+	require.ErrorContains(t, err, "WriteRowsEventV2 is not an update event")
+
+	// the values are compared by ValuesEqual: CREATE TABLE t (d DECIMAL(10,2), f DOUBLE)
+	e = &RowsEvent{
+		Table: &TableMapEvent{
+			ColumnCount: 2,
+			ColumnType:  []byte{mysql.MYSQL_TYPE_NEWDECIMAL, mysql.MYSQL_TYPE_DOUBLE},
+			ColumnMeta:  []uint16{10<<8 | 2, 8},
+		},
+		ColumnCount:   2,
+		eventType:     UPDATE_ROWS_EVENTv2,
+		needBitmap2:   true,
+		ColumnBitmap1: []byte{0x03},
+		ColumnBitmap2: []byte{0x03},
+		Rows: [][]interface{}{
+			{"1.50", float64(2)},
+			{decimal.RequireFromString("1.5"), float64(2.5)},
+		},
+	}
+	updates, err = e.MergedUpdates()
+	require.NoError(t, err)
+	require.Equal(t, []int{1}, updates[0].Changed)
+}
+
+func TestRowsEventChecksumLength(t *testing.T) {
+	tableMapEvent := &TableMapEvent{
+		tableIDSize: 6,
+		TableID:     0x1d3,
+		ColumnCount: 1,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_TINY},
+		ColumnMeta:  []uint16{0},
+	}
+	newRowsEvent := func(checksumLength int) *RowsEvent {
+		return &RowsEvent{
+			tableIDSize:    6,
+			tables:         map[uint64]*TableMapEvent{tableMapEvent.TableID: tableMapEvent},
+			Version:        2,
+			eventType:      WRITE_ROWS_EVENTv2,
+			ChecksumLength: checksumLength,
+		}
+	}
+
+	// INSERT INTO t VALUES (1), (2)
+	data := []byte("\xd3\x01\x00\x00\x00\x00\x01\x00\x02\x00\x01\xff\xfe\x01\xfe\x02")
+	crc := make([]byte, BinlogChecksumLength)
+	binary.LittleEndian.PutUint32(crc, crc32.ChecksumIEEE(data))
+	dataWithCRC := append(append([]byte{}, data...), crc...)
+
+	// without a trailing CRC
+	rows := newRowsEvent(0)
+	require.NoError(t, rows.Decode(data))
+	require.Equal(t, [][]interface{}{{int8(1)}, {int8(2)}}, rows.Rows)
+
+	// with a trailing CRC
+	rows = newRowsEvent(BinlogChecksumLength)
+	require.NoError(t, rows.Decode(dataWithCRC))
+	require.Equal(t, [][]interface{}{{int8(1)}, {int8(2)}}, rows.Rows)
+
+	// the CRC is decoded as rows if its length is not set
+	rows = newRowsEvent(0)
+	err := rows.Decode(dataWithCRC)
+	require.NoError(t, err)
+	require.NotEqual(t, [][]interface{}{{int8(1)}, {int8(2)}}, rows.Rows)
+
+	rows = newRowsEvent(BinlogChecksumLength)
+	err = rows.DecodeData(len(data)-2, data)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestRowsEventBlobOmitted(t *testing.T) {
+	tableMapEvent := &TableMapEvent{
+		tableIDSize: 6,
+		TableID:     0x1d3,
+		ColumnCount: 3,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_BLOB, mysql.MYSQL_TYPE_LONG},
+		ColumnMeta:  []uint16{0, 2, 0},
+	}
+	rows := &RowsEvent{
+		tableIDSize: 6,
+		tables:      map[uint64]*TableMapEvent{tableMapEvent.TableID: tableMapEvent},
+		Version:     2,
+		eventType:   UPDATE_ROWS_EVENTv2,
+		needBitmap2: true,
+	}
+
+	// CREATE TABLE t (id INT PRIMARY KEY, doc TEXT, n INT)
+	// UPDATE t SET n = 2 WHERE id = 1, with binlog_row_image=NOBLOB
+	data := []byte("\xd3\x01\x00\x00\x00\x00\x01\x00\x02\x00\x03\x05\x05" +
+		"\x00\x01\x00\x00\x00\x01\x00\x00\x00" +
+		"\x00\x01\x00\x00\x00\x02\x00\x00\x00")
+	err := rows.Decode(data)
+	require.NoError(t, err)
+	// the TEXT column consumes no bytes
+	require.Equal(t, []int{13, 22}, rows.RowOffsets)
+	require.Equal(t, [][]interface{}{{int32(1), nil, int32(1)}, {int32(1), nil, int32(2)}}, rows.Rows)
+	require.Equal(t, [][]int{{1}, {1}}, rows.SkippedColumns)
+	require.Equal(t, "partial", rows.InferredRowImage())
+
+	for k := range rows.Rows {
+		require.False(t, rows.BlobOmitted(k, 0))
+		require.True(t, rows.BlobOmitted(k, 1))
+		require.False(t, rows.BlobOmitted(k, 2))
+	}
+	require.False(t, rows.BlobOmitted(2, 1))
+	require.False(t, rows.BlobOmitted(0, 3))
+
+	// a TEXT column set to NULL is present
+	data = []byte("\xd3\x01\x00\x00\x00\x00\x01\x00\x02\x00\x03\x05\x07" +
+		"\x00\x01\x00\x00\x00\x01\x00\x00\x00" +
+		"\x02\x01\x00\x00\x00\x02\x00\x00\x00")
+	err = rows.Decode(data)
+	require.NoError(t, err)
+	require.Equal(t, [][]interface{}{{int32(1), nil, int32(1)}, {int32(1), nil, int32(2)}}, rows.Rows)
+	require.True(t, rows.BlobOmitted(0, 1))
+	require.False(t, rows.BlobOmitted(1, 1))
+}
+
+func TestRowsEventRawEventType(t *testing.T) {
+	table := &TableMapEvent{
+		TableID:     1,
+		ColumnCount: 1,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG},
+		ColumnMeta:  []uint16{0},
+	}
+	for _, eventType := range []EventType{WRITE_ROWS_EVENTv1, UPDATE_ROWS_EVENTv1, UPDATE_ROWS_EVENTv2, DELETE_ROWS_EVENTv2} {
+		b := NewRowsEventBuilder(table, eventType).AddRow(int32(1))
+		if eventType == UPDATE_ROWS_EVENTv1 || eventType == UPDATE_ROWS_EVENTv2 {
+			b.AddRow(int32(2))
+		}
+		e, err := b.Build()
+		require.NoError(t, err)
+		require.Equal(t, eventType, e.RawEventType())
+	}
+}
+
+func TestRowsEventTableResolver(t *testing.T) {
+	tableMapEvent := &TableMapEvent{
+		tableIDSize: 6,
+		TableID:     0x1d3,
+		ColumnCount: 1,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_TINY},
+		ColumnMeta:  []uint16{0},
+	}
+	var resolved []uint64
+	resolver := func(tableID uint64) (*TableMapEvent, bool) {
+		resolved = append(resolved, tableID)
+		if tableID == tableMapEvent.TableID {
+			return tableMapEvent, true
+		}
+		return nil, false
+	}
+
+	// INSERT INTO t VALUES (1)
+	data := []byte("\xd3\x01\x00\x00\x00\x00\x01\x00\x02\x00\x01\xff\xfe\x01")
+	rows := &RowsEvent{
+		tableIDSize: 6,
+		// the map is not used
+		tables:    map[uint64]*TableMapEvent{},
+		Version:   2,
+		eventType: WRITE_ROWS_EVENTv2,
+	}
+	rows.SetTableResolver(resolver)
+	err := rows.Decode(data)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0x1d3}, resolved)
+	require.Same(t, tableMapEvent, rows.Table)
+	require.Equal(t, [][]interface{}{{int8(1)}}, rows.Rows)
+
+	// unknown table id
+	data[0] = 0xd4
+	err = rows.Decode(data)
+	require.ErrorIs(t, err, errMissingTableMapEvent)
+}
+
+func TestNewTableMapEvent(t *testing.T) {
+	// CREATE TABLE t (a INT, b VARCHAR(20), c ENUM('x', 'y'), d DATETIME(3)), utf8mb4
+	columnType := []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_DATETIME2}
+	columnMeta := []uint16{0, 80, uint16(mysql.MYSQL_TYPE_ENUM)<<8 | 1, 3}
+	table := &TableMapEvent{
+		TableID:     42,
+		ColumnCount: 4,
+		ColumnType:  columnType,
+		ColumnMeta:  columnMeta,
+		ColumnName:  [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d")},
+	}
+	e, err := NewRowsEventBuilder(table, WRITE_ROWS_EVENTv2).
+		AddRow(int32(1), "one", int64(2), "2024-06-01 12:30:45.123").
+		Build()
+	require.NoError(t, err)
+	data, err := e.Encode()
+	require.NoError(t, err)
+
+	synthetic, err := NewTableMapEvent(42, "test", "t", columnType, columnMeta)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), synthetic.ColumnCount)
+	require.Equal(t, "test", string(synthetic.Schema))
+	require.Equal(t, "t", string(synthetic.Table))
+	require.Nil(t, synthetic.ColumnNameString())
+	available, _ := synthetic.Nullable(0)
+	require.False(t, available)
+
+	rows := &RowsEvent{tableIDSize: 6, tables: map[uint64]*TableMapEvent{}, Version: 2, eventType: WRITE_ROWS_EVENTv2}
+	err = rows.Decode(data)
+	require.ErrorIs(t, err, errMissingTableMapEvent)
+
+	rows.SetTableResolver(func(uint64) (*TableMapEvent, bool) {
+		return synthetic, true
+	})
+	require.NoError(t, rows.Decode(data))
+	require.Same(t, synthetic, rows.Table)
+	require.Equal(t, e.Rows, rows.Rows)
+
+	_, err = NewTableMapEvent(42, "test", "t", columnType, columnMeta[:3])
+	require.ErrorContains(t, err, "mismatch")
+}
+
+func TestTableMapPrimaryKeyNames(t *testing.T) {
+	// CREATE TABLE t (a INT, b INT, c INT, PRIMARY KEY (c, a))
+	table := &TableMapEvent{
+		Schema:      []byte("test"),
+		Table:       []byte("t"),
+		ColumnCount: 3,
+		ColumnName:  [][]byte{[]byte("a"), []byte("b"), []byte("c")},
+		PrimaryKey:  []uint64{2, 0},
+	}
+	names, err := table.PrimaryKeyNames()
+	require.NoError(t, err)
+	require.Equal(t, []string{"c", "a"}, names)
+
+	// names are not logged
+	table = &TableMapEvent{Schema: []byte("test"), Table: []byte("t"), ColumnCount: 3, PrimaryKey: []uint64{2, 0}}
+	_, err = table.PrimaryKeyNames()
+	require.ErrorContains(t, err, "column names of table test.t are not available")
+
+	// no primary key
+	table = &TableMapEvent{ColumnCount: 1, ColumnName: [][]byte{[]byte("a")}}
+	names, err = table.PrimaryKeyNames()
+	require.NoError(t, err)
+	require.Nil(t, names)
+}
+
+func TestRowsEventParallelDecode(t *testing.T) {
+	table := &TableMapEvent{
+		TableID:     1,
+		ColumnCount: 10,
+		ColumnType: []byte{
+			mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_NEWDECIMAL, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_STRING,
+			mysql.MYSQL_TYPE_BLOB, mysql.MYSQL_TYPE_DATETIME2, mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_BIT,
+			mysql.MYSQL_TYPE_YEAR, mysql.MYSQL_TYPE_VARCHAR,
+		},
+		ColumnMeta: []uint16{
+			0, 10<<8 | 2, 20, uint16(mysql.MYSQL_TYPE_STRING)<<8 | 10,
+			2, 3, uint16(mysql.MYSQL_TYPE_SET)<<8 | 1, 2 << 8,
+			0, 1000,
+		},
+	}
+	b := NewRowsEventBuilder(table, UPDATE_ROWS_EVENTv2).
+		SetColumnBitmaps([]byte{0xff, 0x03}, []byte{0xfd, 0x03})
+	for i := 0; i < 50; i++ {
+		row := []interface{}{
+			int32(i), fmt.Sprintf("%d.25", i), strings.Repeat("v", i%20), "c",
+			bytes.Repeat([]byte{byte(i)}, i*7), "2024-06-01 12:30:45.123", int64(i % 8), int64(i),
+			2000 + i, strings.Repeat("w", i*5),
+		}
+		if i%3 == 0 {
+			row[2], row[4] = nil, nil
+		}
+		b.AddRow(row...)
+	}
+	built, err := b.Build()
+	require.NoError(t, err)
+	data, err := built.Encode()
+	require.NoError(t, err)
+
+	newEvent := func(minRows int) *RowsEvent {
+		return &RowsEvent{
+			Version:               2,
+			tableIDSize:           6,
+			tables:                map[uint64]*TableMapEvent{table.TableID: table},
+			eventType:             UPDATE_ROWS_EVENTv2,
+			needBitmap2:           true,
+			parallelDecodeMinRows: minRows,
+		}
+	}
+
+	sequential := newEvent(0)
+	require.NoError(t, sequential.Decode(data))
+	require.Len(t, sequential.Rows, 50)
+
+	for _, minRows := range []int{2, 50, 51} {
+		parallel := newEvent(minRows)
+		pos, err := parallel.DecodeHeader(data)
+		require.NoError(t, err)
+		require.Equal(t, minRows <= 50, parallel.decodeRowsParallel(pos, data))
+
+		parallel = newEvent(minRows)
+		require.NoError(t, parallel.Decode(data))
+		require.Equal(t, sequential.Rows, parallel.Rows)
+		require.Equal(t, sequential.SkippedColumns, parallel.SkippedColumns)
+		require.Equal(t, sequential.RowOffsets, parallel.RowOffsets)
+		require.Equal(t, sequential.DecodeStats(), parallel.DecodeStats())
+	}
+
+	// a truncated event fails the same way
+	truncated := data[: len(data)-3 : len(data)-3]
+	errSequential := newEvent(0).Decode(truncated)
+	require.Error(t, errSequential)
+	errParallel := newEvent(2).Decode(truncated)
+	require.Error(t, errParallel)
+	// the panic message also dumps the event
+	require.Equal(t, strings.SplitN(errSequential.Error(), ", data", 2)[0], strings.SplitN(errParallel.Error(), ", data", 2)[0])
+}
+
+func TestRowsEventDecodeStatsAdd(t *testing.T) {
+	// every counter is added, so that the parallel decoding doesn't drop a new one
+	var s, o RowsEventDecodeStats
+	v := reflect.ValueOf(&o).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		v.Field(i).SetInt(int64(i + 1))
+	}
+	s.add(o)
+	s.add(o)
+	for i := 0; i < v.NumField(); i++ {
+		require.Equal(t, int64(2*(i+1)), reflect.ValueOf(s).Field(i).Int(), v.Type().Field(i).Name)
+	}
+}
+
+func TestRowsEventParallelDecodeWithoutOptionalMeta(t *testing.T) {
+	// the lazy caches of the table map event must be filled before the goroutines
+	// read them, also when the optional metadata is not logged; run with -race
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(8))
+
+	// CREATE TABLE t (v VARCHAR(20), e ENUM('a', 'b'), s SET('x', 'y'), b BLOB)
+	newTable := func() *TableMapEvent {
+		return &TableMapEvent{
+			TableID:     1,
+			ColumnCount: 4,
+			ColumnType:  []byte{mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_BLOB},
+			ColumnMeta:  []uint16{20, uint16(mysql.MYSQL_TYPE_ENUM)<<8 | 1, uint16(mysql.MYSQL_TYPE_SET)<<8 | 1, 2},
+		}
+	}
+	b := NewRowsEventBuilder(newTable(), WRITE_ROWS_EVENTv2)
+	for i := 0; i < 64; i++ {
+		b.AddRow(strings.Repeat("v", i%20), int64(i%2+1), int64(i%4), []byte{byte(i)})
+	}
+	built, err := b.Build()
+	require.NoError(t, err)
+	data, err := built.Encode()
+	require.NoError(t, err)
+
+	for k := 0; k < 10; k++ {
+		table := newTable()
+		e := &RowsEvent{
+			Version:               2,
+			tableIDSize:           6,
+			tables:                map[uint64]*TableMapEvent{table.TableID: table},
+			eventType:             WRITE_ROWS_EVENTv2,
+			parallelDecodeMinRows: 2,
+			binaryAsBytes:         true,
+			transcodeToUTF8:       true,
+			enumSetWithLabels:     true,
+			binaryAsHex:           true,
+		}
+		require.NoError(t, e.Decode(data))
+		require.Len(t, e.Rows, 64)
+		require.Equal(t, []interface{}{"v", EnumValue{Index: 2}, SetValue{Mask: 1}, "01"}, e.Rows[1])
+	}
+}
+
+func TestValueLength(t *testing.T) {
+	testcases := []struct {
+		data []byte
+		tp   byte
+		meta uint16
+		n    int
+		ok   bool
+	}{
+		{[]byte{0x01}, mysql.MYSQL_TYPE_TINY, 0, 1, true},
+		{[]byte("\x80\x00\x00\x00\x00"), mysql.MYSQL_TYPE_TIME2, 4, 5, true},
+		{[]byte("\x80\x00\x00"), mysql.MYSQL_TYPE_TIME2, 0, 3, true},
+		{[]byte("\x02\x00\x00\x00\x04\x01"), mysql.MYSQL_TYPE_JSON, 4, 6, true},
+		{[]byte("\x03abc"), mysql.MYSQL_TYPE_VARCHAR, 10, 4, true},
+		{[]byte("\x03\x00abc"), mysql.MYSQL_TYPE_VARCHAR, 1000, 5, true},
+		{[]byte("\x02\x00"), mysql.MYSQL_TYPE_STRING, uint16(mysql.MYSQL_TYPE_ENUM)<<8 | 2, 2, true},
+		{[]byte("\x03\x00abc"), mysql.MYSQL_TYPE_STRING, 0xcefc, 5, true},
+		{nil, mysql.MYSQL_TYPE_TIME2, 7, 0, false},
+		{nil, mysql.MYSQL_TYPE_BLOB, 2, 0, false},
+		{nil, mysql.MYSQL_TYPE_VARCHAR, 10, 0, false},
+		{nil, 0xf0, 0, 0, false},
+	}
+	for _, tc := range testcases {
+		n, ok := valueLength(tc.data, tc.tp, tc.meta)
+		require.Equal(t, tc.ok, ok, "type %d", tc.tp)
+		require.Equal(t, tc.n, n, "type %d", tc.tp)
+		if ok {
+			e := &RowsEvent{}
+			_, n, err := e.decodeValue(tc.data, tc.tp, tc.meta, false)
+			require.NoError(t, err)
+			require.Equal(t, tc.n, n)
+		}
+	}
+}
+
+func TestDecodeDataFunc(t *testing.T) {
+	// CREATE TABLE t (id INT, s VARCHAR(10)), the after images have no id
+	table := &TableMapEvent{
+		TableID:     42,
+		ColumnCount: 2,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR},
+		ColumnMeta:  []uint16{0, 10},
+	}
+	e, err := NewRowsEventBuilder(table, UPDATE_ROWS_EVENTv2).
+		SetColumnBitmaps([]byte{0x03}, []byte{0x02}).
+		AddRow(int32(1), "a").AddRow(nil, "b").
+		AddRow(int32(2), nil).AddRow(nil, "c").
+		Build()
+	require.NoError(t, err)
+	data, err := e.Encode()
+	require.NoError(t, err)
+
+	rows := &RowsEvent{
+		tableIDSize: 6,
+		tables:      map[uint64]*TableMapEvent{42: table},
+		Version:     2,
+		eventType:   UPDATE_ROWS_EVENTv2,
+		needBitmap2: true,
+	}
+	require.NoError(t, rows.Decode(data))
+	expected, expectedSkips := rows.Rows, rows.SkippedColumns
+
+	pos, err := rows.DecodeHeader(data)
+	require.NoError(t, err)
+	var got [][]interface{}
+	var skips [][]int
+	var before []interface{}
+	err = rows.DecodeDataFunc(pos, data, func(row []interface{}, skippedColumns []int) error {
+		if len(got)%2 == 0 {
+			if before != nil {
+				// the buffer of the before images is reused
+				require.Same(t, &before[0], &row[0])
+			}
+			before = row
+		} else {
+			// the before image is still valid
+			require.Equal(t, expected[len(got)-1], before)
+		}
+		got = append(got, append([]interface{}(nil), row...))
+		skips = append(skips, append([]int{}, skippedColumns...))
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, expected, got)
+	require.Equal(t, expectedSkips, skips)
+	require.Nil(t, rows.Rows)
+	require.Equal(t, 4, rows.DecodeStats().Rows)
+
+	// an error of f stops decoding
+	stop := fmt.Errorf("stop")
+	n := 0
+	err = rows.DecodeDataFunc(pos, data, func(row []interface{}, skippedColumns []int) error {
+		n++
+		return stop
+	})
+	require.Equal(t, stop, err)
+	require.Equal(t, 1, n)
+}
+
+func BenchmarkDecodeWideRows(b *testing.B) {
+	// a table of 500 INT columns, with 100 rows
+	const columns, count = 500, 100
+	table := &TableMapEvent{TableID: 42, ColumnCount: columns}
+	values := make([]interface{}, columns)
+	for i := range values {
+		table.ColumnType = append(table.ColumnType, mysql.MYSQL_TYPE_LONG)
+		table.ColumnMeta = append(table.ColumnMeta, 0)
+		values[i] = int32(i)
+	}
+	builder := NewRowsEventBuilder(table, WRITE_ROWS_EVENTv2)
+	for i := 0; i < count; i++ {
+		builder.AddRow(values...)
+	}
+	e, err := builder.Build()
+	if err != nil {
+		b.Fatal(err)
+	}
+	data, err := e.Encode()
+	if err != nil {
+		b.Fatal(err)
+	}
+	rows := &RowsEvent{
+		tableIDSize: 6,
+		tables:      map[uint64]*TableMapEvent{42: table},
+		Version:     2,
+		eventType:   WRITE_ROWS_EVENTv2,
+	}
+	pos, err := rows.DecodeHeader(data)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("DecodeData", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := rows.DecodeData(pos, data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("DecodeDataFunc", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			err := rows.DecodeDataFunc(pos, data, func(row []interface{}, skippedColumns []int) error {
+				return nil
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestDecodeTruncatedString(t *testing.T) {
+	e := &RowsEvent{}
+	testcases := []struct {
+		tp   byte
+		meta uint16
+		data []byte
+	}{
+		{mysql.MYSQL_TYPE_VARCHAR, 10, []byte("\x05ab")},
+		{mysql.MYSQL_TYPE_VARCHAR, 10, nil},
+		{mysql.MYSQL_TYPE_VARCHAR, 300, []byte("\x10\x00ab")},
+		{mysql.MYSQL_TYPE_VARCHAR, 300, []byte("\x10")},
+		// CHAR(10)
+		{mysql.MYSQL_TYPE_STRING, uint16(mysql.MYSQL_TYPE_STRING)<<8 | 10, []byte("\x03a")},
+	}
+	for _, tc := range testcases {
+		_, _, err := e.decodeValue(tc.data, tc.tp, tc.meta, false)
+		require.ErrorIs(t, err, ErrTruncatedString, "%q", tc.data)
+	}
+
+	v, n, err := e.decodeValue([]byte("\x02abc"), mysql.MYSQL_TYPE_VARCHAR, 10, false)
+	require.NoError(t, err)
+	require.Equal(t, "ab", v)
+	require.Equal(t, 3, n)
+}
+
+func TestRowsEventWithoutRows(t *testing.T) {
+	table := &TableMapEvent{
+		TableID:     42,
+		ColumnCount: 2,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR},
+		ColumnMeta:  []uint16{0, 10},
+	}
+	for _, eventType := range []EventType{WRITE_ROWS_EVENTv2, UPDATE_ROWS_EVENTv2} {
+		e, err := NewRowsEventBuilder(table, eventType).Build()
+		require.NoError(t, err)
+		data, err := e.Encode()
+		require.NoError(t, err)
+		// only the header and the bitmaps, followed by the checksum
+		data = append(data, 0x01, 0x02, 0x03, 0x04)
+
+		rows := &RowsEvent{
+			tableIDSize:    6,
+			tables:         map[uint64]*TableMapEvent{42: table},
+			Version:        2,
+			eventType:      eventType,
+			needBitmap2:    eventType == UPDATE_ROWS_EVENTv2,
+			ChecksumLength: BinlogChecksumLength,
+		}
+		require.NoError(t, rows.Decode(data))
+		require.Len(t, rows.Rows, 0)
+		require.Len(t, rows.SkippedColumns, 0)
+		require.Equal(t, 0, rows.DecodeStats().Rows)
+
+		pos, err := rows.DecodeHeader(data)
+		require.NoError(t, err)
+		require.Equal(t, len(data)-BinlogChecksumLength, pos)
+		err = rows.DecodeDataFunc(pos, data, func(row []interface{}, skippedColumns []int) error {
+			return fmt.Errorf("unexpected row %v", row)
+		})
+		require.NoError(t, err)
+	}
+}
+
+func TestRowsEventPanicHandler(t *testing.T) {
+	// CREATE TABLE t (id INT, b BLOB)
+	table := &TableMapEvent{
+		TableID:     42,
+		ColumnCount: 2,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_BLOB},
+		ColumnMeta:  []uint16{0, 2},
+	}
+	e, err := NewRowsEventBuilder(table, WRITE_ROWS_EVENTv2).
+		AddRow(int32(1), []byte("a")).AddRow(int32(2), []byte("boom")).
+		Build()
+	require.NoError(t, err)
+	data, err := e.Encode()
+	require.NoError(t, err)
+
+	rows := &RowsEvent{
+		tableIDSize: 6,
+		tables:      map[uint64]*TableMapEvent{42: table},
+		Version:     2,
+		eventType:   WRITE_ROWS_EVENTv2,
+	}
+	rows.blobDecodeFunc = func(col int, r io.Reader) (interface{}, error) {
+		b, _ := io.ReadAll(r)
+		if string(b) == "boom" {
+			panic("boom")
+		}
+		return b, nil
+	}
+
+	// the current error without a handler
+	err = rows.Decode(data)
+	require.ErrorContains(t, err, "parse rows event panic boom")
+
+	var recovered interface{}
+	var partialRows [][]interface{}
+	var stack []byte
+	rows.panicHandler = func(r interface{}, partialEvent *RowsEvent) {
+		recovered = r
+		partialRows = partialEvent.Rows
+		stack = debug.Stack()
+	}
+	err = rows.Decode(data)
+	require.ErrorContains(t, err, "parse rows event panic boom")
+	require.Equal(t, "boom", recovered)
+	require.Equal(t, [][]interface{}{{int32(1), []byte("a")}}, partialRows)
+	require.Contains(t, string(stack), "TestRowsEventPanicHandler.func1")
+
+	recovered = nil
+	pos, err := rows.DecodeHeader(data)
+	require.NoError(t, err)
+	err = rows.DecodeDataFunc(pos, data, func(row []interface{}, skippedColumns []int) error { return nil })
+	require.ErrorContains(t, err, "parse rows event panic boom")
+	require.Equal(t, "boom", recovered)
+}
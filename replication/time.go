@@ -28,26 +28,115 @@ func (t fracTime) String() string {
 	return tt.Format(fracTimeFormat[t.Dec])
 }
 
-func formatZeroTime(frac int, dec int) string {
-	if dec == 0 {
-		return "0000-00-00 00:00:00"
+// TimeWithDec is a DATETIME or TIMESTAMP value decoded with BinlogSyncerConfig.ParseTime
+// and UseTimeWithDec. Unlike time.Time, it keeps the fractional seconds precision of
+// the column, so that it can be formatted with as many digits as the source.
+type TimeWithDec struct {
+	time.Time
+
+	// Dec is the fractional seconds precision of the column, in [0, 6]
+	Dec int
+}
+
+// String returns the value like the DATETIME string values, e.g. "2024-06-01 12:00:00.120"
+// for a DATETIME(3), in the location of Time.
+func (t TimeWithDec) String() string {
+	return t.Format(fracTimeFormat[t.Dec])
+}
+
+// PartialDate is a DATE or DATETIME value with a zero month or day, which
+// MySQL allows unless NO_ZERO_IN_DATE is set but time.Time can't represent.
+type PartialDate struct {
+	Year, Month, Day     int
+	Hour, Minute, Second int
+	Microsecond          int
+
+	// Dec is the fractional seconds precision of DATETIME, in [0, 6]
+	Dec int
+	// DateOnly is true for DATE values
+	DateOnly bool
+}
+
+func (d PartialDate) String() string {
+	if d.DateOnly {
+		return fmt.Sprintf("%04d-%02d-%02d", d.Year, d.Month, d.Day)
 	}
+	return formatBeforeUnixZeroTime(d.Year, d.Month, d.Day, d.Hour, d.Minute, d.Second, d.Microsecond, d.Dec)
+}
 
-	s := fmt.Sprintf("0000-00-00 00:00:00.%06d", frac)
+// MySQLTime is a TIME value, which ranges from '-838:59:59.000000' to
+// '838:59:59.000000' and so can't be represented by time.Time. Unlike the
+// string form it keeps the sign and the components for arithmetic.
+type MySQLTime struct {
+	Negative     bool
+	Hours        int
+	Minutes      int
+	Seconds      int
+	Microseconds int
 
-	// dec must < 6, if frac is 924000, but dec is 3, we must output 924 here.
-	return s[0 : len(s)-(6-dec)]
+	// Dec is the fractional seconds precision of TIME, in [0, 6]
+	Dec int
 }
 
-func formatBeforeUnixZeroTime(year, month, day, hour, minute, second, frac, dec int) string {
-	if dec == 0 {
-		return fmt.Sprintf("%04d-%02d-%02d %02d:%02d:%02d", year, month, day, hour, minute, second)
+// String returns the value like the TIME string values, e.g. "-838:59:58.12".
+// The fractional part is only printed if it is not zero.
+func (t MySQLTime) String() string {
+	sign := ""
+	if t.Negative {
+		sign = "-"
 	}
+	s := fmt.Sprintf("%s%02d:%02d:%02d", sign, t.Hours, t.Minutes, t.Seconds)
+	if t.Microseconds != 0 {
+		s += formatFrac(t.Microseconds, t.Dec)
+	}
+	return s
+}
+
+// Duration returns the value as a time.Duration.
+func (t MySQLTime) Duration() time.Duration {
+	d := time.Duration(t.Hours)*time.Hour +
+		time.Duration(t.Minutes)*time.Minute +
+		time.Duration(t.Seconds)*time.Second +
+		time.Duration(t.Microseconds)*time.Microsecond
+	if t.Negative {
+		return -d
+	}
+	return d
+}
 
-	s := fmt.Sprintf("%04d-%02d-%02d %02d:%02d:%02d.%06d", year, month, day, hour, minute, second, frac)
+func formatZeroTime(frac int, dec int) string {
+	return "0000-00-00 00:00:00" + formatFrac(frac, dec)
+}
 
-	// dec must < 6, if frac is 924000, but dec is 3, we must output 924 here.
-	return s[0 : len(s)-(6-dec)]
+// isTimeOfDayOutOfRange reports whether the time of a DATETIME value can't be
+// represented by time.Time, which would normalize it into the next minute, hour
+// or day. MySQL can store a leap second 23:59:60, e.g. when the value comes from
+// a statement-based replica or a lax sql_mode, and a corrupted event anything.
+func isTimeOfDayOutOfRange(hour, minute, second int) bool {
+	return hour > 23 || minute > 59 || second > 59
+}
+
+func formatBeforeUnixZeroTime(year, month, day, hour, minute, second, frac, dec int) string {
+	return fmt.Sprintf("%04d-%02d-%02d %02d:%02d:%02d", year, month, day, hour, minute, second) + formatFrac(frac, dec)
+}
+
+// formatFrac formats the microseconds frac with dec digits, truncating the
+// remaining digits like fracTime.String(), e.g. frac 123456 with dec 5 is ".12345".
+// It returns "" if dec is 0.
+func formatFrac(frac int, dec int) string {
+	if dec <= 0 {
+		return ""
+	}
+	if dec > 6 {
+		dec = 6
+	}
+	// frac can't exceed 999999 in a valid event, keep the lowest digits
+	// instead of printing more than dec digits for a corrupted one
+	frac %= 1000000
+	for i := dec; i < 6; i++ {
+		frac /= 10
+	}
+	return fmt.Sprintf(".%0*d", dec, frac)
 }
 
 func microSecTimestampToTime(ts uint64) time.Time {
@@ -40,6 +40,11 @@ func TestTime(tt *testing.T) {
 		{123000, 3, "0000-00-00 00:00:00.123"},
 		{123, 6, "0000-00-00 00:00:00.000123"},
 		{123000, 6, "0000-00-00 00:00:00.123000"},
+		{123000, 5, "0000-00-00 00:00:00.12300"},
+		{123456, 5, "0000-00-00 00:00:00.12345"},
+		{999999, 5, "0000-00-00 00:00:00.99999"},
+		{9, 5, "0000-00-00 00:00:00.00000"},
+		{10, 5, "0000-00-00 00:00:00.00001"},
 	}
 
 	for _, t := range zeroTbls {
@@ -63,3 +68,12 @@ func TestTimeStringLocation(tt *testing.T) {
 	}
 	require.Equal(tt, "2018-07-30 15:00:00", t.String())
 }
+
+func TestFormatFrac(t *testing.T) {
+	for dec := 0; dec <= 6; dec++ {
+		for _, frac := range []int{0, 1, 9, 10, 99999, 100000, 123000, 123456, 999999} {
+			ft := fracTime{Time: time.Date(2000, 1, 1, 0, 0, 0, frac*1000, time.UTC), Dec: dec}
+			require.Equal(t, ft.String(), formatBeforeUnixZeroTime(2000, 1, 1, 0, 0, 0, frac, dec), "frac %d dec %d", frac, dec)
+		}
+	}
+}